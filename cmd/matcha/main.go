@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/l-orlov/matcha/internal/app"
@@ -8,6 +9,61 @@ import (
 
 const envConfigPath = "CONFIG_PATH"
 
+const usage = `usage: matcha [command]
+
+commands:
+  serve            run API server, it is default command
+  migrate          manage database schema, run matcha migrate for details
+  create-admin     create user with admin role
+  reset-password   set user password and revoke user sessions
+  purge-deleted    purge accounts which deletion grace period is over
+  seed             generate fake profiles for load testing and demos
+  config validate  check config without connecting to dependencies
+
+config path is read from CONFIG_PATH`
+
 func main() {
-	app.Run(os.Getenv(envConfigPath))
+	configPath := os.Getenv(envConfigPath)
+
+	command, args := "serve", []string(nil)
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		if len(args) != 0 {
+			exit(usage)
+		}
+
+		app.Run(configPath)
+	case "migrate":
+		err = app.Migrate(configPath, args)
+	case "create-admin":
+		err = app.CreateAdmin(configPath, args)
+	case "reset-password":
+		err = app.ResetPassword(configPath, args)
+	case "purge-deleted":
+		err = app.PurgeDeleted(configPath, args)
+	case "seed":
+		err = app.Seed(configPath, args)
+	case "config":
+		if len(args) != 1 || args[0] != "validate" {
+			exit(usage)
+		}
+
+		err = app.ValidateConfig(configPath)
+	default:
+		exit(usage)
+	}
+
+	if err != nil {
+		exit(err)
+	}
+}
+
+func exit(err interface{}) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
 }
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/service"
+	"github.com/pkg/errors"
+)
+
+const (
+	createAdminUsage = `usage: matcha create-admin -email EMAIL -username USERNAME -first-name NAME -last-name NAME
+
+creates user with confirmed email and admin role, password is read from the first line of stdin`
+	resetPasswordUsage = `usage: matcha reset-password -username USERNAME | -email EMAIL
+
+sets user password and revokes all user sessions, password is read from the first line of stdin`
+	purgeDeletedUsage = `usage: matcha purge-deleted
+
+purges all accounts which deletion grace period is over`
+)
+
+// CreateAdmin creates admin user.
+func CreateAdmin(configPath string, args []string) error {
+	var user models.UserToCreate
+
+	fs := newFlagSet("create-admin")
+	fs.StringVar(&user.Email, "email", "", "")
+	fs.StringVar(&user.Username, "username", "", "")
+	fs.StringVar(&user.FirstName, "first-name", "", "")
+	fs.StringVar(&user.LastName, "last-name", "", "")
+	if err := parseFlags(fs, args, createAdminUsage); err != nil {
+		return err
+	}
+
+	if user.Email == "" || user.Username == "" || user.FirstName == "" || user.LastName == "" {
+		return errors.New(createAdminUsage)
+	}
+
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		return err
+	}
+	user.Password = password
+
+	d, err := newDependencies(configPath)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	id, err := d.svc.User.CreateAdmin(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("admin %s is created with id %d\n", user.Username, id)
+
+	return nil
+}
+
+// ResetPassword sets password of user found by username or email.
+func ResetPassword(configPath string, args []string) error {
+	var username, email string
+
+	fs := newFlagSet("reset-password")
+	fs.StringVar(&username, "username", "", "")
+	fs.StringVar(&email, "email", "", "")
+	if err := parseFlags(fs, args, resetPasswordUsage); err != nil {
+		return err
+	}
+
+	if (username == "") == (email == "") {
+		return errors.New(resetPasswordUsage)
+	}
+
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	d, err := newDependencies(configPath)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var user *models.User
+	if username != "" {
+		user, err = d.svc.User.GetUserByUsername(ctx, username)
+	} else {
+		user, err = d.svc.User.GetUserByEmail(ctx, email)
+	}
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return service.ErrUserNotFound
+	}
+
+	if err := d.svc.User.ResetUserPassword(ctx, user.ID, password); err != nil {
+		return err
+	}
+
+	fmt.Printf("password of user %s is reset\n", user.Username)
+
+	return nil
+}
+
+// PurgeDeleted purges deleted accounts batch by batch until none is left.
+func PurgeDeleted(configPath string, args []string) error {
+	if len(args) != 0 {
+		return errors.New(purgeDeletedUsage)
+	}
+
+	d, err := newDependencies(configPath)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// accounts which failed to be purged are selected again, so batch of them ends the loop
+	var purgedNum int
+	for {
+		num, err := d.svc.AccountDeletion.PurgeDeletedAccounts(ctx)
+		if err != nil {
+			return err
+		}
+
+		purgedNum += num
+		if num == 0 {
+			break
+		}
+	}
+
+	fmt.Printf("purged accounts: %d\n", purgedNum)
+
+	return ctx.Err()
+}
+
+// ValidateConfig loads config and checks it without connecting to dependencies.
+func ValidateConfig(configPath string) error {
+	cfg, err := config.Init(configPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to init config")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return errors.Wrap(err, "not valid config")
+	}
+
+	fmt.Println("config is valid")
+
+	return nil
+}
+
+// newFlagSet creates flag set which does not print anything, errors are returned with command usage.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string, usage string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return errors.New(usage)
+		}
+
+		return errors.Errorf("%v\n%s", err, usage)
+	}
+
+	if fs.NArg() != 0 {
+		return errors.New(usage)
+	}
+
+	return nil
+}
+
+// readPassword reads password from the first line, so it is not visible in process list and shell history.
+func readPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", errors.Wrap(err, "failed to read password")
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("empty password")
+	}
+
+	return password, nil
+}
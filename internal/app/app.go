@@ -10,75 +10,70 @@ import (
 
 	"github.com/l-orlov/matcha/internal/config"
 	"github.com/l-orlov/matcha/internal/handler"
-	"github.com/l-orlov/matcha/internal/repository"
-	"github.com/l-orlov/matcha/internal/repository/postgres"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository/local"
 	"github.com/l-orlov/matcha/internal/server"
 	"github.com/l-orlov/matcha/internal/service"
-	"github.com/l-orlov/task-tracker/pkg/logger"
-	"github.com/l-orlov/task-tracker/pkg/mailer"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// Run initializes whole application.
+// Run initializes whole application and serves API until it is interrupted.
 func Run(configPath string) {
-	cfg, err := config.Init(configPath)
+	d, err := newDependencies(configPath)
 	if err != nil {
-		log.Fatalf("failed to init config: %v", err)
+		log.Fatal(err)
 	}
+	defer d.Close()
 
-	lg, err := logger.New(cfg.Logger.Level, cfg.Logger.Format)
+	cfg, lg, repo, svc := d.cfg, d.log, d.repo, d.svc
+
+	// fresh deployment has no bucket, also storage connectivity is checked before serving
+	err = repo.Storage.ProvisionBucket(context.Background(), cfg.Storage.Bucket, storageBucketSettings(cfg.Storage))
 	if err != nil {
-		log.Fatalf("failed to init logger: %v", err)
+		log.Fatalf("failed to provision storage bucket: %v", err)
 	}
 
-	// Dependencies
-	db, err := postgres.ConnectToDB(cfg.PostgresDB)
-	if err != nil {
-		lg.Fatalf("failed to connect to db: %v", err)
+	if cfg.AccountDeletion.PurgeInterval.Duration() > 0 {
+		purger := service.NewAccountPurger(
+			logrus.NewEntry(lg).WithFields(logrus.Fields{"source": "account-purger"}),
+			svc.AccountDeletion, cfg.AccountDeletion.PurgeInterval.Duration(),
+		)
+		purger.Start()
+		defer purger.Shutdown()
 	}
-	defer func() {
-		if err = db.Close(); err != nil {
-			lg.Errorf("failed to close db: %v", err)
-		}
-	}()
 
-	if cfg.PostgresDB.MigrationMode {
-		if err = postgres.MigrateSchema(db.DB, cfg.PostgresDB); err != nil {
-			log.Fatalf("failed to do migration: %v", err)
-		}
+	if cfg.StorageReconciliation.Interval.Duration() > 0 {
+		reconciler := service.NewStorageReconciler(
+			logrus.NewEntry(lg).WithFields(logrus.Fields{"source": "storage-reconciler"}),
+			svc.StorageReconciliation, cfg.StorageReconciliation.Interval.Duration(),
+		)
+		reconciler.Start()
+		defer reconciler.Shutdown()
 	}
 
-	m := mailer.New(
-		mailer.Config{
-			Host:              cfg.Mailer.ServerAddress.Host,
-			Port:              cfg.Mailer.ServerAddress.Port,
-			Username:          cfg.Mailer.Username,
-			Password:          cfg.Mailer.Password.String(),
-			Timeout:           cfg.Mailer.Timeout.Duration(),
-			MsgToSendChanSize: cfg.Mailer.MsgToSendChanSize,
-			WorkersNum:        cfg.Mailer.WorkersNum,
-		},
-		logrus.NewEntry(lg).WithFields(logrus.Fields{"source": "mailer"}),
+	dataExportWorker := service.NewDataExportWorker(
+		logrus.NewEntry(lg).WithFields(logrus.Fields{"source": "data-export-worker"}),
+		svc.DataExport, cfg.DataExport.WorkersNum,
 	)
-	m.Init()
-	defer m.Shutdown()
+	dataExportWorker.Start()
+	defer dataExportWorker.Shutdown()
 
-	// Repo, Service & API Handlers
-	repo, err := repository.NewRepository(cfg, lg, db)
-	if err != nil {
-		log.Fatalf("failed to create repository: %v", err)
+	// local storage serves its signed URLs through application
+	var fileServer http.Handler
+	if storage, ok := repo.Storage.(*local.StorageLocal); ok {
+		fileServer = storage
 	}
 
-	svc, err := service.NewService(cfg, lg, repo, m)
+	h := handler.New(cfg, lg, svc, fileServer)
+
+	routes, err := h.InitRoutes()
 	if err != nil {
-		log.Fatalf("failed to create service: %v", err)
+		log.Fatalf("failed to init routes: %v", err)
 	}
 
-	h := handler.New(cfg, lg, svc)
-
 	// HTTP Server
-	srv := server.New(cfg.Port, h.InitRoutes())
+	srv := server.New(cfg.Port, routes)
 	go func() {
 		if err = srv.Run(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			lg.Fatalf("error occurred while running http server: %v", err)
@@ -98,3 +93,18 @@ func Run(configPath string) {
 		lg.Errorf("failed to shut down: %v", err)
 	}
 }
+
+func storageBucketSettings(cfg config.Storage) models.StorageBucketSettings {
+	rules := make([]models.StorageLifecycleRule, len(cfg.LifecycleRules))
+	for i, rule := range cfg.LifecycleRules {
+		rules[i] = models.StorageLifecycleRule{
+			Prefix:         rule.Prefix,
+			ExpirationDays: rule.ExpirationDays,
+		}
+	}
+
+	return models.StorageBucketSettings{
+		PublicReadPrefixes: cfg.PublicReadPrefixes,
+		LifecycleRules:     rules,
+	}
+}
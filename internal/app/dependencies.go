@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/l-orlov/matcha/internal/repository/postgres"
+	"github.com/l-orlov/matcha/internal/service"
+	"github.com/l-orlov/task-tracker/pkg/logger"
+	"github.com/l-orlov/task-tracker/pkg/mailer"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// dependencies are connections, repository and services shared by server and admin commands.
+type dependencies struct {
+	cfg    *config.Config
+	log    *logrus.Logger
+	db     *sqlx.DB
+	mailer mailer.Mailer
+	repo   *repository.Repository
+	svc    *service.Service
+}
+
+// newDependencies loads config and wires services. Dependencies must be closed by caller.
+func newDependencies(configPath string) (*dependencies, error) {
+	cfg, err := config.Init(configPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init config")
+	}
+
+	lg, err := logger.New(cfg.Logger.Level, cfg.Logger.Format)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init logger")
+	}
+
+	db, err := postgres.ConnectToDB(cfg.PostgresDB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to db")
+	}
+
+	d := &dependencies{
+		cfg: cfg,
+		log: lg,
+		db:  db,
+	}
+
+	if err := d.init(); err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *dependencies) init() error {
+	// schema is migrated in migration mode, otherwise service must not start on dirty schema
+	if err := postgres.MigrateSchema(context.Background(), d.cfg.PostgresDB); err != nil {
+		return errors.Wrap(err, "failed to do migration")
+	}
+
+	d.mailer = mailer.New(
+		mailer.Config{
+			Host:              d.cfg.Mailer.ServerAddress.Host,
+			Port:              d.cfg.Mailer.ServerAddress.Port,
+			Username:          d.cfg.Mailer.Username,
+			Password:          d.cfg.Mailer.Password.String(),
+			Timeout:           d.cfg.Mailer.Timeout.Duration(),
+			MsgToSendChanSize: d.cfg.Mailer.MsgToSendChanSize,
+			WorkersNum:        d.cfg.Mailer.WorkersNum,
+		},
+		logrus.NewEntry(d.log).WithFields(logrus.Fields{"source": "mailer"}),
+	)
+	d.mailer.Init()
+
+	var err error
+	d.repo, err = repository.NewRepository(d.cfg, d.log, d.db)
+	if err != nil {
+		return errors.Wrap(err, "failed to create repository")
+	}
+
+	d.svc, err = service.NewService(d.cfg, d.log, d.repo, d.mailer)
+	if err != nil {
+		return errors.Wrap(err, "failed to create service")
+	}
+
+	return nil
+}
+
+// Close waits for queued emails to be sent and closes db.
+func (d *dependencies) Close() {
+	if d.mailer != nil {
+		d.mailer.Shutdown()
+	}
+
+	if err := d.db.Close(); err != nil {
+		d.log.Errorf("failed to close db: %v", err)
+	}
+}
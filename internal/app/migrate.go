@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/repository/postgres"
+	"github.com/pkg/errors"
+)
+
+const migrateUsage = `usage: matcha migrate <command>
+
+commands:
+  up [N]       apply N pending migrations, all of them by default
+  down [N]     roll back N applied migrations, one by default
+  to VERSION   apply or roll back migrations up to version
+  status       print schema version and pending migrations
+  force VERSION
+               set version and clear dirty flag without running migrations,
+               -1 means that no migration is applied`
+
+// Migrate runs migration command with args.
+func Migrate(configPath string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	cfg, err := config.Init(configPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to init config")
+	}
+
+	migrator, err := postgres.NewMigrator(cfg.PostgresDB)
+	if err != nil {
+		return errors.Wrap(err, "failed to create migrator")
+	}
+	defer func() { _ = migrator.Close() }()
+
+	// interrupt stops waiting for migration lock
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "up":
+		steps, err := parseMigrationSteps(args, 0)
+		if err != nil {
+			return err
+		}
+
+		if err := migrator.Up(ctx, steps); err != nil {
+			return err
+		}
+	case "down":
+		steps, err := parseMigrationSteps(args, 1)
+		if err != nil {
+			return err
+		}
+
+		if err := migrator.Down(ctx, steps); err != nil {
+			return err
+		}
+	case "to":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+
+		version, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "not valid version")
+		}
+
+		if err := migrator.To(ctx, uint(version)); err != nil {
+			return err
+		}
+	case "force":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+
+		version, err := strconv.Atoi(args[0])
+		if err != nil || version < -1 {
+			return errors.New("not valid version")
+		}
+
+		if err := migrator.Force(ctx, version); err != nil {
+			return err
+		}
+	case "status":
+		if len(args) != 0 {
+			return errors.New(migrateUsage)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	printMigrationStatus(os.Stdout, status)
+
+	return nil
+}
+
+// parseMigrationSteps parses optional number of migrations.
+func parseMigrationSteps(args []string, defaultSteps int) (int, error) {
+	switch len(args) {
+	case 0:
+		return defaultSteps, nil
+	case 1:
+		steps, err := strconv.Atoi(args[0])
+		if err != nil || steps <= 0 {
+			return 0, errors.New("number of migrations must be positive")
+		}
+
+		return steps, nil
+	default:
+		return 0, errors.New(migrateUsage)
+	}
+}
+
+func printMigrationStatus(w io.Writer, status *postgres.MigrationStatus) {
+	fmt.Fprintf(w, "version: %d\n", status.Version)
+	fmt.Fprintf(w, "dirty: %t\n", status.Dirty)
+	fmt.Fprintf(w, "latest: %d\n", status.Latest)
+
+	pending := make([]string, len(status.Pending))
+	for i, version := range status.Pending {
+		pending[i] = strconv.FormatUint(uint64(version), 10)
+	}
+
+	fmt.Fprintf(w, "pending: %s\n", strings.Join(pending, ", "))
+}
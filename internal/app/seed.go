@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/l-orlov/matcha/internal/service"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const seedUsage = `usage: matcha seed [-count N] [-seed N] [-offset N] [-cities CITY,...] [-radius KM]
+                   [-avatars=false] [-batch N] [-workers N]
+
+generates fake profiles, password of all of them is read from the first line of stdin.
+Profiles are the same for the same seed, offset is index of the first profile,
+so next run must start with offset after the previous one.
+
+cities: `
+
+// Seed generates fake profiles for load testing and demos.
+func Seed(configPath string, args []string) error {
+	params := service.SeedParams{
+		Seed:       1,
+		Count:      1000,
+		BatchSize:  1000,
+		WorkersNum: runtime.NumCPU(),
+		Radius:     30,
+		Avatars:    true,
+	}
+	var cities string
+
+	usage := seedUsage + strings.Join(service.SeedCities(), ", ")
+
+	fs := newFlagSet("seed")
+	fs.Int64Var(&params.Seed, "seed", params.Seed, "")
+	fs.IntVar(&params.Offset, "offset", params.Offset, "")
+	fs.IntVar(&params.Count, "count", params.Count, "")
+	fs.IntVar(&params.BatchSize, "batch", params.BatchSize, "")
+	fs.IntVar(&params.WorkersNum, "workers", params.WorkersNum, "")
+	fs.StringVar(&cities, "cities", "moscow", "")
+	fs.Float64Var(&params.Radius, "radius", params.Radius, "")
+	fs.BoolVar(&params.Avatars, "avatars", params.Avatars, "")
+	if err := parseFlags(fs, args, usage); err != nil {
+		return err
+	}
+	params.Cities = strings.Split(cities, ",")
+
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		return err
+	}
+	params.Password = password
+
+	d, err := newDependencies(configPath)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if params.Avatars {
+		err = d.repo.Storage.ProvisionBucket(ctx, d.cfg.Storage.Bucket, storageBucketSettings(d.cfg.Storage))
+		if err != nil {
+			return errors.Wrap(err, "failed to provision storage bucket")
+		}
+	}
+
+	seed := service.NewSeedService(
+		logrus.NewEntry(d.log).WithFields(logrus.Fields{"source": "seed-svc"}),
+		d.cfg.FilePathTemplates, d.cfg.Storage.Bucket, d.repo, service.NewPictureProcessor(d.cfg.PictureProcessing),
+	)
+
+	seededNum, err := seed.Seed(ctx, params)
+	fmt.Printf("seeded profiles: %d\n", seededNum)
+
+	return err
+}
@@ -4,37 +4,83 @@ import (
 	cr "github.com/l-orlov/task-tracker/pkg/configreader"
 )
 
+const (
+	TokenSourceCookie = "cookie"
+	TokenSourceHeader = "header"
+
+	StorageBackendMinio  = "minio"
+	StorageBackendLocal  = "local"
+	StorageBackendMemory = "memory"
+
+	PictureURLModeSigned    = "signed"
+	PictureURLModePresigned = "presigned"
+
+	PictureModeratorBlocklist = "blocklist"
+	PictureModeratorHTTP      = "http"
+
+	TxIsolationReadCommitted  = "readCommitted"
+	TxIsolationRepeatableRead = "repeatableRead"
+	TxIsolationSerializable   = "serializable"
+)
+
 type (
 	Config struct {
-		Port               string            `yaml:"port" env:"PORT,default=8080"`
-		Logger             Logger            `yaml:"logger"`
-		PostgresDB         PostgresDB        `yaml:"postgresDB"`
-		Redis              Redis             `yaml:"redis"`
-		JWT                JWT               `yaml:"jwt"`
-		Cookie             Cookie            `yaml:"cookie"`
-		UserBlocking       UserBlocking      `yaml:"userBlocking"`
-		Verification       Verification      `yaml:"verification"`
-		Mailer             Mailer            `yaml:"mailer"`
-		Minio              Minio             `yaml:"minio"`
-		FilePathTemplates  FilePathTemplates `yaml:"filePathTemplates"`
-		MaxUserPicturesNum int               `yaml:"maxUserPicturesNum"`
+		Port                  string                `yaml:"port" env:"PORT,default=8080"`
+		TrustedProxies        []string              `yaml:"trustedProxies"`
+		Logger                Logger                `yaml:"logger"`
+		PostgresDB            PostgresDB            `yaml:"postgresDB"`
+		Redis                 Redis                 `yaml:"redis"`
+		JWT                   JWT                   `yaml:"jwt"`
+		Authorization         Authorization         `yaml:"authorization"`
+		Cookie                Cookie                `yaml:"cookie"`
+		UserBlocking          UserBlocking          `yaml:"userBlocking"`
+		LoginLockout          LoginLockout          `yaml:"loginLockout"`
+		LoginAlerts           LoginAlerts           `yaml:"loginAlerts"`
+		MagicLinkLimit        MagicLinkLimit        `yaml:"magicLinkLimit"`
+		RateLimit             RateLimit             `yaml:"rateLimit"`
+		Verification          Verification          `yaml:"verification"`
+		TwoFactor             TwoFactor             `yaml:"twoFactor"`
+		OAuth                 OAuth                 `yaml:"oauth"`
+		WebAuthn              WebAuthn              `yaml:"webAuthn"`
+		Mailer                Mailer                `yaml:"mailer"`
+		Storage               Storage               `yaml:"storage"`
+		Minio                 Minio                 `yaml:"minio"`
+		FilePathTemplates     FilePathTemplates     `yaml:"filePathTemplates"`
+		MaxUserPicturesNum    int                   `yaml:"maxUserPicturesNum"`
+		PictureProcessing     PictureProcessing     `yaml:"pictureProcessing"`
+		PictureUpload         PictureUpload         `yaml:"pictureUpload"`
+		PictureURLs           PictureURLs           `yaml:"pictureURLs"`
+		PictureModeration     PictureModeration     `yaml:"pictureModeration"`
+		PictureDuplicates     PictureDuplicates     `yaml:"pictureDuplicates"`
+		AccountDeletion       AccountDeletion       `yaml:"accountDeletion"`
+		DataExport            DataExport            `yaml:"dataExport"`
+		StorageReconciliation StorageReconciliation `yaml:"storageReconciliation"`
+		Pagination            Pagination            `yaml:"pagination"`
 	}
 	Logger struct {
 		Level  string `yaml:"level" env:"LOGGER_LEVEL,default=info"`
 		Format string `yaml:"format" env:"LOGGER_FORMAT,default=default"`
 	}
 	PostgresDB struct {
-		Address         cr.AddressConfig  `yaml:"address" env:"PG_ADDRESS,default=0.0.0.0:5432"`
-		User            string            `yaml:"user" env:"PG_USER,default=postgres"`
-		Password        string            `yaml:"password" env:"PG_PASSWORD,default=123"`
-		Database        string            `yaml:"name" env:"PG_DATABASE,default=postgres"`
-		SSLMode         string            `yaml:"sslmode" env:"PG_SSL_MODE,default=disable"`
-		ConnMaxLifetime cr.DurationConfig `yaml:"connMaxLifetime"`
-		MaxOpenConns    int               `yaml:"maxOpenConns"`
-		MaxIdleConns    int               `yaml:"maxIdleConns"`
-		Timeout         cr.DurationConfig `yaml:"timeout"`
-		MigrationMode   bool              `yaml:"migrationMode"`
-		MigrationDir    string            `yaml:"migrationDir"`
+		Address              cr.AddressConfig     `yaml:"address" env:"PG_ADDRESS,default=0.0.0.0:5432"`
+		User                 string               `yaml:"user" env:"PG_USER,default=postgres"`
+		Password             string               `yaml:"password" env:"PG_PASSWORD,default=123"`
+		Database             string               `yaml:"name" env:"PG_DATABASE,default=postgres"`
+		SSLMode              string               `yaml:"sslmode" env:"PG_SSL_MODE,default=disable"`
+		ConnMaxLifetime      cr.DurationConfig    `yaml:"connMaxLifetime"`
+		MaxOpenConns         int                  `yaml:"maxOpenConns"`
+		MaxIdleConns         int                  `yaml:"maxIdleConns"`
+		Timeout              cr.DurationConfig    `yaml:"timeout"`
+		MigrationMode        bool                 `yaml:"migrationMode"`
+		MigrationLockTimeout cr.DurationConfig    `yaml:"migrationLockTimeout"`
+		Transactions         PostgresTransactions `yaml:"transactions"`
+	}
+	// PostgresTransactions is config of transactions started by services. Transaction is retried
+	// up to MaxRetries times on serialization failure or deadlock, delay is doubled on every retry.
+	PostgresTransactions struct {
+		Isolation  string            `yaml:"isolation"`
+		MaxRetries int               `yaml:"maxRetries"`
+		RetryDelay cr.DurationConfig `yaml:"retryDelay"`
 	}
 	Redis struct {
 		Address     cr.AddressConfig  `yaml:"address" env:"REDIS_ADDRESS,default=0.0.0.0:6379"`
@@ -47,6 +93,12 @@ type (
 		AccessTokenLifetime  cr.DurationConfig `yaml:"accessTokenLifetime"`
 		RefreshTokenLifetime cr.DurationConfig `yaml:"refreshTokenLifetime"`
 		SigningKey           cr.StdBase64      `yaml:"signingKey" env:"JWT_SIGNING_KEY,default=dGVzdA=="`
+		Issuer               string            `yaml:"issuer"`
+		Audience             string            `yaml:"audience"`
+	}
+	Authorization struct {
+		// TokenSourcePrecedence is used when request has both cookies and Authorization header.
+		TokenSourcePrecedence string `yaml:"tokenSourcePrecedence"`
 	}
 	Cookie struct {
 		HashKey  cr.StdBase64 `yaml:"hashKey" env:"COOKIE_HASH_KEY,default=dGVzdA=="`
@@ -57,9 +109,85 @@ type (
 		Lifetime  cr.DurationConfig `yaml:"lifetime"`
 		MaxErrors int               `yaml:"maxErrors"`
 	}
+	// LoginLockout is config of locking login by account and by IP after failed attempts.
+	// Lock duration starts with BaseLockout and doubles with every next failure up to MaxLockout.
+	LoginLockout struct {
+		FailuresLifetime   cr.DurationConfig `yaml:"failuresLifetime"`
+		AccountMaxFailures int               `yaml:"accountMaxFailures"`
+		IPMaxFailures      int               `yaml:"ipMaxFailures"`
+		BaseLockout        cr.DurationConfig `yaml:"baseLockout"`
+		MaxLockout         cr.DurationConfig `yaml:"maxLockout"`
+	}
+	LoginAlerts struct {
+		// CountryHeader is header with client country code set by proxy or CDN, e.g. CF-IPCountry.
+		CountryHeader string `yaml:"countryHeader"`
+	}
+	MagicLinkLimit struct {
+		Window      cr.DurationConfig `yaml:"window"`
+		MaxRequests int               `yaml:"maxRequests"`
+	}
+	// RateLimit is config of requests rate limiting. Rule with zero MaxRequests is disabled.
+	RateLimit struct {
+		IP   RateLimitRule `yaml:"ip"`
+		User RateLimitRule `yaml:"user"`
+		// Routes are limits per client IP keyed by method and route path, e.g. "POST /auth/sign-in".
+		Routes map[string]RateLimitRule `yaml:"routes"`
+	}
+	RateLimitRule struct {
+		Window      cr.DurationConfig `yaml:"window"`
+		MaxRequests int               `yaml:"maxRequests"`
+	}
 	Verification struct {
 		EmailConfirmTokenLifetime         cr.DurationConfig `yaml:"emailConfirmTokenLifetime"`
 		PasswordResetConfirmTokenLifetime cr.DurationConfig `yaml:"passwordResetConfirmTokenLifetime"`
+		TwoFactorChallengeTokenLifetime   cr.DurationConfig `yaml:"twoFactorChallengeTokenLifetime"`
+		MagicLinkTokenLifetime            cr.DurationConfig `yaml:"magicLinkTokenLifetime"`
+		LoginAlertTokenLifetime           cr.DurationConfig `yaml:"loginAlertTokenLifetime"`
+	}
+	// TwoFactor is config of TOTP two-factor authentication.
+	// Challenge token is invalidated after ChallengeMaxAttempts attempts to enter code.
+	TwoFactor struct {
+		Issuer               string       `yaml:"issuer"`
+		EncryptionKey        cr.StdBase64 `yaml:"encryptionKey" env:"TWO_FACTOR_ENCRYPTION_KEY,default=dGVzdA=="`
+		RecoveryCodesNum     int          `yaml:"recoveryCodesNum"`
+		ChallengeMaxAttempts int          `yaml:"challengeMaxAttempts"`
+	}
+	OAuth struct {
+		StateLifetime cr.DurationConfig        `yaml:"stateLifetime"`
+		Timeout       cr.DurationConfig        `yaml:"timeout"`
+		Providers     map[string]OAuthProvider `yaml:"providers"`
+	}
+	// OAuthProvider is config of OAuth2 provider. Endpoints are discovered by Issuer
+	// for OpenID Connect providers, else they must be set explicitly.
+	// Claims are names of fields in id token and user info response. Provider is disabled until ClientID is set.
+	// EmailsURL lists user emails with primary and verified flags like GitHub does,
+	// primary verified email from it replaces email of user info.
+	OAuthProvider struct {
+		ClientID           string   `yaml:"clientID"`
+		ClientSecret       string   `yaml:"clientSecret"`
+		RedirectURL        string   `yaml:"redirectURL"`
+		Scopes             []string `yaml:"scopes"`
+		Issuer             string   `yaml:"issuer"`
+		AuthURL            string   `yaml:"authURL"`
+		TokenURL           string   `yaml:"tokenURL"`
+		UserInfoURL        string   `yaml:"userInfoURL"`
+		EmailsURL          string   `yaml:"emailsURL"`
+		SubjectClaim       string   `yaml:"subjectClaim"`
+		EmailClaim         string   `yaml:"emailClaim"`
+		EmailVerifiedClaim string   `yaml:"emailVerifiedClaim"`
+		UsernameClaim      string   `yaml:"usernameClaim"`
+		FirstNameClaim     string   `yaml:"firstNameClaim"`
+		LastNameClaim      string   `yaml:"lastNameClaim"`
+		// TrustEmail marks emails as verified for providers that do not return verification claim.
+		// It must be set only for providers which do not let users set unverified email,
+		// otherwise someone else's account could be linked by its email.
+		TrustEmail bool `yaml:"trustEmail"`
+	}
+	WebAuthn struct {
+		RPDisplayName   string            `yaml:"rpDisplayName"`
+		RPID            string            `yaml:"rpID" env:"WEBAUTHN_RP_ID,default=localhost"`
+		RPOrigin        string            `yaml:"rpOrigin" env:"WEBAUTHN_RP_ORIGIN,default=http://localhost:8080"`
+		SessionLifetime cr.DurationConfig `yaml:"sessionLifetime"`
 	}
 	Mailer struct {
 		ServerAddress     cr.AddressConfig  `yaml:"serverAddress" env:"EMAIL_SERVER_ADDRESS,default=smtp.gmail.com:587"`
@@ -70,6 +198,30 @@ type (
 		MsgToSendChanSize int               `yaml:"msgToSendChanSize"`
 		WorkersNum        int               `yaml:"workersNum"`
 	}
+	// Storage is config of file storage. Backend is one of minio, local or memory.
+	// Memory backend loses files on restart and its URLs can not be served, it is intended for tests.
+	// Bucket is created on startup if it does not exist, and its policy and lifecycle rules are replaced
+	// by PublicReadPrefixes and LifecycleRules.
+	Storage struct {
+		Backend            string                 `yaml:"backend" env:"STORAGE_BACKEND"`
+		Bucket             string                 `yaml:"bucket" env:"STORAGE_BUCKET"`
+		PublicReadPrefixes []string               `yaml:"publicReadPrefixes"`
+		LifecycleRules     []StorageLifecycleRule `yaml:"lifecycleRules"`
+		Local              LocalStorage           `yaml:"local"`
+	}
+	// StorageLifecycleRule makes storage delete objects with prefix after expiration days.
+	StorageLifecycleRule struct {
+		Prefix         string `yaml:"prefix"`
+		ExpirationDays int    `yaml:"expirationDays"`
+	}
+	// LocalStorage is config of storage in local directory. Its signed URLs are served by application,
+	// so BaseURL is external address of application.
+	LocalStorage struct {
+		RootDir       string       `yaml:"rootDir"`
+		BaseURL       string       `yaml:"baseURL"`
+		SignKey       cr.StdBase64 `yaml:"signKey" env:"LOCAL_STORAGE_SIGN_KEY,default=dGVzdA=="`
+		MaxUploadSize int64        `yaml:"maxUploadSize"`
+	}
 	Minio struct {
 		Endpoint  cr.AddressConfig  `yaml:"endpoint" env:"MINIO_ENDPOINT,default=0.0.0.0:9000"`
 		AccessKey string            `yaml:"accessKey" env:"MINIO_ACCESS_KEY,default=minio"`
@@ -78,8 +230,98 @@ type (
 		Timeout   cr.DurationConfig `yaml:"timeout"`
 	}
 	FilePathTemplates struct {
+		// UserAvatar is path of avatar with UUID of upload, so new avatar does not overwrite objects of previous one.
 		UserAvatar  string `yaml:"userAvatar"`
 		UserPicture string `yaml:"userPicture"`
+		// UserDir is prefix of all user files. It is used to clean up storage on account deletion.
+		UserDir string `yaml:"userDir"`
+		// UserPictureUpload is path of picture uploaded directly to storage before it is processed.
+		UserPictureUpload string `yaml:"userPictureUpload"`
+		// UserDataExport is path of user personal data export archive. It is overwritten by next export.
+		UserDataExport string `yaml:"userDataExport"`
+	}
+	// PictureProcessing is config of uploaded pictures processing. Sizes are in pixels of the longest side.
+	// MaxPixels limits decoded image area to protect from decompression bombs.
+	PictureProcessing struct {
+		MaxFileSize int64            `yaml:"maxFileSize"`
+		MaxPixels   int64            `yaml:"maxPixels"`
+		MaxSize     int              `yaml:"maxSize"`
+		JPEGQuality int              `yaml:"jpegQuality"`
+		Variants    []PictureVariant `yaml:"variants"`
+	}
+	PictureVariant struct {
+		Name    string `yaml:"name"`
+		MaxSize int    `yaml:"maxSize"`
+	}
+	// PictureUpload is config of direct uploads to storage by presigned URL.
+	// URLLifetime must be less than storage reconciliation grace period, not finalized uploads are deleted after it.
+	PictureUpload struct {
+		URLLifetime cr.DurationConfig `yaml:"urlLifetime"`
+	}
+	// PictureURLs is config of picture URLs. Mode is one of signed or presigned.
+	// Signed URLs are served by application, they are keyed by picture content hash and stay the same
+	// within Expiry window, so browsers and CDN can cache pictures. Such URL is valid from Expiry to 2*Expiry.
+	// Presigned URLs are made by storage and cached until CacheMargin before they expire.
+	PictureURLs struct {
+		Mode        string            `yaml:"mode" env:"PICTURE_URLS_MODE"`
+		BaseURL     string            `yaml:"baseURL"`
+		SignKey     cr.StdBase64      `yaml:"signKey" env:"PICTURE_URLS_SIGN_KEY,default=dGVzdA=="`
+		Expiry      cr.DurationConfig `yaml:"expiry"`
+		CacheMargin cr.DurationConfig `yaml:"cacheMargin"`
+	}
+	// PictureModeration is config of uploaded pictures moderation. Moderator is one of blocklist or http.
+	PictureModeration struct {
+		Moderator string               `yaml:"moderator" env:"PICTURE_MODERATOR"`
+		Blocklist PictureBlocklist     `yaml:"blocklist"`
+		HTTP      HTTPPictureModerator `yaml:"http"`
+	}
+	// PictureBlocklist is list of perceptual hashes of blocked pictures in hex. Picture is rejected if its hash
+	// differs from blocked one in not more than RejectDistance bits and sent to review if in not more than ReviewDistance.
+	PictureBlocklist struct {
+		Hashes         []string `yaml:"hashes"`
+		RejectDistance int      `yaml:"rejectDistance"`
+		ReviewDistance int      `yaml:"reviewDistance"`
+	}
+	// HTTPPictureModerator is external classifier. It gets JPEG picture in POST body
+	// and responds with JSON {"verdict": "approve"}, verdict is one of approve, reject or review.
+	HTTPPictureModerator struct {
+		URL     string            `yaml:"url" env:"PICTURE_MODERATOR_URL"`
+		Timeout cr.DurationConfig `yaml:"timeout"`
+	}
+	// PictureDuplicates is config of detection of pictures which are near-duplicates of pictures of other users.
+	// Pictures match if their perceptual hashes differ in not more than MaxDistance bits. Matches within
+	// 3 bits are always found, larger distances only if hashes have equal 16-bit band.
+	PictureDuplicates struct {
+		MaxDistance     int `yaml:"maxDistance"`
+		CandidatesLimit int `yaml:"candidatesLimit"`
+	}
+	// Pagination is config of list endpoints. Cursors are signed with SignKey, so clients can not forge them.
+	// Page has DefaultLimit items if client does not set limit, and not more than MaxLimit items.
+	Pagination struct {
+		SignKey      cr.StdBase64 `yaml:"signKey" env:"PAGINATION_SIGN_KEY,default=dGVzdA=="`
+		DefaultLimit int          `yaml:"defaultLimit"`
+		MaxLimit     int          `yaml:"maxLimit"`
+	}
+	// AccountDeletion is config of self-service account deletion.
+	// Account is purged after GracePeriod unless user signs in again.
+	AccountDeletion struct {
+		GracePeriod    cr.DurationConfig `yaml:"gracePeriod"`
+		PurgeInterval  cr.DurationConfig `yaml:"purgeInterval"`
+		PurgeBatchSize int               `yaml:"purgeBatchSize"`
+	}
+	// DataExport is config of personal data export. Archive is available by link for URLLifetime.
+	// It is deleted by storage reconciliation, so URLLifetime must not be greater than its grace period.
+	DataExport struct {
+		RequestsInterval cr.DurationConfig `yaml:"requestsInterval"`
+		URLLifetime      cr.DurationConfig `yaml:"urlLifetime"`
+		WorkersNum       int               `yaml:"workersNum"`
+	}
+	// StorageReconciliation is config of job which repairs mismatches between storage objects and database.
+	// Objects and outbox records younger than GracePeriod are skipped as they can belong to uploads in progress.
+	StorageReconciliation struct {
+		Interval    cr.DurationConfig `yaml:"interval"`
+		GracePeriod cr.DurationConfig `yaml:"gracePeriod"`
+		BatchSize   int               `yaml:"batchSize"`
 	}
 )
 
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Validate checks values which can be checked without connecting to dependencies.
+// Empty mode means default one.
+func (c *Config) Validate() error {
+	switch c.Authorization.TokenSourcePrecedence {
+	case "", TokenSourceCookie, TokenSourceHeader:
+	default:
+		return errors.Errorf("unknown token source %q", c.Authorization.TokenSourcePrecedence)
+	}
+
+	switch c.Storage.Backend {
+	case "", StorageBackendMinio, StorageBackendLocal, StorageBackendMemory:
+	default:
+		return errors.Errorf("unknown storage backend %q", c.Storage.Backend)
+	}
+
+	if c.Storage.Bucket == "" {
+		return errors.New("empty storage bucket")
+	}
+
+	if c.Storage.Backend == StorageBackendLocal && c.Storage.Local.RootDir == "" {
+		return errors.New("empty local storage root dir")
+	}
+
+	switch c.PictureURLs.Mode {
+	case "", PictureURLModeSigned, PictureURLModePresigned:
+	default:
+		return errors.Errorf("unknown picture URLs mode %q", c.PictureURLs.Mode)
+	}
+
+	if c.PictureURLs.Expiry.Duration() < time.Second {
+		return errors.New("picture URLs expiry must be at least one second")
+	}
+
+	switch c.PictureModeration.Moderator {
+	case "", PictureModeratorBlocklist:
+	case PictureModeratorHTTP:
+		if c.PictureModeration.HTTP.URL == "" {
+			return errors.New("empty picture moderator URL")
+		}
+	default:
+		return errors.Errorf("unknown picture moderator %q", c.PictureModeration.Moderator)
+	}
+
+	switch c.PostgresDB.Transactions.Isolation {
+	case "", TxIsolationReadCommitted, TxIsolationRepeatableRead, TxIsolationSerializable:
+	default:
+		return errors.Errorf("unknown transaction isolation %q", c.PostgresDB.Transactions.Isolation)
+	}
+
+	if c.Pagination.DefaultLimit <= 0 || c.Pagination.MaxLimit < c.Pagination.DefaultLimit {
+		return errors.New("pagination default limit must be positive and not greater than max limit")
+	}
+
+	if c.AccountDeletion.PurgeBatchSize <= 0 {
+		return errors.New("account deletion purge batch size must be positive")
+	}
+
+	if c.TwoFactor.ChallengeMaxAttempts <= 0 {
+		return errors.New("two-factor challenge max attempts must be positive")
+	}
+
+	if c.DataExport.URLLifetime.Duration() > c.StorageReconciliation.GracePeriod.Duration() {
+		return errors.New("data export URL lifetime must not be greater than storage reconciliation grace period")
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return errors.Errorf("not valid trusted proxy %q", proxy)
+		}
+	}
+
+	return nil
+}
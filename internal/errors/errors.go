@@ -20,6 +20,10 @@ func (e *Error) Error() string {
 	return e.Err.Error()
 }
 
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
 func New(err error) *Error {
 	return &Error{
 		Err:    err,
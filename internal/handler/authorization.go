@@ -2,7 +2,7 @@ package handler
 
 import (
 	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	ierrors "github.com/l-orlov/matcha/internal/errors"
@@ -13,6 +13,9 @@ import (
 const (
 	accessTokenCookieName  = "_m_access"
 	refreshTokenCookieName = "_m_refresh"
+
+	twoFactorMethodTOTP    = "totp"
+	twoFactorMethodPasskey = "passkey"
 )
 
 func (h *Handler) SignIn(c *gin.Context) {
@@ -24,23 +27,92 @@ func (h *Handler) SignIn(c *gin.Context) {
 		return
 	}
 
-	userID, err := h.svc.AuthenticateUserByUsername(c, user.Username, user.Password, user.Fingerprint)
+	userID, err := h.svc.AuthenticateUserByUsername(c, user.Username, user.Password, user.Fingerprint, c.ClientIP())
 	if err != nil {
 		h.newErrorResponse(c, http.StatusBadRequest, err)
 		return
 	}
 
-	accessToken, refreshToken, err := h.svc.CreateSession(strconv.FormatUint(userID, 10))
+	h.signIn(c, userID)
+}
+
+func (h *Handler) SignInByTwoFactorCode(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "SignInByTwoFactorCode")
+
+	var req models.UserToSignInByTwoFactor
+	if err := c.BindJSON(&req); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userID, err := h.svc.AuthenticateUserByTwoFactorCode(
+		c, req.ChallengeToken, req.Code, req.Fingerprint, c.ClientIP(),
+	)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	h.createSession(c, userID)
+}
+
+func (h *Handler) RequestMagicLink(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "RequestMagicLink")
+
+	var req models.UserToSignInByMagicLink
+	if err := c.BindJSON(&req); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	userID, err := h.svc.CheckMagicLinkRequest(c, req.Email, req.Fingerprint)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// response is the same for unknown email to not reveal registered ones
+	if userID == 0 {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	magicLinkToken, err := h.svc.Verification.CreateMagicLinkToken(userID)
 	if err != nil {
 		h.newErrorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	h.setTokensCookies(c, accessToken, refreshToken)
-	c.JSON(http.StatusOK, map[string]interface{}{
-		"accessToken":  accessToken,
-		"refreshToken": refreshToken,
-	})
+	// send token by email
+	h.svc.Mailer.SendMagicLink(req.Email, magicLinkToken)
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) SignInByMagicLink(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "SignInByMagicLink")
+
+	token, ok := c.GetQuery("token")
+	if !ok || token == "" {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrEmptyTokenParameter, ""),
+		)
+		return
+	}
+
+	userID, err := h.svc.Verification.VerifyMagicLinkToken(token)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// link was received by email, so it is confirmed
+	if err := h.svc.User.ConfirmEmail(c, userID); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.signIn(c, userID)
 }
 
 func (h *Handler) ValidateAccessToken(c *gin.Context) {
@@ -69,7 +141,7 @@ func (h *Handler) RefreshSession(c *gin.Context) {
 		return
 	}
 
-	accessToken, refreshToken, err := h.svc.RefreshSession(req.RefreshToken)
+	accessToken, refreshToken, err := h.svc.RefreshSession(c, req.RefreshToken)
 	if err != nil {
 		h.newErrorResponse(c, http.StatusUnauthorized, err)
 		return
@@ -89,6 +161,11 @@ func (h *Handler) Logout(c *gin.Context) {
 		h.getLogEntry(c).Debug(err)
 	}
 
+	if accessToken == "" {
+		// try to get accessToken from Authorization header
+		accessToken, _ = getBearerToken(c)
+	}
+
 	if accessToken == "" {
 		// try to get accessToken from request
 		var req models.LogoutRequest
@@ -144,6 +221,139 @@ func (h *Handler) ResetPassword(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// signIn creates session for user authenticated by first factor.
+// If user has enabled TOTP or registered passkeys it responds with challenge token instead.
+func (h *Handler) signIn(c *gin.Context, userID uint64) {
+	var twoFactorMethods []string
+
+	isTwoFactorEnabled, err := h.svc.TwoFactor.IsTwoFactorEnabled(c, userID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if isTwoFactorEnabled {
+		twoFactorMethods = append(twoFactorMethods, twoFactorMethodTOTP)
+	}
+
+	hasPasskeys, err := h.svc.WebAuthn.HasPasskeys(c, userID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if hasPasskeys {
+		twoFactorMethods = append(twoFactorMethods, twoFactorMethodPasskey)
+	}
+
+	if len(twoFactorMethods) != 0 {
+		challengeToken, err := h.svc.Verification.CreateTwoFactorChallengeToken(userID)
+		if err != nil {
+			h.newErrorResponse(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, map[string]interface{}{
+			"twoFactorRequired": true,
+			"twoFactorMethods":  twoFactorMethods,
+			"challengeToken":    challengeToken,
+		})
+		return
+	}
+
+	h.createSession(c, userID)
+}
+
+// createSession creates session for authenticated user and responds with its tokens.
+func (h *Handler) createSession(c *gin.Context, userID uint64) {
+	accessToken, refreshToken, err := h.svc.CreateSession(c, userID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// signing in reactivates deactivated account and cancels deletion during grace period
+	isReactivated, err := h.svc.User.ReactivateUser(c, userID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if isReactivated {
+		h.getLogEntry(c).Infof("account of user %d is reactivated by sign in", userID)
+	}
+
+	if err := h.svc.LoginAlert.RegisterLogin(c, userID, h.getLoginInfo(c)); err != nil {
+		h.getLogEntry(c).Errorf("failed to register login: %v", err)
+	}
+
+	h.setTokensCookies(c, accessToken, refreshToken)
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+	})
+}
+
+func (h *Handler) getLoginInfo(c *gin.Context) models.LoginInfo {
+	info := models.LoginInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+
+	if h.cfg.LoginAlerts.CountryHeader != "" {
+		// country is expected as ISO 3166-1 alpha-2 code
+		if country := strings.ToUpper(c.GetHeader(h.cfg.LoginAlerts.CountryHeader)); len(country) == 2 {
+			info.Country = country
+		}
+	}
+
+	return info
+}
+
+// RevokeAccessByLoginAlert handles "this wasn't me" link from login alert email.
+// It signs user out everywhere, revokes passwordless sign in methods and sends password reset link.
+func (h *Handler) RevokeAccessByLoginAlert(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "RevokeAccessByLoginAlert")
+
+	token, ok := c.GetQuery("token")
+	if !ok || token == "" {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrEmptyTokenParameter, ""),
+		)
+		return
+	}
+
+	userID, err := h.svc.LoginAlert.RevokeAccessByLoginAlert(c, token)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.svc.User.GetUserByID(c, userID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if user == nil {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrUserNotFound, ""),
+		)
+		return
+	}
+
+	passwordResetConfirmToken, err := h.svc.Verification.CreatePasswordResetConfirmToken(user.ID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// send token by email
+	h.svc.Mailer.SendResetPasswordConfirm(user.Email, passwordResetConfirmToken)
+
+	c.Status(http.StatusOK)
+}
+
 func (h *Handler) setTokensCookies(c *gin.Context, accessToken, refreshToken string) {
 	if encodedAccessToken, err := h.options.SecureCookie.Encode(accessTokenCookieName, accessToken); err == nil {
 		c.SetCookie(
@@ -164,6 +374,11 @@ func (h *Handler) setTokensCookies(c *gin.Context, accessToken, refreshToken str
 	}
 }
 
+func (h *Handler) deleteTokensCookies(c *gin.Context) {
+	c.SetCookie(accessTokenCookieName, "", -1, "/", h.cfg.Cookie.Domain, false, true)
+	c.SetCookie(refreshTokenCookieName, "", -1, "/", h.cfg.Cookie.Domain, false, true)
+}
+
 func (h *Handler) Cookie(c *gin.Context, name string) (string, error) {
 	value, err := c.Cookie(name)
 	if err != nil {
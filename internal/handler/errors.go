@@ -9,5 +9,10 @@ var (
 	ErrNotValidUUIDParameter = errors.New("not valid uuid parameter")
 	ErrEmptyEmailParameter   = errors.New("empty email parameter")
 	ErrEmptyTokenParameter   = errors.New("empty token parameter")
+	ErrEmptyCodeParameter    = errors.New("empty code parameter")
+	ErrEmptySessionParameter = errors.New("empty session parameter")
+	ErrNotValidIDEncoding    = errors.New("not valid id parameter encoding")
 	ErrUserNotFound          = errors.New("user not found")
+	ErrPictureNotFound       = errors.New("picture not found")
+	ErrTooManyRequests       = errors.New("too many requests")
 )
@@ -6,7 +6,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/securecookie"
 	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/repository/local"
 	"github.com/l-orlov/matcha/internal/service"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,11 +23,13 @@ type (
 		log     *logrus.Logger
 		options Options
 		svc     *service.Service
+		// fileServer serves signed URLs of storage backend which has no server of its own, e.g. local.
+		fileServer http.Handler
 	}
 )
 
 func New(
-	cfg *config.Config, log *logrus.Logger, svc *service.Service,
+	cfg *config.Config, log *logrus.Logger, svc *service.Service, fileServer http.Handler,
 ) *Handler {
 	c := &Handler{
 		cfg: cfg,
@@ -35,31 +39,56 @@ func New(
 			RefreshTokenCookieMaxAge: int(cfg.JWT.RefreshTokenLifetime.Duration().Seconds()),
 			SecureCookie:             securecookie.New(cfg.Cookie.HashKey, cfg.Cookie.BlockKey),
 		},
-		svc: svc,
+		svc:        svc,
+		fileServer: fileServer,
 	}
 
 	return c
 }
 
-func (h *Handler) InitRoutes() http.Handler {
+func (h *Handler) InitRoutes() (http.Handler, error) {
 	router := gin.New()
 
-	router.Use(h.InitMiddleware)
+	// forwarding headers can be set by client itself, so they are trusted only from known proxies,
+	// otherwise client IP could be spoofed to bypass rate limits and login lockouts
+	if err := router.SetTrustedProxies(h.cfg.TrustedProxies); err != nil {
+		return nil, errors.Wrap(err, "not valid trusted proxies")
+	}
+	router.ForwardedByClientIP = len(h.cfg.TrustedProxies) != 0
+
+	router.Use(h.InitMiddleware, h.RateLimitMiddleware)
 
 	auth := router.Group("/auth")
 	{
 		auth.POST("/sign-up", h.CreateUser)
 		auth.POST("/sign-in", h.SignIn)
+		auth.POST("/sign-in/2fa", h.SignInByTwoFactorCode)
+		auth.POST("/sign-in/2fa/passkey/begin", h.BeginPasskeyTwoFactor)
+		auth.POST("/sign-in/2fa/passkey/finish", h.SignInByPasskeyTwoFactor)
+		auth.POST("/passkey/begin", h.BeginPasskeySignIn)
+		auth.POST("/passkey/finish", h.SignInByPasskey)
+		auth.POST("/magic-link", h.RequestMagicLink)
+		auth.POST("/magic-link/sign-in", h.SignInByMagicLink)
+		auth.GET("/oauth/:provider", h.OAuthLogin)
+		auth.GET("/oauth/:provider/callback", h.OAuthCallback)
 		router.POST("/reset-password", h.ResetPassword)
 		auth.POST("/validate-access-token", h.ValidateAccessToken)
 		auth.POST("/refresh-session", h.RefreshSession)
 		auth.POST("/logout", h.Logout)
+		auth.POST("/not-me", h.RevokeAccessByLoginAlert)
 	}
 
+	if h.fileServer != nil {
+		router.Any(local.URLPath+"*object", gin.WrapH(h.fileServer))
+	}
+
+	router.GET(service.PictureURLPath+":version/*path", h.GetPicture)
+	router.HEAD(service.PictureURLPath+":version/*path", h.GetPicture)
+
 	router.POST("/confirm-email", h.ConfirmEmail)
 	router.POST("/confirm-reset-password", h.ConfirmPasswordReset)
 
-	api := router.Group("/api/v1", h.UserAuthorizationMiddleware)
+	api := router.Group("/api/v1", h.UserAuthorizationMiddleware, h.UserRateLimitMiddleware)
 	{
 		users := api.Group("/users")
 		{
@@ -69,20 +98,60 @@ func (h *Handler) InitRoutes() http.Handler {
 			users.PUT("/", h.UpdateUser)
 			users.PUT("/set-password", h.SetUserPassword)
 			users.PUT("/change-password", h.ChangeUserPassword)
-			users.DELETE("/by-id/:id", h.DeleteUser)
+			users.PUT("/state", h.SetUserState)
+			users.POST("/delete-account", h.DeleteAccount)
+			users.POST("/data-export", h.RequestDataExport)
 			users.GET("/profile/by-id/:id", h.GetUserProfileByID)
 			users.PUT("/profile", h.UpdateUserProfile)
 
+			usersTwoFactor := users.Group("/2fa")
+			{
+				usersTwoFactor.POST("/enroll", h.EnrollTwoFactor)
+				usersTwoFactor.POST("/enable", h.EnableTwoFactor)
+				usersTwoFactor.POST("/disable", h.DisableTwoFactor)
+				usersTwoFactor.POST("/recovery-codes", h.GenerateRecoveryCodes)
+			}
+
+			usersPasskeys := users.Group("/passkeys")
+			{
+				usersPasskeys.POST("/register/begin", h.BeginPasskeyRegistration)
+				usersPasskeys.POST("/register/finish", h.FinishPasskeyRegistration)
+				usersPasskeys.GET("/", h.GetPasskeys)
+				usersPasskeys.DELETE("/", h.DeletePasskey)
+			}
+
 			usersPictures := users.Group("/pictures")
 			{
 				usersPictures.POST("/avatar", h.UploadUserAvatar)
 				usersPictures.DELETE("/avatar", h.DeleteUserAvatar)
+				usersPictures.POST("/avatar/promote", h.PromoteUserPictureToAvatar)
 				usersPictures.POST("/", h.UploadUserPicture)
+				usersPictures.POST("/upload-url", h.CreatePictureUploadURL)
+				usersPictures.POST("/finalize", h.FinalizePictureUpload)
 				usersPictures.GET("/", h.GetUserPictures)
+				usersPictures.PUT("/order", h.ReorderUserPictures)
+				usersPictures.PUT("/caption", h.UpdateUserPictureCaption)
 				usersPictures.DELETE("/", h.DeleteUserPicture)
 			}
 		}
+
+		admin := api.Group("/admin", h.AdminAuthorizationMiddleware)
+		{
+			admin.DELETE("/users/by-id/:id", h.DeleteUser)
+		}
+
+		moderation := api.Group("/moderation", h.AdminAuthorizationMiddleware)
+		{
+			moderation.GET("/pictures", h.GetPendingUserPictures)
+			moderation.POST("/pictures/approve", h.ApproveUserPicture)
+			moderation.POST("/pictures/reject", h.RejectUserPicture)
+			moderation.GET("/avatars", h.GetPendingUserAvatars)
+			moderation.POST("/avatars/approve", h.ApproveUserAvatar)
+			moderation.POST("/avatars/reject", h.RejectUserAvatar)
+			moderation.GET("/picture-matches", h.GetPictureMatches)
+			moderation.DELETE("/picture-matches", h.DismissPictureMatch)
+		}
 	}
 
-	return CORS(router)
+	return CORS(router), nil
 }
@@ -2,22 +2,29 @@ package handler
 
 import (
 	"net/http"
-	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
 	"github.com/l-orlov/matcha/internal/service"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	ctxUserID   = "userID"
-	ctxLogEntry = "log-entry"
+	ctxUserID            = "userID"
+	ctxAccessTokenClaims = "accessTokenClaims"
+	ctxLogEntry          = "log-entry"
+
+	authorizationHeader = "Authorization"
 )
 
-var ErrNotValidAuthorizationHeader = errors.New("not valid Authorization header")
+var (
+	ErrNotValidAuthorizationHeader = errors.New("not valid Authorization header")
+	ErrAdminRoleRequired           = errors.New("admin role is required")
+)
 
 func CORS(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,8 +48,17 @@ func (h *Handler) InitMiddleware(c *gin.Context) {
 	c.Set(ctxLogEntry, logEntry)
 }
 
+// UserAuthorizationMiddleware authorizes user by accessToken from cookie or from Authorization header.
+// If request has both, the source is chosen by configured precedence.
 func (h *Handler) UserAuthorizationMiddleware(c *gin.Context) {
-	if err := h.validateTokenCookieAndRefreshIfNeeded(c); err != nil {
+	var err error
+	if h.isBearerTokenPreferred(c) {
+		err = h.validateTokenHeader(c)
+	} else {
+		err = h.validateTokenCookieAndRefreshIfNeeded(c)
+	}
+
+	if err != nil {
 		h.newErrorResponse(c, http.StatusUnauthorized, err)
 		return
 	}
@@ -50,6 +66,36 @@ func (h *Handler) UserAuthorizationMiddleware(c *gin.Context) {
 	c.Next()
 }
 
+// AdminAuthorizationMiddleware allows only users with admin role. It must be used after UserAuthorizationMiddleware.
+func (h *Handler) AdminAuthorizationMiddleware(c *gin.Context) {
+	claims, err := getAccessTokenClaimsFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, role := range claims.Roles {
+		if role == models.RoleAdmin {
+			c.Next()
+			return
+		}
+	}
+
+	h.newErrorResponse(c, http.StatusForbidden, ErrAdminRoleRequired)
+}
+
+func (h *Handler) isBearerTokenPreferred(c *gin.Context) bool {
+	if c.GetHeader(authorizationHeader) == "" {
+		return false
+	}
+
+	if h.cfg.Authorization.TokenSourcePrecedence == config.TokenSourceHeader {
+		return true
+	}
+
+	return !hasCookie(c, accessTokenCookieName) && !hasCookie(c, refreshTokenCookieName)
+}
+
 // validateTokenCookieAndRefreshIfNeeded gets accessToken from cookie and validate it.
 // on success it puts accessToken data to ctx and returns nil.
 // else it tries to refresh session by refresh token from cookie:
@@ -64,7 +110,7 @@ func (h *Handler) validateTokenCookieAndRefreshIfNeeded(c *gin.Context) error {
 
 	accessTokenClaims, err := h.svc.UserAuthorization.ValidateAccessToken(accessToken)
 	if err != nil {
-		if !strings.Contains(err.Error(), "token is expired by") &&
+		if !errors.Is(err, service.ErrAccessTokenExpired) &&
 			!errors.Is(err, service.ErrNotActiveAccessToken) {
 			return err
 		}
@@ -72,7 +118,7 @@ func (h *Handler) validateTokenCookieAndRefreshIfNeeded(c *gin.Context) error {
 		return h.refreshSessionByRefreshTokenCookie(c)
 	}
 
-	return h.validateAndSetUserIDForContext(c, accessTokenClaims.Subject)
+	return h.setAccessTokenClaimsToContext(c, accessTokenClaims)
 }
 
 func (h *Handler) refreshSessionByRefreshTokenCookie(c *gin.Context) error {
@@ -81,7 +127,7 @@ func (h *Handler) refreshSessionByRefreshTokenCookie(c *gin.Context) error {
 		return err
 	}
 
-	newAccessToken, newRefreshToken, err := h.svc.UserAuthorization.RefreshSession(refreshToken)
+	newAccessToken, newRefreshToken, err := h.svc.UserAuthorization.RefreshSession(c, refreshToken)
 	if err != nil {
 		return err
 	}
@@ -93,7 +139,7 @@ func (h *Handler) refreshSessionByRefreshTokenCookie(c *gin.Context) error {
 
 	h.setTokensCookies(c, newAccessToken, newRefreshToken)
 
-	return h.validateAndSetUserIDForContext(c, accessTokenClaims.Subject)
+	return h.setAccessTokenClaimsToContext(c, accessTokenClaims)
 }
 
 func setHandlerNameToLogEntry(c *gin.Context, handlerName string) {
@@ -125,17 +171,14 @@ func (h *Handler) getLogEntry(c *gin.Context) *logrus.Entry {
 	return logEntry
 }
 
-func (h *Handler) validateAndSetUserIDForContext(c *gin.Context, userIDStr string) error {
-	userID, err := strconv.ParseUint(userIDStr, 10, 64)
-	if err != nil {
-		return err
-	}
-
-	if err = h.validateUserID(c, userID); err != nil {
+// setAccessTokenClaimsToContext validates accessToken claims and puts them to ctx.
+func (h *Handler) setAccessTokenClaimsToContext(c *gin.Context, claims *models.AccessTokenClaims) error {
+	if err := h.validateUserID(c, claims.UserID); err != nil {
 		return err
 	}
 
-	c.Set(ctxUserID, userID)
+	c.Set(ctxUserID, claims.UserID)
+	c.Set(ctxAccessTokenClaims, claims)
 
 	return nil
 }
@@ -167,22 +210,48 @@ func getUserIDFromContext(c *gin.Context) (uint64, error) {
 	return userID, nil
 }
 
-// NOT USEFUL CODE BELOW.
+func getAccessTokenClaimsFromContext(c *gin.Context) (*models.AccessTokenClaims, error) {
+	claimsValue, ok := c.Get(ctxAccessTokenClaims)
+	if !ok {
+		return nil, errors.New("failed to get access token claims from context")
+	}
+
+	claims, ok := claimsValue.(*models.AccessTokenClaims)
+	if !ok {
+		return nil, errors.Errorf("access token claims from context have not valid type: %T", claimsValue)
+	}
+
+	return claims, nil
+}
 
-// validateTokenHeader gets accessToken from header and validate it.
+// validateTokenHeader gets accessToken from Authorization header and validate it.
 // on success it puts accessToken data to ctx and returns nil. else it returns error.
+// Session is not refreshed here: bearer clients refresh it by themselves.
 func (h *Handler) validateTokenHeader(c *gin.Context) error {
-	header := c.GetHeader("Authorization")
-	headerParts := strings.Split(header, " ")
-	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		return ErrNotValidAuthorizationHeader
+	accessToken, err := getBearerToken(c)
+	if err != nil {
+		return err
 	}
 
-	accessToken := headerParts[1]
 	accessTokenClaims, err := h.svc.UserAuthorization.ValidateAccessToken(accessToken)
 	if err != nil {
 		return err
 	}
 
-	return h.validateAndSetUserIDForContext(c, accessTokenClaims.Subject)
+	return h.setAccessTokenClaimsToContext(c, accessTokenClaims)
+}
+
+func getBearerToken(c *gin.Context) (string, error) {
+	header := c.GetHeader(authorizationHeader)
+	headerParts := strings.Split(header, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" || headerParts[1] == "" {
+		return "", ErrNotValidAuthorizationHeader
+	}
+
+	return headerParts[1], nil
+}
+
+func hasCookie(c *gin.Context, name string) bool {
+	_, err := c.Cookie(name)
+	return err == nil
 }
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
+)
+
+func (h *Handler) GetPendingUserPictures(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "GetPendingUserPictures")
+
+	var pageReq pagination.Request
+	if err := c.BindQuery(&pageReq); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	pictures, nextCursor, err := h.svc.PictureModeration.GetPendingUserPictures(c, pageReq)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if pictures == nil {
+		c.JSON(http.StatusOK, pagination.Page{Items: []struct{}{}})
+		return
+	}
+
+	c.JSON(http.StatusOK, pagination.Page{Items: pictures, NextCursor: nextCursor})
+}
+
+func (h *Handler) ApproveUserPicture(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "ApproveUserPicture")
+
+	pictureUUID, err := uuid.Parse(c.Query("uuid"))
+	if err != nil {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrNotValidUUIDParameter, ""),
+		)
+		return
+	}
+
+	if err := h.svc.PictureModeration.ApproveUserPicture(c, pictureUUID); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) RejectUserPicture(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "RejectUserPicture")
+
+	pictureUUID, err := uuid.Parse(c.Query("uuid"))
+	if err != nil {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrNotValidUUIDParameter, ""),
+		)
+		return
+	}
+
+	if err := h.svc.PictureModeration.RejectUserPicture(c, pictureUUID); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) GetPendingUserAvatars(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "GetPendingUserAvatars")
+
+	var pageReq pagination.Request
+	if err := c.BindQuery(&pageReq); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	avatars, nextCursor, err := h.svc.PictureModeration.GetPendingUserAvatars(c, pageReq)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if avatars == nil {
+		c.JSON(http.StatusOK, pagination.Page{Items: []struct{}{}})
+		return
+	}
+
+	c.JSON(http.StatusOK, pagination.Page{Items: avatars, NextCursor: nextCursor})
+}
+
+func (h *Handler) ApproveUserAvatar(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "ApproveUserAvatar")
+
+	var review models.UserAvatarReview
+	if err := c.BindJSON(&review); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.svc.PictureModeration.ApproveUserAvatar(c, review); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) RejectUserAvatar(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "RejectUserAvatar")
+
+	var review models.UserAvatarReview
+	if err := c.BindJSON(&review); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.svc.PictureModeration.RejectUserAvatar(c, review); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) GetPictureMatches(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "GetPictureMatches")
+
+	var pageReq pagination.Request
+	if err := c.BindQuery(&pageReq); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	matches, nextCursor, err := h.svc.PictureModeration.GetPictureMatches(c, pageReq)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if matches == nil {
+		c.JSON(http.StatusOK, pagination.Page{Items: []struct{}{}})
+		return
+	}
+
+	c.JSON(http.StatusOK, pagination.Page{Items: matches, NextCursor: nextCursor})
+}
+
+func (h *Handler) DismissPictureMatch(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "DismissPictureMatch")
+
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrNotValidIDParameter, ""),
+		)
+		return
+	}
+
+	if err := h.svc.PictureModeration.DismissPictureMatch(c, id); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/pkg/errors"
+)
+
+const (
+	oauthStateCookieName = "_m_oauth_state"
+	oauthStateCookiePath = "/auth/oauth/"
+)
+
+// OAuthLogin redirects to provider login page. State is also put to cookie, so callback is accepted
+// only in browser which started login. Lax cookie is sent on top-level redirect back from provider.
+func (h *Handler) OAuthLogin(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "OAuthLogin")
+
+	loginURL, state, err := h.svc.OAuth.GetOAuthLoginURL(c, c.Param("provider"))
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	encodedState, err := h.options.SecureCookie.Encode(oauthStateCookieName, state)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.setOAuthStateCookie(c, encodedState, int(h.cfg.OAuth.StateLifetime.Duration().Seconds()))
+
+	c.Redirect(http.StatusFound, loginURL)
+}
+
+func (h *Handler) OAuthCallback(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "OAuthCallback")
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		h.newErrorResponse(
+			c, http.StatusBadRequest,
+			ierrors.NewBusiness(errors.Errorf("oauth provider error: %s", providerErr), c.Query("error_description")),
+		)
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrEmptyCodeParameter, ""),
+		)
+		return
+	}
+
+	// state cookie is single-use like state itself
+	browserState, err := h.Cookie(c, oauthStateCookieName)
+	if err != nil {
+		h.getLogEntry(c).Debug(err)
+	}
+	h.setOAuthStateCookie(c, "", -1)
+
+	userID, err := h.svc.OAuth.AuthenticateUserByOAuth(c, c.Param("provider"), c.Query("state"), browserState, code)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.signIn(c, userID)
+}
+
+func (h *Handler) setOAuthStateCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     oauthStateCookiePath,
+		Domain:   h.cfg.Cookie.Domain,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
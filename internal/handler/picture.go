@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/service"
+	"github.com/pkg/errors"
+)
+
+// GetPicture serves picture by signed URL. Response can be cached by browsers and CDN until URL expires,
+// ETag and Range requests are handled by http.ServeContent.
+func (h *Handler) GetPicture(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "GetPicture")
+
+	expires, err := strconv.ParseInt(c.Query(service.PictureURLExpiresParam), 10, 64)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusForbidden, service.ErrNotValidPictureURL)
+		return
+	}
+
+	data, err := h.svc.PictureURL.GetSignedPicture(c, models.SignedPictureRequest{
+		Path:      strings.TrimPrefix(c.Param("path"), "/"),
+		Version:   c.Param("version"),
+		Expires:   expires,
+		Signature: c.Query(service.PictureURLSignatureParam),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrNotValidPictureURL) {
+			h.newErrorResponse(c, http.StatusForbidden, err)
+			return
+		}
+
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if data == nil {
+		h.newErrorResponse(c, http.StatusNotFound, ErrPictureNotFound)
+		return
+	}
+
+	hash := sha256.Sum256(data)
+	c.Header("ETag", `"`+hex.EncodeToString(hash[:])+`"`)
+	c.Header("Cache-Control", "public, immutable, max-age="+strconv.FormatInt(expires-time.Now().Unix(), 10))
+
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(data))
+}
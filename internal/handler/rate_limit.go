@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/l-orlov/matcha/internal/config"
+)
+
+const retryAfterHeader = "Retry-After"
+
+// RateLimitMiddleware limits requests by client IP and by configured route limits.
+func (h *Handler) RateLimitMiddleware(c *gin.Context) {
+	clientIP := c.ClientIP()
+
+	if !h.allowRequest(c, "ip:"+clientIP, h.cfg.RateLimit.IP) {
+		return
+	}
+
+	route := c.Request.Method + " " + c.FullPath()
+	if rule, ok := h.cfg.RateLimit.Routes[route]; ok {
+		if !h.allowRequest(c, "route:"+route+":"+clientIP, rule) {
+			return
+		}
+	}
+
+	c.Next()
+}
+
+// UserRateLimitMiddleware limits requests by authorized user. It must be used after UserAuthorizationMiddleware.
+func (h *Handler) UserRateLimitMiddleware(c *gin.Context) {
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !h.allowRequest(c, "user:"+strconv.FormatUint(userID, 10), h.cfg.RateLimit.User) {
+		return
+	}
+
+	c.Next()
+}
+
+// allowRequest checks request by rule. If limit is exceeded it aborts request with 429 status.
+func (h *Handler) allowRequest(c *gin.Context, key string, rule config.RateLimitRule) bool {
+	if rule.MaxRequests <= 0 {
+		return true
+	}
+
+	allowed, retryAfter := h.svc.RateLimit.AllowRequest(key, rule.MaxRequests, rule.Window.Duration())
+	if allowed {
+		return true
+	}
+
+	c.Header(retryAfterHeader, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	h.newErrorResponse(c, http.StatusTooManyRequests, ErrTooManyRequests)
+
+	return false
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/l-orlov/matcha/internal/models"
+)
+
+func (h *Handler) EnrollTwoFactor(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "EnrollTwoFactor")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	enrollment, err := h.svc.TwoFactor.EnrollTwoFactor(c, userID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *Handler) EnableTwoFactor(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "EnableTwoFactor")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	recoveryCodes, err := h.svc.TwoFactor.EnableTwoFactor(c, userID, req.Code)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+func (h *Handler) DisableTwoFactor(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "DisableTwoFactor")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var req models.TwoFactorDisableRequest
+	if err := c.BindJSON(&req); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.svc.TwoFactor.DisableTwoFactor(c, userID, req.Password); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) GenerateRecoveryCodes(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "GenerateRecoveryCodes")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	recoveryCodes, err := h.svc.TwoFactor.GenerateRecoveryCodes(c, userID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"recoveryCodes": recoveryCodes,
+	})
+}
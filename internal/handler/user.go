@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	ierrors "github.com/l-orlov/matcha/internal/errors"
 	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
 )
 
 func (h *Handler) CreateUser(c *gin.Context) {
@@ -119,20 +120,27 @@ func (h *Handler) ChangeUserPassword(c *gin.Context) {
 func (h *Handler) GetAllUsers(c *gin.Context) {
 	setHandlerNameToLogEntry(c, "GetAllUsers")
 
-	users, err := h.svc.User.GetAllUsers(c)
+	var pageReq pagination.Request
+	if err := c.BindQuery(&pageReq); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	users, nextCursor, err := h.svc.User.GetAllUsers(c, pageReq)
 	if err != nil {
 		h.newErrorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
 
 	if users == nil {
-		c.JSON(http.StatusOK, []struct{}{})
+		c.JSON(http.StatusOK, pagination.Page{Items: []struct{}{}})
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, pagination.Page{Items: users, NextCursor: nextCursor})
 }
 
+// DeleteUser purges account of any user immediately, so it is allowed only to admins.
 func (h *Handler) DeleteUser(c *gin.Context) {
 	setHandlerNameToLogEntry(c, "DeleteUser")
 
@@ -144,7 +152,78 @@ func (h *Handler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	if err := h.svc.User.DeleteUser(c, id); err != nil {
+	if err := h.svc.AccountDeletion.PurgeAccount(c, id); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// DeleteAccount schedules deletion of current user account. User can cancel it by signing in again.
+func (h *Handler) DeleteAccount(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "DeleteAccount")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var req models.AccountDeletionRequest
+	if err := c.BindJSON(&req); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	deleteAt, err := h.svc.AccountDeletion.ScheduleAccountDeletion(c, userID, req.Password)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	h.deleteTokensCookies(c)
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"deleteAt": deleteAt,
+	})
+}
+
+// RequestDataExport queues export of current user personal data. Download link is sent by email.
+func (h *Handler) RequestDataExport(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "RequestDataExport")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.svc.DataExport.RequestDataExport(c, userID); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// SetUserState sets state of current user account: active, deactivated or hidden.
+func (h *Handler) SetUserState(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "SetUserState")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var req models.UserState
+	if err := c.BindJSON(&req); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.svc.User.SetUserState(c, userID, req.State); err != nil {
 		h.newErrorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
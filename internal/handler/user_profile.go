@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	ierrors "github.com/l-orlov/matcha/internal/errors"
 	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
 )
 
 func (h *Handler) GetUserProfileByID(c *gin.Context) {
@@ -21,7 +22,13 @@ func (h *Handler) GetUserProfileByID(c *gin.Context) {
 		return
 	}
 
-	user, err := h.svc.UserProfile.GetUserProfileByID(c, id)
+	viewerID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	user, err := h.svc.UserProfile.GetUserProfileByID(c, viewerID, id)
 	if err != nil {
 		h.newErrorResponse(c, http.StatusInternalServerError, err)
 		return
@@ -119,7 +126,54 @@ func (h *Handler) UploadUserPicture(c *gin.Context) {
 		return
 	}
 
-	if err = h.svc.UserProfile.UploadUserPicture(c, userID, file); err != nil {
+	if err = h.svc.UserProfile.UploadUserPicture(c, userID, file, c.PostForm("caption")); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) CreatePictureUploadURL(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "CreatePictureUploadURL")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var request models.PictureUploadRequest
+	if err := c.BindJSON(&request); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	upload, err := h.svc.UserProfile.CreatePictureUploadURL(c, userID, request)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, upload)
+}
+
+func (h *Handler) FinalizePictureUpload(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "FinalizePictureUpload")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var finalization models.PictureUploadFinalization
+	if err := c.BindJSON(&finalization); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.svc.UserProfile.FinalizePictureUpload(c, userID, finalization); err != nil {
 		h.newErrorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
@@ -136,23 +190,114 @@ func (h *Handler) GetUserPictures(c *gin.Context) {
 		return
 	}
 
-	users, err := h.svc.UserProfile.GetUserPicturesByUserID(c, userID)
+	var pageReq pagination.Request
+	if err := c.BindQuery(&pageReq); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	users, nextCursor, err := h.svc.UserProfile.GetUserPicturesByUserID(c, userID, pageReq)
 	if err != nil {
 		h.newErrorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
 
 	if users == nil {
-		c.JSON(http.StatusOK, []struct{}{})
+		c.JSON(http.StatusOK, pagination.Page{Items: []struct{}{}})
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, pagination.Page{Items: users, NextCursor: nextCursor})
+}
+
+func (h *Handler) ReorderUserPictures(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "ReorderUserPictures")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var order models.UserPicturesOrder
+	if err := c.BindJSON(&order); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.svc.UserProfile.ReorderUserPictures(c, userID, order.UUIDs); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) UpdateUserPictureCaption(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "UpdateUserPictureCaption")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	pictureUUID, err := uuid.Parse(c.Query("uuid"))
+	if err != nil {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrNotValidUUIDParameter, ""),
+		)
+		return
+	}
+
+	var caption models.UserPictureCaption
+	if err := c.BindJSON(&caption); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.svc.UserProfile.UpdateUserPictureCaption(c, userID, pictureUUID, caption.Caption); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) PromoteUserPictureToAvatar(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "PromoteUserPictureToAvatar")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	pictureUUID, err := uuid.Parse(c.Query("uuid"))
+	if err != nil {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrNotValidUUIDParameter, ""),
+		)
+		return
+	}
+
+	if err := h.svc.UserProfile.PromoteUserPictureToAvatar(c, userID, pictureUUID); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
 }
 
 func (h *Handler) DeleteUserPicture(c *gin.Context) {
 	setHandlerNameToLogEntry(c, "DeleteUserPicture")
 
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
 	pictureUUIDStr := c.Query("uuid")
 	if pictureUUIDStr == "" {
 		h.newErrorResponse(c, http.StatusBadRequest, ErrNotValidUUIDParameter)
@@ -165,7 +310,7 @@ func (h *Handler) DeleteUserPicture(c *gin.Context) {
 		return
 	}
 
-	if err := h.svc.UserProfile.DeleteUserPicture(c, pictureUUID); err != nil {
+	if err := h.svc.UserProfile.DeleteUserPicture(c, userID, pictureUUID); err != nil {
 		h.newErrorResponse(c, http.StatusInternalServerError, err)
 		return
 	}
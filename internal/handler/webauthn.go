@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
+)
+
+func (h *Handler) BeginPasskeyRegistration(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "BeginPasskeyRegistration")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	options, err := h.svc.WebAuthn.BeginPasskeyRegistration(c, userID)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (h *Handler) FinishPasskeyRegistration(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "FinishPasskeyRegistration")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := h.svc.WebAuthn.FinishPasskeyRegistration(c, userID, c.Request.Body); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) GetPasskeys(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "GetPasskeys")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var pageReq pagination.Request
+	if err := c.BindQuery(&pageReq); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	passkeys, nextCursor, err := h.svc.WebAuthn.GetPasskeys(c, userID, pageReq)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if passkeys == nil {
+		c.JSON(http.StatusOK, pagination.Page{Items: []struct{}{}})
+		return
+	}
+
+	c.JSON(http.StatusOK, pagination.Page{Items: passkeys, NextCursor: nextCursor})
+}
+
+func (h *Handler) DeletePasskey(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "DeletePasskey")
+
+	userID, err := getUserIDFromContext(c)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// id is encoded in the same way as in GetPasskeys response
+	credentialID, err := base64.StdEncoding.DecodeString(c.Query("id"))
+	if err != nil || len(credentialID) == 0 {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrNotValidIDEncoding, ""),
+		)
+		return
+	}
+
+	if err := h.svc.WebAuthn.DeletePasskey(c, userID, credentialID); err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) BeginPasskeySignIn(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "BeginPasskeySignIn")
+
+	var req models.PasskeySignInRequest
+	if err := c.BindJSON(&req); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	options, sessionID, err := h.svc.WebAuthn.BeginPasskeyLogin(c, req.Username)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"sessionId": sessionID,
+		"options":   options,
+	})
+}
+
+// SignInByPasskey completes passwordless sign in. Passkey is strong factor by itself,
+// so two-factor authentication is not required here.
+func (h *Handler) SignInByPasskey(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "SignInByPasskey")
+
+	sessionID, ok := c.GetQuery("session")
+	if !ok || sessionID == "" {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrEmptySessionParameter, ""),
+		)
+		return
+	}
+
+	userID, err := h.svc.AuthenticateUserByPasskey(c, sessionID, c.Request.Body, c.Query("fingerprint"))
+	if err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	h.createSession(c, userID)
+}
+
+func (h *Handler) BeginPasskeyTwoFactor(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "BeginPasskeyTwoFactor")
+
+	var req models.PasskeyTwoFactorRequest
+	if err := c.BindJSON(&req); err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	options, err := h.svc.WebAuthn.BeginPasskeyTwoFactor(c, req.ChallengeToken)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+func (h *Handler) SignInByPasskeyTwoFactor(c *gin.Context) {
+	setHandlerNameToLogEntry(c, "SignInByPasskeyTwoFactor")
+
+	challengeToken, ok := c.GetQuery("challengeToken")
+	if !ok || challengeToken == "" {
+		h.newErrorResponse(
+			c, http.StatusBadRequest, ierrors.NewBusiness(ErrEmptyTokenParameter, ""),
+		)
+		return
+	}
+
+	userID, err := h.svc.AuthenticateUserByPasskeyTwoFactor(
+		c, challengeToken, c.Request.Body, c.Query("fingerprint"),
+	)
+	if err != nil {
+		h.newErrorResponse(c, http.StatusBadRequest, err)
+		return
+	}
+
+	h.createSession(c, userID)
+}
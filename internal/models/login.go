@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type (
+	// LoginInfo describes client of successful login.
+	LoginInfo struct {
+		IP        string
+		UserAgent string
+		Country   string
+	}
+	UserLoginDevice struct {
+		UserID     uint64    `json:"userId" db:"user_id"`
+		DeviceHash string    `json:"-" db:"device_hash"`
+		Country    string    `json:"country" db:"country"`
+		UserAgent  string    `json:"userAgent" db:"user_agent"`
+		LastIP     string    `json:"lastIp" db:"last_ip"`
+		CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+		LastSeenAt time.Time `json:"lastSeenAt" db:"last_seen_at"`
+	}
+)
@@ -0,0 +1,23 @@
+package models
+
+type (
+	OAuthState struct {
+		Provider     string `json:"provider"`
+		CodeVerifier string `json:"codeVerifier"`
+		Nonce        string `json:"nonce,omitempty"`
+	}
+	OAuthUserInfo struct {
+		Subject         string
+		Email           string
+		IsEmailVerified bool
+		Username        string
+		FirstName       string
+		LastName        string
+	}
+	UserIdentity struct {
+		Provider string `json:"provider" db:"provider"`
+		Subject  string `json:"subject" db:"subject"`
+		UserID   uint64 `json:"userId" db:"user_id"`
+		Email    string `json:"email" db:"email"`
+	}
+)
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Keys of list items for keyset pagination. Page starts after item with key in list order.
+// Every key ends with primary key, so order is stable when other columns are equal.
+type (
+	UserPictureKey struct {
+		Position int       `json:"p"`
+		UUID     uuid.UUID `json:"u"`
+	}
+	PendingPictureKey struct {
+		CreatedAt time.Time `json:"t"`
+		UUID      uuid.UUID `json:"u"`
+	}
+	PictureMatchKey struct {
+		CreatedAt time.Time `json:"t"`
+		ID        uint64    `json:"i"`
+	}
+	WebAuthnCredentialKey struct {
+		CreatedAt time.Time `json:"t"`
+		ID        []byte    `json:"i"`
+	}
+)
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// PictureVariants are paths of resized copies of picture by variant name.
+type PictureVariants map[string]string
+
+func (v PictureVariants) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("{}"), nil
+	}
+
+	return json.Marshal(v)
+}
+
+func (v *PictureVariants) Scan(src interface{}) error {
+	var data []byte
+	switch value := src.(type) {
+	case []byte:
+		data = value
+	case string:
+		data = []byte(value)
+	case nil:
+		*v = nil
+		return nil
+	default:
+		return errors.Errorf("unsupported type of picture variants: %T", src)
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -1,10 +1,29 @@
 package models
 
+import (
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type Session struct {
+	ID            string `json:"id"`
 	UserID        string `json:"userId"`
 	AccessTokenID string `json:"accessTokenId"`
 }
 
+// AccessTokenClaims is a set of claims that access token carries.
+type AccessTokenClaims struct {
+	UserID           uint64   `json:"uid"`
+	Roles            []string `json:"roles,omitempty"`
+	SessionID        string   `json:"sid"`
+	IsEmailConfirmed bool     `json:"emailConfirmed"`
+	jwt.RegisteredClaims
+}
+
 type ValidateAccessTokenRequest struct {
 	AccessToken string `json:"accessToken" binding:"required"`
 }
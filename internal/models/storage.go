@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// StorageUploadFileField is name of form field with uploaded file.
+const StorageUploadFileField = "file"
+
+type (
+	StorageObject struct {
+		Name         string
+		Size         int64
+		ContentType  string
+		LastModified time.Time
+	}
+	// StorageBucketSettings are applied to bucket on provisioning.
+	StorageBucketSettings struct {
+		// PublicReadPrefixes are prefixes of objects which can be read without signed URL.
+		PublicReadPrefixes []string
+		LifecycleRules     []StorageLifecycleRule
+	}
+	// StorageUpload is presigned form for uploading object directly to storage. It is sent by POST as multipart form
+	// with Fields followed by file, so storage checks content type and size before the file is stored.
+	StorageUpload struct {
+		URL    string
+		Fields map[string]string
+	}
+	StorageLifecycleRule struct {
+		Prefix         string
+		ExpirationDays int
+	}
+	// SignedPictureRequest is request of picture by URL signed by application.
+	SignedPictureRequest struct {
+		Path      string
+		Version   string
+		Expires   int64
+		Signature string
+	}
+)
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/lib/pq"
+)
+
+type (
+	UserTwoFactor struct {
+		UserID        uint64         `db:"user_id"`
+		Secret        []byte         `db:"secret"`
+		IsEnabled     bool           `db:"is_enabled"`
+		RecoveryCodes pq.StringArray `db:"recovery_codes"`
+	}
+	TwoFactorEnrollment struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioningURI"`
+	}
+	TwoFactorCodeRequest struct {
+		Code string `json:"code" binding:"required"`
+	}
+	TwoFactorDisableRequest struct {
+		Password string `json:"password" binding:"required"`
+	}
+	UserToSignInByTwoFactor struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+		Fingerprint    string `json:"fingerprint" binding:"required"`
+	}
+)
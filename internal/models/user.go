@@ -1,10 +1,32 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	UserStateActive      = "active"
+	UserStateDeactivated = "deactivated"
+	UserStateHidden      = "hidden"
+	UserStateDeleted     = "deleted"
+
+	// Gender and sexual preferences are zero until user sets them.
+	GenderMale   = 1
+	GenderFemale = 2
+
+	SexualPreferencesHeterosexual = 1
+	SexualPreferencesHomosexual   = 2
+	SexualPreferencesBisexual     = 3
+
+	// Pending pictures are hidden from other users until moderator approves them.
+	PictureModerationApproved = "approved"
+	PictureModerationPending  = "pending"
+)
+
 type (
 	UserToCreate struct {
 		Email     string `json:"email" binding:"required"`
@@ -18,14 +40,26 @@ type (
 		Password    string `json:"password" binding:"required"`
 		Fingerprint string `json:"fingerprint" binding:"required"`
 	}
+	UserToSignInByMagicLink struct {
+		Email       string `json:"email" binding:"required"`
+		Fingerprint string `json:"fingerprint" binding:"required"`
+	}
 	User struct {
-		ID               uint64 `json:"id" binding:"required" db:"id"`
-		Email            string `json:"email" binding:"required" db:"email"`
-		Username         string `json:"username" binding:"required" db:"username"`
-		FirstName        string `json:"firstName" binding:"required" db:"first_name"`
-		LastName         string `json:"lastName" binding:"required" db:"last_name"`
-		Password         string `json:"-" db:"password"`
-		IsEmailConfirmed bool   `json:"isEmailConfirmed" db:"is_email_confirmed"`
+		ID               uint64         `json:"id" binding:"required" db:"id"`
+		Email            string         `json:"email" binding:"required" db:"email"`
+		Username         string         `json:"username" binding:"required" db:"username"`
+		FirstName        string         `json:"firstName" binding:"required" db:"first_name"`
+		LastName         string         `json:"lastName" binding:"required" db:"last_name"`
+		Password         string         `json:"-" db:"password"`
+		IsEmailConfirmed bool           `json:"isEmailConfirmed" db:"is_email_confirmed"`
+		Roles            pq.StringArray `json:"roles" db:"roles"`
+		State            string         `json:"state" db:"state"`
+	}
+	UserState struct {
+		State string `json:"state" binding:"required"`
+	}
+	AccountDeletionRequest struct {
+		Password string `json:"password" binding:"required"`
 	}
 	UserPassword struct {
 		ID       uint64 `json:"id" binding:"required"`
@@ -37,28 +71,105 @@ type (
 		NewPassword string `json:"newPassword" binding:"required"`
 	}
 	UserProfile struct {
-		ID                uint64        `json:"id" binding:"required"`
-		Email             string        `json:"email" binding:"required"`
-		Username          string        `json:"username" binding:"required"`
-		FirstName         string        `json:"firstName" binding:"required"`
-		LastName          string        `json:"lastName" binding:"required"`
-		IsEmailConfirmed  bool          `json:"isEmailConfirmed"`
-		Gender            int           `json:"gender"`
-		SexualPreferences int           `json:"sexualPreferences"`
-		Biography         string        `json:"biography"`
-		Tags              []string      `json:"tags"`
-		AvatarPath        string        `json:"avatarPath"`
-		AvatarURL         string        `json:"avatarURL"`
-		Pictures          []UserPicture `json:"pictures"`
-		LikesNum          int           `json:"likesNum"`
-		ViewsNum          int           `json:"viewsNum"`
-		GPSPosition       string        `json:"gpsPosition"`
+		ID                uint64            `json:"id" binding:"required"`
+		Email             string            `json:"email" binding:"required"`
+		Username          string            `json:"username" binding:"required"`
+		FirstName         string            `json:"firstName" binding:"required"`
+		LastName          string            `json:"lastName" binding:"required"`
+		IsEmailConfirmed  bool              `json:"isEmailConfirmed"`
+		Gender            int               `json:"gender"`
+		SexualPreferences int               `json:"sexualPreferences"`
+		Biography         string            `json:"biography"`
+		Tags              []string          `json:"tags"`
+		AvatarPath        string            `json:"avatarPath"`
+		AvatarURL         string            `json:"avatarURL"`
+		AvatarVariants    PictureVariants   `json:"-"`
+		AvatarContentHash string            `json:"-"`
+		AvatarModeration  string            `json:"avatarModerationStatus"`
+		AvatarVariantURLs map[string]string `json:"avatarVariantURLs"`
+		Pictures          []UserPicture     `json:"pictures"`
+		LikesNum          int               `json:"likesNum"`
+		ViewsNum          int               `json:"viewsNum"`
+		GPSPosition       string            `json:"gpsPosition"`
+		State             string            `json:"state"`
+	}
+	// SeedUser is fake user generated for load testing and demos. Users are inserted with reserved ids,
+	// so their avatars are uploaded before.
+	SeedUser struct {
+		UserProfile
+		Password             string
+		AvatarPerceptualHash int64
 	}
 	UserPicture struct {
-		UUID        uuid.UUID `json:"uuid" db:"uuid"`
-		UserID      uint64    `json:"userId" db:"user_id"`
-		PicturePath string    `json:"picturePath" db:"picture_path"`
-		PictureURL  string    `json:"pictureURL"`
+		UUID           uuid.UUID         `json:"uuid" db:"uuid"`
+		UserID         uint64            `json:"userId" db:"user_id"`
+		PicturePath    string            `json:"picturePath" db:"picture_path"`
+		PictureURL     string            `json:"pictureURL"`
+		Variants       PictureVariants   `json:"-" db:"variants"`
+		VariantURLs    map[string]string `json:"variantURLs"`
+		ContentHash    string            `json:"-" db:"content_hash"`
+		PerceptualHash int64             `json:"-" db:"perceptual_hash"`
+		Position       int               `json:"position" db:"position"`
+		Caption        string            `json:"caption" db:"caption"`
+		Moderation     string            `json:"moderationStatus" db:"moderation_status"`
+		CreatedAt      time.Time         `json:"createdAt" db:"created_at"`
+	}
+	UserAvatar struct {
+		UserID         uint64            `json:"userId" db:"id"`
+		Path           string            `json:"avatarPath" db:"avatar_path"`
+		URL            string            `json:"avatarURL"`
+		Variants       PictureVariants   `json:"-" db:"avatar_variants"`
+		VariantURLs    map[string]string `json:"avatarVariantURLs"`
+		ContentHash    string            `json:"contentHash" db:"avatar_content_hash"`
+		Moderation     string            `json:"moderationStatus" db:"avatar_moderation_status"`
+		PerceptualHash int64             `json:"-" db:"avatar_perceptual_hash"`
+	}
+	// PictureHash is perceptual hash of user picture or avatar. Hash is zero if it is unknown.
+	PictureHash struct {
+		UserID         uint64 `db:"user_id"`
+		PicturePath    string `db:"picture_path"`
+		PerceptualHash int64  `db:"perceptual_hash"`
+	}
+	// PictureMatch is picture which is near-duplicate of picture of another user. It is signal of fake account.
+	PictureMatch struct {
+		ID                 uint64    `json:"id" db:"id"`
+		UserID             uint64    `json:"userId" db:"user_id"`
+		PicturePath        string    `json:"picturePath" db:"picture_path"`
+		PictureURL         string    `json:"pictureURL"`
+		MatchedUserID      uint64    `json:"matchedUserId" db:"matched_user_id"`
+		MatchedPicturePath string    `json:"matchedPicturePath" db:"matched_picture_path"`
+		MatchedPictureURL  string    `json:"matchedPictureURL"`
+		Distance           int       `json:"distance" db:"distance"`
+		CreatedAt          time.Time `json:"createdAt" db:"created_at"`
+	}
+	// UserAvatarReview identifies reviewed avatar. Content hash guards from reviewing avatar replaced meanwhile.
+	UserAvatarReview struct {
+		UserID      uint64 `json:"userId" binding:"required"`
+		ContentHash string `json:"contentHash" binding:"required"`
+	}
+	UserPicturesOrder struct {
+		UUIDs []uuid.UUID `json:"uuids" binding:"required"`
+	}
+	UserPictureCaption struct {
+		Caption string `json:"caption"`
+	}
+	PictureUploadRequest struct {
+		ContentType string `json:"contentType" binding:"required"`
+		Size        int64  `json:"size" binding:"required"`
+	}
+	// PictureUpload describes request which client must make to upload picture directly to storage:
+	// multipart form with Fields followed by file in FileField.
+	PictureUpload struct {
+		UUID      uuid.UUID         `json:"uuid"`
+		URL       string            `json:"url"`
+		Method    string            `json:"method"`
+		Fields    map[string]string `json:"fields"`
+		FileField string            `json:"fileField"`
+		ExpiresAt time.Time         `json:"expiresAt"`
+	}
+	PictureUploadFinalization struct {
+		UUID    uuid.UUID `json:"uuid" binding:"required"`
+		Caption string    `json:"caption"`
 	}
 )
 
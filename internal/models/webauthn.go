@@ -0,0 +1,33 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+type (
+	WebAuthnCredential struct {
+		ID              []byte       `json:"id" db:"id"`
+		UserID          uint64       `json:"userId" db:"user_id"`
+		PublicKey       []byte       `json:"-" db:"public_key"`
+		AttestationType string       `json:"attestationType" db:"attestation_type"`
+		AAGUID          []byte       `json:"aaguid" db:"aaguid"`
+		SignCount       uint32       `json:"signCount" db:"sign_count"`
+		Flags           int16        `json:"-" db:"flags"`
+		CreatedAt       time.Time    `json:"createdAt" db:"created_at"`
+		LastUsedAt      sql.NullTime `json:"-" db:"last_used_at"`
+	}
+	// WebAuthnSession is data of started registration or assertion ceremony.
+	WebAuthnSession struct {
+		UserID uint64               `json:"userId"`
+		Data   webauthn.SessionData `json:"data"`
+	}
+	PasskeySignInRequest struct {
+		Username string `json:"username" binding:"required"`
+	}
+	PasskeyTwoFactorRequest struct {
+		ChallengeToken string `json:"challengeToken" binding:"required"`
+	}
+)
@@ -0,0 +1,113 @@
+// Package pagination implements keyset pagination of list endpoints. Client gets opaque cursor of the next
+// page, which is key of the last item signed with list name, and passes it back to get items after key.
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotValidCursor = errors.New("not valid cursor")
+	ErrNotValidLimit  = errors.New("not valid limit")
+)
+
+type (
+	// Request is page requested by client. Empty cursor requests the first page, zero limit the default one.
+	Request struct {
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+	// Page is page of list returned to client. NextCursor is empty on the last page.
+	Page struct {
+		Items      interface{} `json:"items"`
+		NextCursor string      `json:"nextCursor,omitempty"`
+	}
+	Paginator struct {
+		signKey      []byte
+		defaultLimit int
+		maxLimit     int
+	}
+)
+
+func New(cfg config.Pagination) *Paginator {
+	return &Paginator{
+		signKey:      cfg.SignKey,
+		defaultLimit: cfg.DefaultLimit,
+		maxLimit:     cfg.MaxLimit,
+	}
+}
+
+// Limit returns number of items on requested page, it is capped by max limit.
+func (p *Paginator) Limit(req Request) (int, error) {
+	switch {
+	case req.Limit < 0:
+		return 0, ErrNotValidLimit
+	case req.Limit == 0:
+		return p.defaultLimit, nil
+	case req.Limit > p.maxLimit:
+		return p.maxLimit, nil
+	default:
+		return req.Limit, nil
+	}
+}
+
+// DecodeCursor verifies that cursor was issued for list and decodes item key from it.
+// It returns false if cursor is empty, i.e. the first page is requested.
+func (p *Paginator) DecodeCursor(list, cursor string, key interface{}) (bool, error) {
+	if cursor == "" {
+		return false, nil
+	}
+
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 2 {
+		return false, ErrNotValidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false, ErrNotValidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false, ErrNotValidCursor
+	}
+
+	if !hmac.Equal(signature, p.sign(list, payload)) {
+		return false, ErrNotValidCursor
+	}
+
+	if err := json.Unmarshal(payload, key); err != nil {
+		return false, ErrNotValidCursor
+	}
+
+	return true, nil
+}
+
+// EncodeCursor returns cursor of list page which starts after item with key.
+func (p *Paginator) EncodeCursor(list string, key interface{}) (string, error) {
+	payload, err := json.Marshal(key)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal cursor key")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(p.sign(list, payload)), nil
+}
+
+// sign signs payload with list name, so cursor of one list can not be used for another one.
+func (p *Paginator) sign(list string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.signKey)
+	mac.Write([]byte(list))
+	mac.Write([]byte{'\n'})
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,517 @@
+package local
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository/uploadform"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// URLPath is path prefix of signed URLs. Storage must be served by this path.
+	URLPath = "/files/"
+
+	objectsDir = "objects"
+	metaDir    = "meta"
+
+	tmpFilePrefix = ".tmp-"
+
+	expiresParam   = "expires"
+	maxSizeParam   = "maxSize"
+	signatureParam = "signature"
+
+	contentTypeField = "Content-Type"
+)
+
+var (
+	ErrNotValidObjectName = errors.New("not valid object name")
+	ErrFileNotFound       = errors.New("file not found")
+)
+
+// StorageLocal keeps files in local directory. It is intended for local development.
+// Objects are served by signed URLs through ServeHTTP, so file URLs and upload forms work like presigned ones of S3.
+// Object name can not be both a file and a prefix of another object followed by slash.
+type StorageLocal struct {
+	config Config
+	log    *logrus.Entry
+}
+
+type Config struct {
+	RootDir string
+	// BaseURL is external address of application, URLPath is appended to it.
+	BaseURL       string
+	SignKey       []byte
+	MaxUploadSize int64
+}
+
+// uploadPolicy is constraints of upload form which are signed together with its URL.
+type uploadPolicy struct {
+	contentType string
+	maxSize     int64
+}
+
+type objectMeta struct {
+	ContentType string `json:"contentType"`
+}
+
+func New(config Config, log *logrus.Entry) (*StorageLocal, error) {
+	if len(config.SignKey) == 0 {
+		return nil, errors.New("empty local storage sign key")
+	}
+
+	if err := os.MkdirAll(config.RootDir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "failed to create local storage root dir")
+	}
+
+	return &StorageLocal{
+		config: config,
+		log:    log,
+	}, nil
+}
+
+// ProvisionBucket creates bucket directory if it does not exist. Settings are not applied:
+// objects are available only by signed URLs, and temporary files are deleted by storage reconciler.
+func (s *StorageLocal) ProvisionBucket(_ context.Context, bucket string, _ models.StorageBucketSettings) error {
+	bucketDir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(bucketDir, objectsDir), 0o755); err != nil {
+		return errors.Wrap(err, "failed to create local storage bucket")
+	}
+
+	return nil
+}
+
+func (s *StorageLocal) PutFile(
+	_ context.Context, bucketName, objectName, contentType string, reader io.Reader,
+) error {
+	objectPath, metaPath, err := s.objectPaths(bucketName, objectName)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(objectPath, reader); err != nil {
+		return errors.Wrap(err, "failed to put to local storage")
+	}
+
+	meta, err := json.Marshal(objectMeta{ContentType: contentType})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(metaPath, strings.NewReader(string(meta))); err != nil {
+		return errors.Wrap(err, "failed to put object meta to local storage")
+	}
+
+	return nil
+}
+
+func (s *StorageLocal) GetFile(_ context.Context, bucket, objectName string) ([]byte, error) {
+	objectPath, _, err := s.objectPaths(bucket, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(objectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrFileNotFound
+		}
+
+		return nil, errors.Wrap(err, "failed to read from local storage")
+	}
+
+	return data, nil
+}
+
+func (s *StorageLocal) GetFileURL(
+	_ context.Context, bucket, objectName string, expires time.Duration,
+) (url string, err error) {
+	return s.signedURL(http.MethodGet, bucket, objectName, expires, nil)
+}
+
+// GetFileUpload returns upload form which URL is signed together with content type and max size,
+// so like POST policy of S3 it accepts only file matching them.
+func (s *StorageLocal) GetFileUpload(
+	_ context.Context, bucket, objectName, contentType string, maxSize int64, expires time.Duration,
+) (*models.StorageUpload, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("not valid upload max size")
+	}
+
+	url, err := s.signedURL(http.MethodPost, bucket, objectName, expires, &uploadPolicy{
+		contentType: contentType,
+		maxSize:     maxSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.StorageUpload{
+		URL:    url,
+		Fields: map[string]string{contentTypeField: contentType},
+	}, nil
+}
+
+func (s *StorageLocal) GetFileInfo(_ context.Context, bucket, objectName string) (*models.StorageObject, error) {
+	objectPath, metaPath, err := s.objectPaths(bucket, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, nil
+	}
+
+	return &models.StorageObject{
+		Name:         objectName,
+		Size:         info.Size(),
+		ContentType:  readContentType(metaPath),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (s *StorageLocal) CopyFile(ctx context.Context, bucket, srcObjectName, dstObjectName string) error {
+	srcObjectPath, srcMetaPath, err := s.objectPaths(bucket, srcObjectName)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(srcObjectPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrFileNotFound
+		}
+
+		return err
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			s.log.Error(err)
+		}
+	}()
+
+	return s.PutFile(ctx, bucket, dstObjectName, readContentType(srcMetaPath), src)
+}
+
+func (s *StorageLocal) DeleteFile(_ context.Context, bucket, objectName string) error {
+	objectPath, metaPath, err := s.objectPaths(bucket, objectName)
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range []string{objectPath, metaPath} {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListFiles returns all objects which names start with prefix ordered by name.
+func (s *StorageLocal) ListFiles(_ context.Context, bucket, prefix string) ([]models.StorageObject, error) {
+	bucketDir, err := s.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	objectsRoot := filepath.Join(bucketDir, objectsDir)
+
+	var objects []models.StorageObject
+	err = filepath.Walk(objectsRoot, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		// files being written are not objects yet
+		if info.IsDir() || strings.HasPrefix(info.Name(), tmpFilePrefix) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(objectsRoot, filePath)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		objects = append(objects, models.StorageObject{
+			Name:         name,
+			Size:         info.Size(),
+			ContentType:  readContentType(filepath.Join(bucketDir, metaDir, relPath)),
+			LastModified: info.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list local storage objects")
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+
+	return objects, nil
+}
+
+// DeleteFilesByPrefix deletes all objects which names start with prefix.
+func (s *StorageLocal) DeleteFilesByPrefix(ctx context.Context, bucket, prefix string) error {
+	objects, err := s.ListFiles(ctx, bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	for i := range objects {
+		if err := s.DeleteFile(ctx, bucket, objects[i].Name); err != nil {
+			return errors.Wrapf(err, "failed to remove object %s", objects[i].Name)
+		}
+	}
+
+	return nil
+}
+
+// ServeHTTP serves GET requests and POST upload forms by signed URLs.
+func (s *StorageLocal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, objectName, ok := parseURLPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get(expiresParam), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		s.serveUpload(w, r, bucket, objectName, expires)
+		return
+	}
+
+	if !s.checkSignature(query, r.Method, bucket, objectName, expires, nil) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	info, err := s.GetFileInfo(r.Context(), bucket, objectName)
+	if err != nil {
+		s.log.Errorf("failed to get file info of %s/%s: %v", bucket, objectName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if info == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+
+	objectPath, _, _ := s.objectPaths(bucket, objectName)
+	http.ServeFile(w, r, objectPath)
+}
+
+// serveUpload stores file of upload form. Signature covers content type field and max size,
+// so it is checked after form fields are read and before file is read.
+func (s *StorageLocal) serveUpload(w http.ResponseWriter, r *http.Request, bucket, objectName string, expires int64) {
+	query := r.URL.Query()
+	policy := uploadPolicy{}
+	policy.maxSize, _ = strconv.ParseInt(query.Get(maxSizeParam), 10, 64)
+	if policy.maxSize <= 0 {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	maxSize := policy.maxSize
+	if s.config.MaxUploadSize > 0 && maxSize > s.config.MaxUploadSize {
+		maxSize = s.config.MaxUploadSize
+	}
+
+	form, err := uploadform.Read(r, maxSize)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	policy.contentType = form.Fields[contentTypeField]
+	if !s.checkSignature(query, r.Method, bucket, objectName, expires, &policy) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if err := s.PutFile(r.Context(), bucket, objectName, policy.contentType, form.File); err != nil {
+		if errors.Is(err, uploadform.ErrTooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		s.log.Errorf("failed to upload %s/%s: %v", bucket, objectName, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *StorageLocal) checkSignature(
+	query url.Values, method, bucket, objectName string, expires int64, policy *uploadPolicy,
+) bool {
+	signature := s.sign(method, bucket, objectName, expires, policy)
+
+	return hmac.Equal([]byte(query.Get(signatureParam)), []byte(signature))
+}
+
+// signedURL returns URL signed for method. Upload URL is signed with its policy,
+// max size is passed in URL and content type in form.
+func (s *StorageLocal) signedURL(
+	method, bucket, objectName string, expires time.Duration, policy *uploadPolicy,
+) (string, error) {
+	if _, _, err := s.objectPaths(bucket, objectName); err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(expires).Unix()
+
+	query := url.Values{}
+	query.Set(expiresParam, strconv.FormatInt(expiresAt, 10))
+	if policy != nil {
+		query.Set(maxSizeParam, strconv.FormatInt(policy.maxSize, 10))
+	}
+	query.Set(signatureParam, s.sign(method, bucket, objectName, expiresAt, policy))
+
+	u := url.URL{Path: URLPath + bucket + "/" + objectName}
+
+	return strings.TrimSuffix(s.config.BaseURL, "/") + u.EscapedPath() + "?" + query.Encode(), nil
+}
+
+func (s *StorageLocal) sign(method, bucket, objectName string, expires int64, policy *uploadPolicy) string {
+	mac := hmac.New(sha256.New, s.config.SignKey)
+	mac.Write([]byte(method + "\n" + bucket + "\n" + objectName + "\n" + strconv.FormatInt(expires, 10)))
+	if policy != nil {
+		mac.Write([]byte("\n" + policy.contentType + "\n" + strconv.FormatInt(policy.maxSize, 10)))
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// objectPaths returns paths of object file and its meta file. Names which could escape root dir are rejected.
+func (s *StorageLocal) objectPaths(bucket, objectName string) (objectPath, metaPath string, err error) {
+	bucketDir, err := s.bucketDir(bucket)
+	if err != nil {
+		return "", "", err
+	}
+
+	if objectName == "" || strings.HasSuffix(objectName, "/") || path.Clean("/"+objectName) != "/"+objectName {
+		return "", "", ErrNotValidObjectName
+	}
+
+	relPath := filepath.FromSlash(objectName)
+
+	return filepath.Join(bucketDir, objectsDir, relPath), filepath.Join(bucketDir, metaDir, relPath), nil
+}
+
+func (s *StorageLocal) bucketDir(bucket string) (string, error) {
+	if bucket == "" || bucket == "." || bucket == ".." || strings.ContainsAny(bucket, `/\`) {
+		return "", errors.New("not valid bucket name")
+	}
+
+	return filepath.Join(s.config.RootDir, bucket), nil
+}
+
+func parseURLPath(urlPath string) (bucket, objectName string, ok bool) {
+	if !strings.HasPrefix(urlPath, URLPath) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(urlPath, URLPath), "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// writeFile writes file through temporary file, so readers never see partially written file.
+func writeFile(filePath string, reader io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), tmpFilePrefix)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmp, reader); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
+
+func readContentType(metaPath string) string {
+	data, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return ""
+	}
+
+	var meta objectMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return ""
+	}
+
+	return meta.ContentType
+}
@@ -0,0 +1,41 @@
+package local_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/l-orlov/matcha/internal/repository/local"
+	"github.com/l-orlov/matcha/internal/repository/storagetest"
+	"github.com/sirupsen/logrus"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		log := logrus.New()
+		log.SetOutput(ioutil.Discard)
+
+		// upload forms are posted to storage served by test server
+		var handler http.Handler = http.NotFoundHandler()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+
+		storage, err := local.New(local.Config{
+			RootDir:       t.TempDir(),
+			BaseURL:       server.URL,
+			SignKey:       []byte("test"),
+			MaxUploadSize: 1 << 20,
+		}, logrus.NewEntry(log))
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+
+		handler = storage
+
+		return storage
+	}, "test")
+}
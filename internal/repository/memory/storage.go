@@ -0,0 +1,341 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository/uploadform"
+	"github.com/pkg/errors"
+)
+
+const (
+	// URLScheme is scheme of storage URLs, they are served by RoundTrip.
+	URLScheme = "memory"
+
+	expiresParam = "expires"
+	uploadParam  = "upload"
+
+	contentTypeField = "Content-Type"
+)
+
+var ErrFileNotFound = errors.New("file not found")
+
+// StorageMemory keeps files in memory. It is intended for tests.
+// Its URLs are served by RoundTrip, so it can be used as transport of HTTP client which follows them.
+type StorageMemory struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string]object
+	// uploads are upload forms policies by tokens of their URLs
+	uploads map[string]uploadPolicy
+}
+
+type uploadPolicy struct {
+	bucket      string
+	objectName  string
+	contentType string
+	maxSize     int64
+	expiresAt   time.Time
+}
+
+type object struct {
+	data         []byte
+	contentType  string
+	lastModified time.Time
+}
+
+func New() *StorageMemory {
+	return &StorageMemory{
+		buckets: make(map[string]map[string]object),
+		uploads: make(map[string]uploadPolicy),
+	}
+}
+
+// ProvisionBucket creates bucket if it does not exist. Settings are not applied.
+func (s *StorageMemory) ProvisionBucket(_ context.Context, bucket string, _ models.StorageBucketSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket]; !ok {
+		s.buckets[bucket] = make(map[string]object)
+	}
+
+	return nil
+}
+
+func (s *StorageMemory) PutFile(
+	_ context.Context, bucketName, objectName, contentType string, reader io.Reader,
+) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return errors.Wrap(err, "failed to read file to put to memory")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.putObject(bucketName, objectName, object{
+		data:         data,
+		contentType:  contentType,
+		lastModified: time.Now(),
+	})
+
+	return nil
+}
+
+func (s *StorageMemory) GetFile(_ context.Context, bucket, objectName string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.buckets[bucket][objectName]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+
+	return append([]byte(nil), obj.data...), nil
+}
+
+func (s *StorageMemory) GetFileURL(
+	_ context.Context, bucket, objectName string, expires time.Duration,
+) (url string, err error) {
+	return fileURL(bucket, objectName, expires), nil
+}
+
+// GetFileUpload returns upload form which policy is kept in storage,
+// so like POST policy of S3 it accepts only file matching content type and max size.
+func (s *StorageMemory) GetFileUpload(
+	_ context.Context, bucket, objectName, contentType string, maxSize int64, expires time.Duration,
+) (*models.StorageUpload, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("not valid upload max size")
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	s.mu.Lock()
+	s.uploads[token] = uploadPolicy{
+		bucket:      bucket,
+		objectName:  objectName,
+		contentType: contentType,
+		maxSize:     maxSize,
+		expiresAt:   time.Now().Add(expires),
+	}
+	s.mu.Unlock()
+
+	u := objectURL(bucket, objectName)
+	u.RawQuery = url.Values{uploadParam: {token}}.Encode()
+
+	return &models.StorageUpload{
+		URL:    u.String(),
+		Fields: map[string]string{contentTypeField: contentType},
+	}, nil
+}
+
+func (s *StorageMemory) GetFileInfo(_ context.Context, bucket, objectName string) (*models.StorageObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.buckets[bucket][objectName]
+	if !ok {
+		return nil, nil
+	}
+
+	info := obj.info(objectName)
+
+	return &info, nil
+}
+
+func (s *StorageMemory) CopyFile(_ context.Context, bucket, srcObjectName, dstObjectName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.buckets[bucket][srcObjectName]
+	if !ok {
+		return ErrFileNotFound
+	}
+
+	obj.lastModified = time.Now()
+	s.putObject(bucket, dstObjectName, obj)
+
+	return nil
+}
+
+func (s *StorageMemory) DeleteFile(_ context.Context, bucket, objectName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buckets[bucket], objectName)
+
+	return nil
+}
+
+// ListFiles returns all objects which names start with prefix ordered by name.
+func (s *StorageMemory) ListFiles(_ context.Context, bucket, prefix string) ([]models.StorageObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var objects []models.StorageObject
+	for name, obj := range s.buckets[bucket] {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, obj.info(name))
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+
+	return objects, nil
+}
+
+// DeleteFilesByPrefix deletes all objects which names start with prefix.
+func (s *StorageMemory) DeleteFilesByPrefix(_ context.Context, bucket, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range s.buckets[bucket] {
+		if strings.HasPrefix(name, prefix) {
+			delete(s.buckets[bucket], name)
+		}
+	}
+
+	return nil
+}
+
+// RoundTrip serves GET requests and POST upload forms by storage URLs.
+func (s *StorageMemory) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+
+	if r.URL.Scheme != URLScheme {
+		return nil, errors.Errorf("not memory storage URL %s", r.URL)
+	}
+
+	bucket, objectName := r.URL.Host, strings.TrimPrefix(r.URL.Path, "/")
+
+	switch r.Method {
+	case http.MethodGet:
+		return s.serveFile(r, bucket, objectName), nil
+	case http.MethodPost:
+		return s.serveUpload(r, bucket, objectName), nil
+	default:
+		return newResponse(r, http.StatusMethodNotAllowed, "", nil), nil
+	}
+}
+
+func (s *StorageMemory) serveFile(r *http.Request, bucket, objectName string) *http.Response {
+	expires, err := strconv.ParseInt(r.URL.Query().Get(expiresParam), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return newResponse(r, http.StatusForbidden, "", nil)
+	}
+
+	s.mu.RLock()
+	obj, ok := s.buckets[bucket][objectName]
+	s.mu.RUnlock()
+
+	if !ok {
+		return newResponse(r, http.StatusNotFound, "", nil)
+	}
+
+	return newResponse(r, http.StatusOK, obj.contentType, obj.data)
+}
+
+// serveUpload stores file of upload form if it matches policy of URL.
+func (s *StorageMemory) serveUpload(r *http.Request, bucket, objectName string) *http.Response {
+	s.mu.RLock()
+	policy, ok := s.uploads[r.URL.Query().Get(uploadParam)]
+	s.mu.RUnlock()
+
+	if !ok || time.Now().After(policy.expiresAt) || policy.bucket != bucket || policy.objectName != objectName {
+		return newResponse(r, http.StatusForbidden, "", nil)
+	}
+
+	form, err := uploadform.Read(r, policy.maxSize)
+	if err != nil {
+		return newResponse(r, http.StatusBadRequest, "", nil)
+	}
+
+	if form.Fields[contentTypeField] != policy.contentType {
+		return newResponse(r, http.StatusForbidden, "", nil)
+	}
+
+	if err := s.PutFile(r.Context(), bucket, objectName, policy.contentType, form.File); err != nil {
+		if errors.Is(err, uploadform.ErrTooLarge) {
+			return newResponse(r, http.StatusRequestEntityTooLarge, "", nil)
+		}
+
+		return newResponse(r, http.StatusBadRequest, "", nil)
+	}
+
+	return newResponse(r, http.StatusNoContent, "", nil)
+}
+
+func (s *StorageMemory) putObject(bucket, objectName string, obj object) {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		objects = make(map[string]object)
+		s.buckets[bucket] = objects
+	}
+
+	objects[objectName] = obj
+}
+
+func (o object) info(name string) models.StorageObject {
+	return models.StorageObject{
+		Name:         name,
+		Size:         int64(len(o.data)),
+		ContentType:  o.contentType,
+		LastModified: o.lastModified,
+	}
+}
+
+func fileURL(bucket, objectName string, expires time.Duration) string {
+	u := objectURL(bucket, objectName)
+	u.RawQuery = url.Values{expiresParam: {strconv.FormatInt(time.Now().Add(expires).Unix(), 10)}}.Encode()
+
+	return u.String()
+}
+
+func objectURL(bucket, objectName string) *url.URL {
+	return &url.URL{
+		Scheme: URLScheme,
+		Host:   bucket,
+		Path:   "/" + objectName,
+	}
+}
+
+func newResponse(r *http.Request, status int, contentType string, body []byte) *http.Response {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
@@ -0,0 +1,13 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/l-orlov/matcha/internal/repository/memory"
+	"github.com/l-orlov/matcha/internal/repository/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) repository.Storage { return memory.New() }, "test")
+}
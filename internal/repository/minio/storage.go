@@ -2,11 +2,15 @@ package minio
 
 import (
 	"context"
+	"encoding/json"
 	"io"
+	"io/ioutil"
 	"time"
 
+	"github.com/l-orlov/matcha/internal/models"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -41,6 +45,47 @@ func New(config Config, log *logrus.Entry) (*StorageMinio, error) {
 	}, nil
 }
 
+// ProvisionBucket creates bucket if it does not exist and replaces its policy and lifecycle rules by settings.
+// It is called on startup, so it also checks storage connectivity.
+func (s *StorageMinio) ProvisionBucket(ctx context.Context, bucket string, settings models.StorageBucketSettings) error {
+	childCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	exists, err := s.client.BucketExists(childCtx, bucket)
+	if err != nil {
+		return errors.Wrap(err, "failed to check minio bucket")
+	}
+
+	if !exists {
+		err = s.client.MakeBucket(childCtx, bucket, minio.MakeBucketOptions{})
+		// bucket could be created by another instance at the same time
+		if err != nil && minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+			return errors.Wrap(err, "failed to create minio bucket")
+		}
+
+		s.log.Infof("created bucket %s", bucket)
+	}
+
+	policy, err := bucketPolicy(bucket, settings.PublicReadPrefixes)
+	if err != nil {
+		return err
+	}
+
+	// empty policy removes existing one, so bucket is private
+	if err := s.client.SetBucketPolicy(childCtx, bucket, policy); err != nil {
+		return errors.Wrap(err, "failed to set minio bucket policy")
+	}
+
+	// empty configuration removes existing rules
+	if err := s.client.SetBucketLifecycle(
+		childCtx, bucket, bucketLifecycle(settings.LifecycleRules),
+	); err != nil {
+		return errors.Wrap(err, "failed to set minio bucket lifecycle")
+	}
+
+	return nil
+}
+
 func (s *StorageMinio) PutFile(
 	ctx context.Context, bucketName, objectName, contentType string, reader io.Reader,
 ) error {
@@ -56,6 +101,28 @@ func (s *StorageMinio) PutFile(
 	return nil
 }
 
+func (s *StorageMinio) GetFile(ctx context.Context, bucket, objectName string) ([]byte, error) {
+	childCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	object, err := s.client.GetObject(childCtx, bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get from minio")
+	}
+	defer func() {
+		if err := object.Close(); err != nil {
+			s.log.Error(err)
+		}
+	}()
+
+	data, err := ioutil.ReadAll(object)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read from minio")
+	}
+
+	return data, nil
+}
+
 func (s *StorageMinio) GetFileURL(
 	ctx context.Context, bucket, objectName string, expires time.Duration,
 ) (url string, err error) {
@@ -70,6 +137,86 @@ func (s *StorageMinio) GetFileURL(
 	return u.String(), nil
 }
 
+// GetFileUpload returns presigned POST policy form. Policy fixes object name and content type
+// and limits file size, so storage rejects other uploads.
+func (s *StorageMinio) GetFileUpload(
+	ctx context.Context, bucket, objectName, contentType string, maxSize int64, expires time.Duration,
+) (*models.StorageUpload, error) {
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	if err := policy.SetKey(objectName); err != nil {
+		return nil, err
+	}
+
+	if err := policy.SetExpires(time.Now().UTC().Add(expires)); err != nil {
+		return nil, err
+	}
+
+	if err := policy.SetContentType(contentType); err != nil {
+		return nil, err
+	}
+
+	if err := policy.SetContentLengthRange(1, maxSize); err != nil {
+		return nil, err
+	}
+
+	childCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	u, fields, err := s.client.PresignedPostPolicy(childCtx, policy)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to presign minio post policy")
+	}
+
+	return &models.StorageUpload{
+		URL:    u.String(),
+		Fields: fields,
+	}, nil
+}
+
+// GetFileInfo returns object info without its content. It returns nil if object does not exist.
+func (s *StorageMinio) GetFileInfo(ctx context.Context, bucket, objectName string) (*models.StorageObject, error) {
+	childCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	info, err := s.client.StatObject(childCtx, bucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to stat minio object")
+	}
+
+	return &models.StorageObject{
+		Name:         info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+// CopyFile copies object on server side.
+func (s *StorageMinio) CopyFile(ctx context.Context, bucket, srcObjectName, dstObjectName string) error {
+	childCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	if _, err := s.client.CopyObject(childCtx, minio.CopyDestOptions{
+		Bucket: bucket,
+		Object: dstObjectName,
+	}, minio.CopySrcOptions{
+		Bucket: bucket,
+		Object: srcObjectName,
+	}); err != nil {
+		return errors.Wrap(err, "failed to copy in minio")
+	}
+
+	return nil
+}
+
 func (s *StorageMinio) DeleteFile(ctx context.Context, bucket, objectName string) error {
 	childCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
 	defer cancel()
@@ -80,3 +227,89 @@ func (s *StorageMinio) DeleteFile(ctx context.Context, bucket, objectName string
 
 	return nil
 }
+
+// ListFiles returns all objects which names start with prefix.
+func (s *StorageMinio) ListFiles(ctx context.Context, bucket, prefix string) ([]models.StorageObject, error) {
+	childCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	var objects []models.StorageObject
+	for object := range s.client.ListObjects(childCtx, bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, errors.Wrap(object.Err, "failed to list minio objects")
+		}
+
+		objects = append(objects, models.StorageObject{
+			Name:         object.Key,
+			LastModified: object.LastModified,
+		})
+	}
+
+	return objects, nil
+}
+
+// DeleteFilesByPrefix deletes all objects which names start with prefix.
+func (s *StorageMinio) DeleteFilesByPrefix(ctx context.Context, bucket, prefix string) error {
+	childCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	objects := s.client.ListObjects(childCtx, bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+
+	for removeErr := range s.client.RemoveObjects(childCtx, bucket, objects, minio.RemoveObjectsOptions{}) {
+		return errors.Wrapf(removeErr.Err, "failed to remove object %s", removeErr.ObjectName)
+	}
+
+	return nil
+}
+
+// bucketPolicy returns policy which allows anonymous reading of objects with prefixes.
+// It returns empty policy if there are no prefixes.
+func bucketPolicy(bucket string, publicReadPrefixes []string) (string, error) {
+	if len(publicReadPrefixes) == 0 {
+		return "", nil
+	}
+
+	resources := make([]string, len(publicReadPrefixes))
+	for i, prefix := range publicReadPrefixes {
+		resources[i] = "arn:aws:s3:::" + bucket + "/" + prefix + "*"
+	}
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":    "Allow",
+			"Principal": map[string][]string{"AWS": {"*"}},
+			"Action":    []string{"s3:GetObject"},
+			"Resource":  resources,
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(policy), nil
+}
+
+func bucketLifecycle(rules []models.StorageLifecycleRule) *lifecycle.Configuration {
+	config := lifecycle.NewConfiguration()
+	for _, rule := range rules {
+		config.Rules = append(config.Rules, lifecycle.Rule{
+			ID:     "expire-" + rule.Prefix,
+			Status: "Enabled",
+			RuleFilter: lifecycle.Filter{
+				Prefix: rule.Prefix,
+			},
+			Expiration: lifecycle.Expiration{
+				Days: lifecycle.ExpirationDays(rule.ExpirationDays),
+			},
+		})
+	}
+
+	return config
+}
@@ -0,0 +1,56 @@
+package minio_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/l-orlov/matcha/internal/repository/minio"
+	"github.com/l-orlov/matcha/internal/repository/storagetest"
+	"github.com/sirupsen/logrus"
+)
+
+// TestStorage runs against MinIO server from MINIO_ENDPOINT, e.g. one from docker-compose.
+// Test bucket is emptied before every test.
+func TestStorage(t *testing.T) {
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT is not set")
+	}
+
+	bucket := os.Getenv("MINIO_TEST_BUCKET")
+	if bucket == "" {
+		bucket = "matcha-storagetest"
+	}
+
+	storagetest.Run(t, func(t *testing.T) repository.Storage {
+		log := logrus.New()
+		log.SetOutput(ioutil.Discard)
+
+		storage, err := minio.New(minio.Config{
+			Endpoint:  endpoint,
+			AccessKey: getEnv("MINIO_ACCESS_KEY", "minio"),
+			SecretKey: getEnv("MINIO_SECRET_KEY", "minio123"),
+			Timeout:   10 * time.Second,
+		}, logrus.NewEntry(log))
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+
+		// bucket does not exist on the first run
+		_ = storage.DeleteFilesByPrefix(context.Background(), bucket, "")
+
+		return storage
+	}, bucket)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
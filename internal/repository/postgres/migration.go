@@ -1,34 +1,224 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/httpfs"
 	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/schema"
 	"github.com/pkg/errors"
 )
 
-func MigrateSchema(db *sql.DB, cfg config.PostgresDB) error {
+// migrationLockID is key of advisory lock held by every migration command. Migrate takes its own lock
+// only for single operation and fails if it waits longer than 15 seconds, so replicas which start
+// at the same time would fail while the first one applies long migrations.
+const migrationLockID = 2021051801
+
+var ErrDirtySchema = errors.New("schema is dirty, fix it and force version")
+
+type (
+	// Migrator applies migrations embedded into binary. It uses own connection,
+	// because migrate closes database on Close.
+	Migrator struct {
+		db          *sql.DB
+		source      source.Driver
+		m           *migrate.Migrate
+		lockTimeout time.Duration
+	}
+	// MigrationStatus is version of schema and versions of migrations which are not applied.
+	// Version is zero if no migration is applied.
+	MigrationStatus struct {
+		Version uint
+		Dirty   bool
+		Latest  uint
+		Pending []uint
+	}
+)
+
+func NewMigrator(cfg config.PostgresDB) (*Migrator, error) {
+	db, err := sql.Open("postgres", initConnectionString(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := newMigrator(db, cfg)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return migrator, nil
+}
+
+func newMigrator(db *sql.DB, cfg config.PostgresDB) (*Migrator, error) {
 	driver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "failed to create migration driver")
 	}
 
-	m, err := migrate.NewWithDatabaseInstance("file://"+cfg.MigrationDir, cfg.Database, driver)
+	src, err := httpfs.New(http.FS(schema.Migrations), ".")
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "failed to read embedded migrations")
+	}
+
+	m, err := migrate.NewWithInstance("httpfs", src, cfg.Database, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:          db,
+		source:      src,
+		m:           m,
+		lockTimeout: cfg.MigrationLockTimeout.Duration(),
+	}, nil
+}
+
+// Close closes migrator connection.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	if srcErr != nil {
+		return srcErr
+	}
+
+	return dbErr
+}
+
+// Up applies steps pending migrations, all of them if steps is zero.
+func (m *Migrator) Up(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func() error {
+		if steps == 0 {
+			return ignoreNoChange(m.m.Up())
+		}
+
+		return ignoreNoChange(m.m.Steps(steps))
+	})
+}
+
+// Down rolls back steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		return errors.New("number of migrations to roll back must be positive")
+	}
+
+	return m.withLock(ctx, func() error {
+		return ignoreNoChange(m.m.Steps(-steps))
+	})
+}
+
+// To applies or rolls back migrations up to version.
+func (m *Migrator) To(ctx context.Context, version uint) error {
+	return m.withLock(ctx, func() error {
+		return ignoreNoChange(m.m.Migrate(version))
+	})
+}
+
+// Force sets version without running migrations and clears dirty flag. It is used after failed
+// migration is fixed manually. Version -1 means that no migration is applied.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	return m.withLock(ctx, func() error {
+		return m.m.Force(version)
+	})
+}
+
+// CheckClean returns ErrDirtySchema if the last migration failed. Schema is dirty while migration
+// is running too, so it is checked holding the lock.
+func (m *Migrator) CheckClean(ctx context.Context) error {
+	return m.withLock(ctx, func() error {
+		version, dirty, err := m.m.Version()
+		if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+			return err
+		}
+
+		if dirty {
+			return errors.Wrapf(ErrDirtySchema, "version %d", version)
+		}
+
+		return nil
+	})
+}
+
+// Status returns current state of schema. It does not wait for running migration.
+func (m *Migrator) Status() (*MigrationStatus, error) {
+	var status MigrationStatus
+
+	var err error
+	status.Version, status.Dirty, err = m.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, err
 	}
 
-	if err = m.Steps(1); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
+	version, err := m.source.First()
+	for err == nil {
+		if version > status.Version {
+			status.Pending = append(status.Pending, version)
 		}
 
+		status.Latest = version
+		version, err = m.source.Next(version)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// withLock runs fn holding migration advisory lock. Lock is session level, so it is taken on dedicated
+// connection and released if process dies.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error {
+	lockCtx := ctx
+	if m.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, m.lockTimeout)
+		defer cancel()
+	}
+
+	conn, err := m.db.Conn(lockCtx)
+	if err != nil {
 		return err
 	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.ExecContext(lockCtx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return errors.Wrap(err, "failed to take migration lock")
+	}
+
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}()
+
+	return fn()
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+
+	return err
+}
+
+// MigrateSchema prepares schema on startup. It applies all pending migrations in migration mode,
+// otherwise it only checks that schema is not dirty.
+func MigrateSchema(ctx context.Context, cfg config.PostgresDB) error {
+	migrator, err := NewMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = migrator.Close() }()
+
+	if cfg.MigrationMode {
+		return migrator.Up(ctx, 0)
+	}
 
-	return nil
+	return migrator.CheckClean(ctx)
 }
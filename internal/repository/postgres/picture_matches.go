@@ -0,0 +1,155 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/l-orlov/matcha/internal/models"
+)
+
+const (
+	usersPicturesMatchesTable = "users_pictures_matches"
+	// pictureHashBandsNum is number of 16-bit bands of perceptual hash which are indexed.
+	pictureHashBandsNum = 4
+)
+
+type PictureMatchesPostgres struct {
+	db        *sqlx.DB
+	dbTimeout time.Duration
+}
+
+func NewPictureMatchesPostgres(db *sqlx.DB, dbTimeout time.Duration) *PictureMatchesPostgres {
+	return &PictureMatchesPostgres{
+		db:        db,
+		dbTimeout: dbTimeout,
+	}
+}
+
+// GetPictureHashCandidates returns pictures and avatars of other users which perceptual hash has
+// at least one 16-bit band equal to band of hash. It includes all hashes which differ in less than 4 bits.
+func (r *PictureMatchesPostgres) GetPictureHashCandidates(
+	ctx context.Context, userID uint64, hash int64, limit int,
+) ([]models.PictureHash, error) {
+	query := fmt.Sprintf(`
+SELECT user_id, picture_path, perceptual_hash FROM %s
+WHERE user_id != $1 AND (%s)
+UNION ALL
+SELECT id, avatar_path, avatar_perceptual_hash FROM %s
+WHERE id != $1 AND (%s)
+LIMIT $%d`,
+		usersPicturesTable, pictureHashBandsCondition("perceptual_hash"),
+		usersTable, pictureHashBandsCondition("avatar_perceptual_hash"),
+		pictureHashBandsNum+2,
+	)
+
+	args := []interface{}{userID}
+	for i := 0; i < pictureHashBandsNum; i++ {
+		args = append(args, pictureHashBand(hash, i))
+	}
+	args = append(args, limit)
+
+	var hashes []models.PictureHash
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &hashes, query, args...); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// AddPictureMatches saves matches, already saved ones are skipped.
+func (r *PictureMatchesPostgres) AddPictureMatches(ctx context.Context, matches []models.PictureMatch) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (user_id, picture_path, matched_user_id, matched_picture_path, distance)
+VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`, usersPicturesMatchesTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	return runInTx(dbCtx, r.db, func(tx *sqlx.Tx) error {
+		for i := range matches {
+			if _, err := tx.ExecContext(dbCtx, query, &matches[i].UserID, &matches[i].PicturePath,
+				&matches[i].MatchedUserID, &matches[i].MatchedPicturePath, &matches[i].Distance,
+			); err != nil {
+				return getDBError(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetPictureMatches returns picture matches after key, the newest first.
+func (r *PictureMatchesPostgres) GetPictureMatches(
+	ctx context.Context, after *models.PictureMatchKey, limit int,
+) ([]models.PictureMatch, error) {
+	query := fmt.Sprintf(`
+SELECT id, user_id, picture_path, matched_user_id, matched_picture_path, distance, created_at FROM %s
+WHERE NOT $1 OR (created_at, id) < ($2, $3)
+ORDER BY created_at DESC, id DESC LIMIT NULLIF($4, 0)`, usersPicturesMatchesTable)
+	var matches []models.PictureMatch
+
+	var key models.PictureMatchKey
+	if after != nil {
+		key = *after
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &matches, query,
+		after != nil, &key.CreatedAt, &key.ID, &limit,
+	); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+func (r *PictureMatchesPostgres) DeletePictureMatch(ctx context.Context, id uint64) (bool, error) {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, usersPicturesMatchesTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	result, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &id)
+	if err != nil {
+		return false, getDBError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected != 0, nil
+}
+
+// pictureHashBandsCondition matches column bands with query params from $2. Expressions must be
+// the same as in indexes, so they are used.
+func pictureHashBandsCondition(column string) string {
+	var condition string
+	for i := 0; i < pictureHashBandsNum; i++ {
+		if i != 0 {
+			condition += " OR "
+		}
+
+		shift := 16 * (pictureHashBandsNum - 1 - i)
+		if shift == 0 {
+			condition += fmt.Sprintf("%s & 65535 = $%d", column, i+2)
+		} else {
+			condition += fmt.Sprintf("(%s >> %d) & 65535 = $%d", column, shift, i+2)
+		}
+	}
+
+	return condition
+}
+
+func pictureHashBand(hash int64, i int) int64 {
+	return int64(uint64(hash) >> (16 * (pictureHashBandsNum - 1 - i)) & 0xFFFF)
+}
@@ -29,7 +29,8 @@ func initConnectionString(cfg config.PostgresDB) string {
 
 func getDBError(err error) error {
 	if err, ok := err.(*pq.Error); ok {
-		if err.Code.Class() < "50" { // business error
+		// transaction rollback errors are not caused by input and are retried by TxManager
+		if err.Code.Class() < "50" && err.Code.Class() != "40" { // business error
 			return ierrors.NewBusiness(err, err.Detail)
 		}
 
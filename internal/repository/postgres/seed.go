@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/lib/pq"
+)
+
+// SeedPostgres inserts generated fake data in bulk.
+type SeedPostgres struct {
+	db        *sqlx.DB
+	dbTimeout time.Duration
+}
+
+func NewSeedPostgres(db *sqlx.DB, dbTimeout time.Duration) *SeedPostgres {
+	return &SeedPostgres{
+		db:        db,
+		dbTimeout: dbTimeout,
+	}
+}
+
+// ReserveUserIDs takes num ids from users sequence. Ids of not inserted users are just skipped.
+func (r *SeedPostgres) ReserveUserIDs(ctx context.Context, num int) ([]uint64, error) {
+	query := fmt.Sprintf(`
+SELECT nextval(pg_get_serial_sequence('%s', 'id')) FROM generate_series(1, $1)`, usersTable)
+	var ids []uint64
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &ids, query, &num); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// CopyUsers inserts users with reserved ids by COPY. Avatar perceptual hash is set only for users with avatar.
+func (r *SeedPostgres) CopyUsers(ctx context.Context, users []models.SeedUser) error {
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	return runInTx(dbCtx, r.db, func(tx *sqlx.Tx) error {
+		stmt, err := tx.PrepareContext(dbCtx, pq.CopyIn(usersTable,
+			"id", "email", "username", "first_name", "last_name", "password", "is_email_confirmed",
+			"gender", "sexual_preferences", "biography", "tags", "avatar_path", "avatar_variants",
+			"avatar_content_hash", "avatar_perceptual_hash", "likes_num", "views_num", "gps_position",
+		))
+		if err != nil {
+			return getDBError(err)
+		}
+		defer func() { _ = stmt.Close() }()
+
+		for i := range users {
+			user := &users[i]
+
+			// COPY encodes bytes as bytea, so JSON is passed as text
+			variants, err := user.AvatarVariants.Value()
+			if err != nil {
+				return err
+			}
+
+			var perceptualHash interface{}
+			if user.AvatarPath != "" {
+				perceptualHash = user.AvatarPerceptualHash
+			}
+
+			if _, err := stmt.ExecContext(dbCtx,
+				user.ID, user.Email, user.Username, user.FirstName, user.LastName, user.Password,
+				user.IsEmailConfirmed, user.Gender, user.SexualPreferences, user.Biography,
+				pq.StringArray(user.Tags), user.AvatarPath, string(variants.([]byte)),
+				user.AvatarContentHash, perceptualHash, user.LikesNum, user.ViewsNum, user.GPSPosition,
+			); err != nil {
+				return getDBError(err)
+			}
+		}
+
+		// exec without args flushes buffered rows and finishes COPY
+		if _, err := stmt.ExecContext(dbCtx); err != nil {
+			return getDBError(err)
+		}
+
+		return nil
+	})
+}
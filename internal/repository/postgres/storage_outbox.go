@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	storageOutboxTable = "storage_outbox"
+)
+
+// StorageOutboxPostgres keeps prefixes of storage objects which must be deleted unless record is removed.
+// Record is added before objects are written or after their database row is deleted,
+// so objects are not lost track of if process fails between storage and database writes.
+type StorageOutboxPostgres struct {
+	db        *sqlx.DB
+	dbTimeout time.Duration
+}
+
+func NewStorageOutboxPostgres(db *sqlx.DB, dbTimeout time.Duration) *StorageOutboxPostgres {
+	return &StorageOutboxPostgres{
+		db:        db,
+		dbTimeout: dbTimeout,
+	}
+}
+
+func (r *StorageOutboxPostgres) AddStorageOutboxRecord(ctx context.Context, objectPrefix string) error {
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	return addStorageOutboxRecord(dbCtx, getQueryer(dbCtx, r.db), objectPrefix)
+}
+
+// AddStorageOutboxRecords adds records of many prefixes with one query. It is used for bulk uploads.
+func (r *StorageOutboxPostgres) AddStorageOutboxRecords(ctx context.Context, objectPrefixes []string) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (object_prefix) SELECT unnest($1::TEXT[]) ON CONFLICT (object_prefix) DO NOTHING`, storageOutboxTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, pq.Array(objectPrefixes)); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+// GetStorageOutboxRecords returns object prefixes of records added before time.
+func (r *StorageOutboxPostgres) GetStorageOutboxRecords(
+	ctx context.Context, before time.Time, limit int,
+) ([]string, error) {
+	query := fmt.Sprintf(`
+SELECT object_prefix FROM %s WHERE created_at <= $1 ORDER BY created_at LIMIT $2`, storageOutboxTable)
+	var prefixes []string
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &prefixes, query, &before, &limit); err != nil {
+		return nil, err
+	}
+
+	return prefixes, nil
+}
+
+func (r *StorageOutboxPostgres) DeleteStorageOutboxRecord(ctx context.Context, objectPrefix string) error {
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	return deleteStorageOutboxRecord(dbCtx, getQueryer(dbCtx, r.db), objectPrefix)
+}
+
+func (r *StorageOutboxPostgres) DeleteStorageOutboxRecords(ctx context.Context, objectPrefixes []string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE object_prefix = ANY($1)`, storageOutboxTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, pq.Array(objectPrefixes)); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+func addStorageOutboxRecord(ctx context.Context, db sqlx.ExecerContext, objectPrefix string) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (object_prefix) VALUES ($1) ON CONFLICT (object_prefix) DO NOTHING`, storageOutboxTable)
+
+	if _, err := db.ExecContext(ctx, query, &objectPrefix); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+func deleteStorageOutboxRecord(ctx context.Context, db sqlx.ExecerContext, objectPrefix string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE object_prefix = $1`, storageOutboxTable)
+
+	if _, err := db.ExecContext(ctx, query, &objectPrefix); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const (
+	errCodeSerializationFailure = "40001"
+	errCodeDeadlockDetected     = "40P01"
+)
+
+type (
+	// txContextKey is key of transaction in context.
+	txContextKey struct{}
+	// queryer is implemented by both *sqlx.DB and *sqlx.Tx.
+	queryer interface {
+		sqlx.ExtContext
+		GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+		SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	}
+	// TxManager runs several repository calls in one transaction. Transaction is passed to repositories
+	// in context, so they use it instead of db.
+	TxManager struct {
+		db         *sqlx.DB
+		isolation  sql.IsolationLevel
+		maxRetries int
+		retryDelay time.Duration
+	}
+)
+
+func NewTxManager(db *sqlx.DB, cfg config.PostgresTransactions) (*TxManager, error) {
+	isolation, err := parseTxIsolation(cfg.Isolation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxManager{
+		db:         db,
+		isolation:  isolation,
+		maxRetries: cfg.MaxRetries,
+		retryDelay: cfg.RetryDelay.Duration(),
+	}, nil
+}
+
+// WithinTransaction runs fn in transaction which is committed if fn returns no error. Repository calls
+// must use context passed to fn. If ctx already has transaction, fn joins it and it is not committed here.
+// On serialization failure or deadlock the whole fn is retried, so fn must not have side effects
+// other than repository calls.
+func (m *TxManager) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txContextKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	delay := m.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := m.runTransaction(ctx, fn)
+		if err == nil || attempt >= m.maxRetries || !isRetryableTxError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+func (m *TxManager) runTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := m.db.BeginTxx(ctx, &sql.TxOptions{Isolation: m.isolation})
+	if err != nil {
+		return getDBError(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(context.WithValue(ctx, txContextKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+// getQueryer returns transaction from context or db if there is no transaction.
+func getQueryer(ctx context.Context, db *sqlx.DB) queryer {
+	if tx, ok := ctx.Value(txContextKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+
+	return db
+}
+
+// runInTx runs fn in transaction from context or in new transaction which is committed
+// if fn returns no error. It is used by repository methods which make several queries.
+func runInTx(ctx context.Context, db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	if tx, ok := ctx.Value(txContextKey{}).(*sqlx.Tx); ok {
+		return fn(tx)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return getDBError(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+func isRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	return pqErr.Code == errCodeSerializationFailure || pqErr.Code == errCodeDeadlockDetected
+}
+
+func parseTxIsolation(isolation string) (sql.IsolationLevel, error) {
+	switch isolation {
+	case "":
+		return sql.LevelDefault, nil
+	case config.TxIsolationReadCommitted:
+		return sql.LevelReadCommitted, nil
+	case config.TxIsolationRepeatableRead:
+		return sql.LevelRepeatableRead, nil
+	case config.TxIsolationSerializable:
+		return sql.LevelSerializable, nil
+	default:
+		return 0, errors.Errorf("unknown transaction isolation %q", isolation)
+	}
+}
@@ -16,6 +16,30 @@ const (
 	usersTable = "users"
 )
 
+// userScope defines which users are visible for read path depending on their state.
+type userScope int
+
+const (
+	// userScopeAccount includes users that can sign in. Deleted users can sign in
+	// until they are purged, signing in cancels deletion.
+	userScopeAccount userScope = iota
+	// userScopeVisible includes users which profile can be viewed by other users.
+	userScopeVisible
+	// userScopeListed includes users which can be shown in listings and suggestions.
+	userScopeListed
+)
+
+func (s userScope) condition() string {
+	switch s {
+	case userScopeVisible:
+		return fmt.Sprintf("state IN ('%s', '%s')", models.UserStateActive, models.UserStateHidden)
+	case userScopeListed:
+		return fmt.Sprintf("state = '%s'", models.UserStateActive)
+	default:
+		return "TRUE"
+	}
+}
+
 type UserPostgres struct {
 	db        *sqlx.DB
 	dbTimeout time.Duration
@@ -36,7 +60,7 @@ VALUES ($1, $2, $3, $4, $5) RETURNING id`, usersTable)
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	row := r.db.QueryRowContext(dbCtx, query,
+	row := getQueryer(dbCtx, r.db).QueryRowContext(dbCtx, query,
 		&user.Email, &user.Username, &user.FirstName, &user.LastName, &user.Password)
 	if err := row.Err(); err != nil {
 		return 0, getDBError(err)
@@ -52,13 +76,14 @@ VALUES ($1, $2, $3, $4, $5) RETURNING id`, usersTable)
 
 func (r *UserPostgres) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	query := fmt.Sprintf(`
-SELECT id, email, username, first_name, last_name, password FROM %s WHERE username=$1`, usersTable)
+SELECT id, email, username, first_name, last_name, password, state FROM %s WHERE username=$1 AND %s`,
+		usersTable, userScopeAccount.condition())
 	var user models.User
 
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	if err := r.db.GetContext(dbCtx, &user, query, &username); err != nil {
+	if err := getQueryer(dbCtx, r.db).GetContext(dbCtx, &user, query, &username); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -71,13 +96,14 @@ SELECT id, email, username, first_name, last_name, password FROM %s WHERE userna
 
 func (r *UserPostgres) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	query := fmt.Sprintf(`
-SELECT id, email, username, first_name, last_name, password FROM %s WHERE email=$1`, usersTable)
+SELECT id, email, username, first_name, last_name, password, is_email_confirmed, state
+FROM %s WHERE email=$1 AND %s`, usersTable, userScopeAccount.condition())
 	var user models.User
 
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	if err := r.db.GetContext(dbCtx, &user, query, &email); err != nil {
+	if err := getQueryer(dbCtx, r.db).GetContext(dbCtx, &user, query, &email); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -90,13 +116,14 @@ SELECT id, email, username, first_name, last_name, password FROM %s WHERE email=
 
 func (r *UserPostgres) GetUserByID(ctx context.Context, id uint64) (*models.User, error) {
 	query := fmt.Sprintf(`
-SELECT id, email, username, first_name, last_name, password, is_email_confirmed FROM %s WHERE id=$1`, usersTable)
+SELECT id, email, username, first_name, last_name, password, is_email_confirmed, roles, state
+FROM %s WHERE id=$1 AND %s`, usersTable, userScopeAccount.condition())
 	var user models.User
 
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	if err := r.db.GetContext(dbCtx, &user, query, &id); err != nil {
+	if err := getQueryer(dbCtx, r.db).GetContext(dbCtx, &user, query, &id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -114,7 +141,7 @@ UPDATE %s SET username = $1, first_name = $2, last_name = $3 WHERE id = $4`, use
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	_, err := r.db.ExecContext(dbCtx, query, &user.Username, &user.FirstName, &user.LastName, &user.ID)
+	_, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &user.Username, &user.FirstName, &user.LastName, &user.ID)
 	if err != nil {
 		return getDBError(err)
 	}
@@ -128,22 +155,25 @@ func (r *UserPostgres) UpdateUserPassword(ctx context.Context, userID uint64, pa
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	if _, err := r.db.ExecContext(dbCtx, query, &password, &userID); err != nil {
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &password, &userID); err != nil {
 		return getDBError(err)
 	}
 
 	return nil
 }
 
-func (r *UserPostgres) GetAllUsers(ctx context.Context) ([]models.User, error) {
+// GetAllUsers returns listed users with id greater than afterID in order of ids. Zero limit returns all users.
+func (r *UserPostgres) GetAllUsers(ctx context.Context, afterID uint64, limit int) ([]models.User, error) {
 	query := fmt.Sprintf(`
-SELECT id, email, username, first_name, last_name, is_email_confirmed FROM %s`, usersTable)
+SELECT id, email, username, first_name, last_name, is_email_confirmed, state FROM %s
+WHERE %s AND id > $1 ORDER BY id LIMIT NULLIF($2, 0)`,
+		usersTable, userScopeListed.condition())
 	var users []models.User
 
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	err := r.db.SelectContext(dbCtx, &users, query)
+	err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &users, query, &afterID, &limit)
 
 	return users, err
 }
@@ -154,36 +184,177 @@ func (r *UserPostgres) DeleteUser(ctx context.Context, id uint64) error {
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	if _, err := r.db.ExecContext(dbCtx, query, &id); err != nil {
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &id); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// DeleteScheduledUser deletes user only if deletion is still scheduled before time.
+// It returns false if user was reactivated or deleted already.
+func (r *UserPostgres) DeleteScheduledUser(ctx context.Context, id uint64, before time.Time) (bool, error) {
+	query := fmt.Sprintf(`
+DELETE FROM %s WHERE id = $1 AND state = $2 AND deletion_scheduled_at <= $3`, usersTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	result, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &id, models.UserStateDeleted, &before)
+	if err != nil {
+		return false, getDBError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected != 0, nil
+}
+
+// LockUser locks user row until the end of transaction, so it must be called within TxManager transaction.
+// It returns false if user does not exist.
+func (r *UserPostgres) LockUser(ctx context.Context, id uint64) (bool, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	tx, ok := dbCtx.Value(txContextKey{}).(*sqlx.Tx)
+	if !ok {
+		return false, errors.New("user lock requires transaction")
+	}
+
+	if err := lockUser(dbCtx, tx, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+
+		return false, getDBError(err)
+	}
+
+	return true, nil
+}
+
+func (r *UserPostgres) ScheduleUserDeletion(ctx context.Context, userID uint64, deleteAt time.Time) error {
+	query := fmt.Sprintf(`
+UPDATE %s SET state = $1, deletion_scheduled_at = $2 WHERE id = $3`, usersTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, models.UserStateDeleted, &deleteAt, &userID); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+// UpdateUserState sets state of not deleted user. Deletion is set by ScheduleUserDeletion only.
+func (r *UserPostgres) UpdateUserState(ctx context.Context, userID uint64, state string) error {
+	query := fmt.Sprintf(`UPDATE %s SET state = $1 WHERE id = $2 AND state <> $3`, usersTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &state, &userID, models.UserStateDeleted); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+// ReactivateUser makes deactivated or deleted user active and cancels scheduled deletion.
+// It returns false if user was not deactivated or deleted.
+func (r *UserPostgres) ReactivateUser(ctx context.Context, userID uint64) (bool, error) {
+	query := fmt.Sprintf(`
+UPDATE %s SET state = $1, deletion_scheduled_at = NULL WHERE id = $2 AND state IN ($3, $4)`, usersTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	result, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query,
+		models.UserStateActive, &userID, models.UserStateDeactivated, models.UserStateDeleted)
+	if err != nil {
+		return false, getDBError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected != 0, nil
+}
+
+func (r *UserPostgres) GetUsersScheduledForDeletion(
+	ctx context.Context, before time.Time, limit int,
+) ([]uint64, error) {
+	query := fmt.Sprintf(`
+SELECT id FROM %s WHERE state = $1 AND deletion_scheduled_at <= $2
+ORDER BY deletion_scheduled_at LIMIT $3`, usersTable)
+	var ids []uint64
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &ids, query, models.UserStateDeleted, &before, &limit); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
 func (r *UserPostgres) ConfirmEmail(ctx context.Context, id uint64) error {
 	query := fmt.Sprintf(`UPDATE %s SET is_email_confirmed = true WHERE id = $1`, usersTable)
 
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	if _, err := r.db.ExecContext(dbCtx, query, &id); err != nil {
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &id); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+// AddUserRole adds role to user roles if user does not have it yet.
+func (r *UserPostgres) AddUserRole(ctx context.Context, userID uint64, role string) error {
+	query := fmt.Sprintf(`
+UPDATE %s SET roles = array_append(roles, $1) WHERE id = $2 AND NOT $1 = ANY(roles)`, usersTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &role, &userID); err != nil {
 		return getDBError(err)
 	}
 
 	return nil
 }
 
+// GetUserProfileByID returns profile of user regardless of its visibility. It is used for own profile.
 func (r *UserPostgres) GetUserProfileByID(ctx context.Context, id uint64) (*models.UserProfile, error) {
+	return r.getUserProfileByID(ctx, id, userScopeAccount)
+}
+
+// GetVisibleUserProfileByID returns profile of user if it can be viewed by other users.
+func (r *UserPostgres) GetVisibleUserProfileByID(ctx context.Context, id uint64) (*models.UserProfile, error) {
+	return r.getUserProfileByID(ctx, id, userScopeVisible)
+}
+
+func (r *UserPostgres) getUserProfileByID(
+	ctx context.Context, id uint64, scope userScope,
+) (*models.UserProfile, error) {
 	query := fmt.Sprintf(`
 SELECT id, email, username, first_name, last_name, is_email_confirmed,
-gender, sexual_preferences, biography, tags, avatar_path, likes_num, views_num, gps_position
-FROM %s WHERE id=$1`, usersTable)
+gender, sexual_preferences, biography, tags, avatar_path, avatar_variants, avatar_content_hash,
+avatar_moderation_status, likes_num, views_num, gps_position, state
+FROM %s WHERE id=$1 AND %s`, usersTable, scope.condition())
 
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	row := r.db.QueryRowContext(dbCtx, query, &id)
+	row := getQueryer(dbCtx, r.db).QueryRowContext(dbCtx, query, &id)
 	if err := row.Err(); err != nil {
 		return nil, err
 	}
@@ -191,7 +362,8 @@ FROM %s WHERE id=$1`, usersTable)
 	var user models.UserProfile
 	err := row.Scan(&user.ID, &user.Email, &user.Username, &user.FirstName, &user.LastName,
 		&user.IsEmailConfirmed, &user.Gender, &user.SexualPreferences, &user.Biography,
-		pq.Array(&user.Tags), &user.AvatarPath, &user.LikesNum, &user.ViewsNum, &user.GPSPosition,
+		pq.Array(&user.Tags), &user.AvatarPath, &user.AvatarVariants, &user.AvatarContentHash,
+		&user.AvatarModeration, &user.LikesNum, &user.ViewsNum, &user.GPSPosition, &user.State,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -214,7 +386,7 @@ WHERE id = $11`, usersTable)
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	_, err := r.db.ExecContext(dbCtx, query, &user.Username, &user.FirstName, &user.LastName,
+	_, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &user.Username, &user.FirstName, &user.LastName,
 		&user.Gender, &user.SexualPreferences, &user.Biography, pq.Array(&user.Tags),
 		&user.LikesNum, &user.ViewsNum, &user.GPSPosition, &user.ID)
 	if err != nil {
@@ -224,16 +396,65 @@ WHERE id = $11`, usersTable)
 	return nil
 }
 
-func (r *UserPostgres) UpdateUserAvatarPath(ctx context.Context, userID uint64, avatarPath string) error {
-	query := fmt.Sprintf(`UPDATE %s SET avatar_path = $1 WHERE id = $2`, usersTable)
+func (r *UserPostgres) UpdateUserAvatar(ctx context.Context, userID uint64, avatar models.UserAvatar) error {
+	query := fmt.Sprintf(`
+UPDATE %s SET avatar_path = $1, avatar_variants = $2, avatar_content_hash = $3, avatar_moderation_status = $4,
+avatar_perceptual_hash = NULLIF($5, 0)
+WHERE id = $6`, usersTable)
 
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	_, err := r.db.ExecContext(dbCtx, query, &avatarPath, &userID)
+	_, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &avatar.Path, avatar.Variants, &avatar.ContentHash,
+		&avatar.Moderation, &avatar.PerceptualHash, &userID)
 	if err != nil {
 		return getDBError(err)
 	}
 
 	return nil
 }
+
+// GetPendingUserAvatars returns avatars waiting for moderation of users with id greater than afterID,
+// the oldest users first.
+func (r *UserPostgres) GetPendingUserAvatars(ctx context.Context, afterID uint64, limit int) ([]models.UserAvatar, error) {
+	query := fmt.Sprintf(`
+SELECT id, avatar_path, avatar_variants, avatar_content_hash, avatar_moderation_status FROM %s
+WHERE avatar_moderation_status = $1 AND avatar_path != '' AND id > $2 ORDER BY id LIMIT NULLIF($3, 0)`, usersTable)
+	var avatars []models.UserAvatar
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &avatars, query,
+		models.PictureModerationPending, &afterID, &limit,
+	); err != nil {
+		return nil, err
+	}
+
+	return avatars, nil
+}
+
+// SetUserAvatarModeration sets moderation status of avatar if it has content hash.
+// It returns false if user has other avatar.
+func (r *UserPostgres) SetUserAvatarModeration(
+	ctx context.Context, userID uint64, contentHash, status string,
+) (bool, error) {
+	query := fmt.Sprintf(`
+UPDATE %s SET avatar_moderation_status = $1
+WHERE id = $2 AND avatar_path != '' AND avatar_content_hash = $3`, usersTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	result, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &status, &userID, &contentHash)
+	if err != nil {
+		return false, getDBError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected != 0, nil
+}
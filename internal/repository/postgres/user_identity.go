@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	userIdentitiesTable = "user_identities"
+)
+
+type UserIdentityPostgres struct {
+	db        *sqlx.DB
+	dbTimeout time.Duration
+}
+
+func NewUserIdentityPostgres(db *sqlx.DB, dbTimeout time.Duration) *UserIdentityPostgres {
+	return &UserIdentityPostgres{
+		db:        db,
+		dbTimeout: dbTimeout,
+	}
+}
+
+func (r *UserIdentityPostgres) CreateUserIdentity(ctx context.Context, identity models.UserIdentity) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)`, userIdentitiesTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	_, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query,
+		&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email)
+	if err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+func (r *UserIdentityPostgres) GetUserIdentity(
+	ctx context.Context, provider, subject string,
+) (*models.UserIdentity, error) {
+	query := fmt.Sprintf(`
+SELECT provider, subject, user_id, email FROM %s WHERE provider=$1 AND subject=$2`, userIdentitiesTable)
+	var identity models.UserIdentity
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).GetContext(dbCtx, &identity, query, &provider, &subject); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (r *UserIdentityPostgres) GetUserIdentitiesByUserID(
+	ctx context.Context, userID uint64,
+) ([]models.UserIdentity, error) {
+	query := fmt.Sprintf(`
+SELECT provider, subject, user_id, email FROM %s WHERE user_id=$1`, userIdentitiesTable)
+	var identities []models.UserIdentity
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &identities, query, &userID); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+func (r *UserIdentityPostgres) DeleteUserIdentitiesByUserID(ctx context.Context, userID uint64) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, userIdentitiesTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &userID); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/l-orlov/matcha/internal/models"
+)
+
+const (
+	userLoginDevicesTable = "user_login_devices"
+)
+
+type UserLoginDevicePostgres struct {
+	db        *sqlx.DB
+	dbTimeout time.Duration
+}
+
+func NewUserLoginDevicePostgres(db *sqlx.DB, dbTimeout time.Duration) *UserLoginDevicePostgres {
+	return &UserLoginDevicePostgres{
+		db:        db,
+		dbTimeout: dbTimeout,
+	}
+}
+
+func (r *UserLoginDevicePostgres) GetUserLoginDevices(
+	ctx context.Context, userID uint64,
+) ([]models.UserLoginDevice, error) {
+	query := fmt.Sprintf(`
+SELECT user_id, device_hash, country, user_agent, last_ip, created_at, last_seen_at
+FROM %s WHERE user_id=$1`, userLoginDevicesTable)
+	var devices []models.UserLoginDevice
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &devices, query, &userID); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+func (r *UserLoginDevicePostgres) UpsertUserLoginDevice(ctx context.Context, device models.UserLoginDevice) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (user_id, device_hash, country, user_agent, last_ip) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (user_id, device_hash, country) DO UPDATE SET last_ip=EXCLUDED.last_ip, last_seen_at=NOW()`,
+		userLoginDevicesTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	_, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query,
+		&device.UserID, &device.DeviceHash, &device.Country, &device.UserAgent, &device.LastIP)
+	if err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/l-orlov/matcha/internal/models"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
@@ -28,29 +29,68 @@ func NewUserPicturesPostgres(db *sqlx.DB, dbTimeout time.Duration) *UserPictures
 	}
 }
 
-func (r *UserPicturesPostgres) CreateUserPicture(ctx context.Context, picture models.UserPicture) error {
-	query := fmt.Sprintf(`
-INSERT INTO %s (uuid, user_id, picture_path) VALUES ($1, $2, $3)`, usersPicturesTable)
-
+// CreateUserPicture adds picture to the end of user pictures. User row is locked while pictures
+// are counted, so concurrent uploads can not exceed maxNum. It returns false if limit is reached.
+// Storage outbox record of picture path is deleted in the same transaction.
+func (r *UserPicturesPostgres) CreateUserPicture(
+	ctx context.Context, picture models.UserPicture, maxNum int,
+) (bool, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	_, err := r.db.ExecContext(dbCtx, query, &picture.UUID, &picture.UserID, &picture.PicturePath)
+	var created bool
+	err := runInTx(dbCtx, r.db, func(tx *sqlx.Tx) error {
+		if err := lockUser(dbCtx, tx, picture.UserID); err != nil {
+			return err
+		}
+
+		var count, position int
+		query := fmt.Sprintf(`
+SELECT COUNT(*), COALESCE(MAX(position) + 1, 0) FROM %s WHERE user_id=$1`, usersPicturesTable)
+		if err := tx.QueryRowxContext(dbCtx, query, &picture.UserID).Scan(&count, &position); err != nil {
+			return err
+		}
+
+		if count >= maxNum {
+			return nil
+		}
+
+		query = fmt.Sprintf(`
+INSERT INTO %s (uuid, user_id, picture_path, variants, content_hash, perceptual_hash,
+position, caption, moderation_status)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7, $8, $9)`, usersPicturesTable)
+
+		if _, err := tx.ExecContext(dbCtx, query, &picture.UUID, &picture.UserID, &picture.PicturePath,
+			picture.Variants, &picture.ContentHash, &picture.PerceptualHash, &position, &picture.Caption, &picture.Moderation,
+		); err != nil {
+			return getDBError(err)
+		}
+
+		if err := deleteStorageOutboxRecord(dbCtx, tx, picture.PicturePath); err != nil {
+			return err
+		}
+
+		created = true
+
+		return nil
+	})
 	if err != nil {
-		return getDBError(err)
+		return false, err
 	}
 
-	return nil
+	return created, nil
 }
 
 func (r *UserPicturesPostgres) GetUserPictureByUUID(ctx context.Context, uuid uuid.UUID) (*models.UserPicture, error) {
-	query := fmt.Sprintf(`SELECT uuid, user_id, picture_path FROM %s WHERE uuid=$1`, usersPicturesTable)
+	query := fmt.Sprintf(`
+SELECT uuid, user_id, picture_path, variants, content_hash, COALESCE(perceptual_hash, 0) AS perceptual_hash,
+position, caption, moderation_status, created_at FROM %s WHERE uuid=$1`, usersPicturesTable)
 	var picture models.UserPicture
 
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	if err := r.db.GetContext(dbCtx, &picture, query, &uuid); err != nil {
+	if err := getQueryer(dbCtx, r.db).GetContext(dbCtx, &picture, query, &uuid); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -61,25 +101,259 @@ func (r *UserPicturesPostgres) GetUserPictureByUUID(ctx context.Context, uuid uu
 	return &picture, nil
 }
 
-func (r *UserPicturesPostgres) GetUserPicturesByUserID(ctx context.Context, userID uint64) ([]models.UserPicture, error) {
-	query := fmt.Sprintf(`SELECT uuid, user_id, picture_path FROM %s WHERE user_id=$1`, usersPicturesTable)
+// GetUserPicturesByUserID returns user pictures in order of positions after key,
+// the first ones if key is nil. Zero limit returns all pictures.
+func (r *UserPicturesPostgres) GetUserPicturesByUserID(
+	ctx context.Context, userID uint64, after *models.UserPictureKey, limit int,
+) ([]models.UserPicture, error) {
+	query := fmt.Sprintf(`
+SELECT uuid, user_id, picture_path, variants, content_hash, COALESCE(perceptual_hash, 0) AS perceptual_hash,
+position, caption, moderation_status, created_at FROM %s
+WHERE user_id=$1 AND (NOT $2 OR (position, uuid) > ($3, $4))
+ORDER BY position, uuid LIMIT NULLIF($5, 0)`, usersPicturesTable)
 	var pictures []models.UserPicture
 
+	var key models.UserPictureKey
+	if after != nil {
+		key = *after
+	}
+
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	err := r.db.SelectContext(dbCtx, &pictures, query, &userID)
+	err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &pictures, query,
+		&userID, after != nil, &key.Position, &key.UUID, &limit)
 
 	return pictures, err
 }
 
+func (r *UserPicturesPostgres) GetUserPicturesByPaths(
+	ctx context.Context, paths []string,
+) ([]models.UserPicture, error) {
+	query := fmt.Sprintf(`
+SELECT uuid, user_id, picture_path, variants, content_hash, COALESCE(perceptual_hash, 0) AS perceptual_hash,
+position, caption, moderation_status, created_at FROM %s
+WHERE picture_path = ANY($1)`, usersPicturesTable)
+	var pictures []models.UserPicture
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &pictures, query, pq.Array(paths)); err != nil {
+		return nil, err
+	}
+
+	return pictures, nil
+}
+
+// GetUserPicturesAfterUUID returns pictures of all users ordered by uuid. It is used to iterate over all pictures.
+func (r *UserPicturesPostgres) GetUserPicturesAfterUUID(
+	ctx context.Context, after uuid.UUID, limit int,
+) ([]models.UserPicture, error) {
+	query := fmt.Sprintf(`
+SELECT uuid, user_id, picture_path, variants, content_hash, COALESCE(perceptual_hash, 0) AS perceptual_hash,
+position, caption, moderation_status, created_at FROM %s
+WHERE uuid > $1 ORDER BY uuid LIMIT $2`, usersPicturesTable)
+	var pictures []models.UserPicture
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &pictures, query, &after, &limit); err != nil {
+		return nil, err
+	}
+
+	return pictures, nil
+}
+
+func (r *UserPicturesPostgres) UpdateUserPictureVariants(
+	ctx context.Context, uuid uuid.UUID, variants models.PictureVariants,
+) error {
+	query := fmt.Sprintf(`UPDATE %s SET variants = $1 WHERE uuid = $2`, usersPicturesTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, variants, &uuid); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+// ReorderUserPictures sets positions of user pictures by order of uuids. It returns false
+// if uuids do not match current user pictures exactly.
+func (r *UserPicturesPostgres) ReorderUserPictures(
+	ctx context.Context, userID uint64, uuids []uuid.UUID,
+) (bool, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	uuidStrs := make([]string, len(uuids))
+	for i := range uuids {
+		uuidStrs[i] = uuids[i].String()
+	}
+
+	var reordered bool
+	err := runInTx(dbCtx, r.db, func(tx *sqlx.Tx) error {
+		if err := lockUser(dbCtx, tx, userID); err != nil {
+			return err
+		}
+
+		// uuids are checked before update, so transaction joined by caller is never left half-updated
+		var count, matchedNum int
+		query := fmt.Sprintf(`
+SELECT COUNT(*), COUNT(DISTINCT uuid) FILTER (WHERE uuid = ANY($2::UUID[])) FROM %s WHERE user_id=$1`,
+			usersPicturesTable)
+		if err := tx.QueryRowxContext(dbCtx, query, &userID, pq.Array(uuidStrs)).Scan(&count, &matchedNum); err != nil {
+			return err
+		}
+
+		if count != len(uuids) || matchedNum != count {
+			return nil
+		}
+
+		query = fmt.Sprintf(`
+UPDATE %s p SET position = o.position - 1
+FROM unnest($1::UUID[]) WITH ORDINALITY AS o(uuid, position)
+WHERE p.uuid = o.uuid AND p.user_id = $2`, usersPicturesTable)
+
+		if _, err := tx.ExecContext(dbCtx, query, pq.Array(uuidStrs), &userID); err != nil {
+			return getDBError(err)
+		}
+
+		reordered = true
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return reordered, nil
+}
+
+// UpdateUserPictureCaption returns false if user has no picture with uuid.
+func (r *UserPicturesPostgres) UpdateUserPictureCaption(
+	ctx context.Context, userID uint64, uuid uuid.UUID, caption string,
+) (bool, error) {
+	query := fmt.Sprintf(`UPDATE %s SET caption = $1 WHERE uuid = $2 AND user_id = $3`, usersPicturesTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	result, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &caption, &uuid, &userID)
+	if err != nil {
+		return false, getDBError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected != 0, nil
+}
+
+// GetPendingUserPictures returns pictures waiting for moderation after key, the oldest first.
+func (r *UserPicturesPostgres) GetPendingUserPictures(
+	ctx context.Context, after *models.PendingPictureKey, limit int,
+) ([]models.UserPicture, error) {
+	query := fmt.Sprintf(`
+SELECT uuid, user_id, picture_path, variants, content_hash, COALESCE(perceptual_hash, 0) AS perceptual_hash,
+position, caption, moderation_status, created_at FROM %s
+WHERE moderation_status = $1 AND (NOT $2 OR (created_at, uuid) > ($3, $4))
+ORDER BY created_at, uuid LIMIT NULLIF($5, 0)`, usersPicturesTable)
+	var pictures []models.UserPicture
+
+	var key models.PendingPictureKey
+	if after != nil {
+		key = *after
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &pictures, query,
+		models.PictureModerationPending, after != nil, &key.CreatedAt, &key.UUID, &limit,
+	); err != nil {
+		return nil, err
+	}
+
+	return pictures, nil
+}
+
+func (r *UserPicturesPostgres) SetUserPictureModeration(ctx context.Context, uuid uuid.UUID, status string) (bool, error) {
+	query := fmt.Sprintf(`UPDATE %s SET moderation_status = $1 WHERE uuid = $2`, usersPicturesTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	result, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &status, &uuid)
+	if err != nil {
+		return false, getDBError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected != 0, nil
+}
+
+// DeleteUserPicture deletes picture row of any user, it is used by moderation and reconciliation.
 func (r *UserPicturesPostgres) DeleteUserPicture(ctx context.Context, uuid uuid.UUID) error {
-	query := fmt.Sprintf(`DELETE FROM %s WHERE uuid = $1`, usersPicturesTable)
+	query := fmt.Sprintf(`DELETE FROM %s WHERE uuid = $1 RETURNING picture_path`, usersPicturesTable)
+
+	_, err := r.deleteUserPicture(ctx, query, &uuid)
 
+	return err
+}
+
+// DeleteUserPictureOfUser returns false if user has no picture with uuid.
+func (r *UserPicturesPostgres) DeleteUserPictureOfUser(
+	ctx context.Context, userID uint64, uuid uuid.UUID,
+) (bool, error) {
+	query := fmt.Sprintf(`
+DELETE FROM %s WHERE uuid = $1 AND user_id = $2 RETURNING picture_path`, usersPicturesTable)
+
+	return r.deleteUserPicture(ctx, query, &uuid, &userID)
+}
+
+// deleteUserPicture deletes picture row and adds storage outbox record of its path in one transaction,
+// so picture objects are deleted later even if deleting them right away fails.
+func (r *UserPicturesPostgres) deleteUserPicture(ctx context.Context, query string, args ...interface{}) (bool, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
 	defer cancel()
 
-	if _, err := r.db.ExecContext(dbCtx, query, &uuid); err != nil {
+	var deleted bool
+	err := runInTx(dbCtx, r.db, func(tx *sqlx.Tx) error {
+		var path string
+		if err := tx.GetContext(dbCtx, &path, query, args...); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+
+			return err
+		}
+		deleted = true
+
+		return addStorageOutboxRecord(dbCtx, tx, path)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return deleted, nil
+}
+
+// lockUser locks user row until the end of transaction to serialize changes of user pictures.
+func lockUser(ctx context.Context, tx *sqlx.Tx, userID uint64) error {
+	query := fmt.Sprintf(`SELECT id FROM %s WHERE id=$1 FOR UPDATE`, usersTable)
+
+	var id uint64
+	if err := tx.GetContext(ctx, &id, query, &userID); err != nil {
 		return err
 	}
 
@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const (
+	usersTwoFactorTable = "users_two_factor"
+)
+
+type UserTwoFactorPostgres struct {
+	db        *sqlx.DB
+	dbTimeout time.Duration
+}
+
+func NewUserTwoFactorPostgres(db *sqlx.DB, dbTimeout time.Duration) *UserTwoFactorPostgres {
+	return &UserTwoFactorPostgres{
+		db:        db,
+		dbTimeout: dbTimeout,
+	}
+}
+
+// PutUserTwoFactorSecret saves new not enabled secret for user replacing the previous one.
+func (r *UserTwoFactorPostgres) PutUserTwoFactorSecret(ctx context.Context, userID uint64, secret []byte) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (user_id, secret) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, is_enabled = FALSE, recovery_codes = ARRAY []::TEXT[], last_totp_step = NULL`,
+		usersTwoFactorTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &userID, &secret); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+func (r *UserTwoFactorPostgres) GetUserTwoFactor(ctx context.Context, userID uint64) (*models.UserTwoFactor, error) {
+	query := fmt.Sprintf(`
+SELECT user_id, secret, is_enabled, recovery_codes FROM %s WHERE user_id=$1`, usersTwoFactorTable)
+	var twoFactor models.UserTwoFactor
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if err := getQueryer(dbCtx, r.db).GetContext(dbCtx, &twoFactor, query, &userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &twoFactor, nil
+}
+
+func (r *UserTwoFactorPostgres) EnableUserTwoFactor(ctx context.Context, userID uint64) error {
+	query := fmt.Sprintf(`UPDATE %s SET is_enabled = TRUE WHERE user_id = $1`, usersTwoFactorTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &userID); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+func (r *UserTwoFactorPostgres) UpdateUserRecoveryCodes(ctx context.Context, userID uint64, codeHashes []string) error {
+	query := fmt.Sprintf(`UPDATE %s SET recovery_codes = $1 WHERE user_id = $2`, usersTwoFactorTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, pq.Array(codeHashes), &userID); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+// UseUserRecoveryCode removes recovery code from user codes.
+// It returns false if user has no such code, so every code can be used only once.
+func (r *UserTwoFactorPostgres) UseUserRecoveryCode(ctx context.Context, userID uint64, codeHash string) (bool, error) {
+	query := fmt.Sprintf(`
+UPDATE %s SET recovery_codes = array_remove(recovery_codes, $1)
+WHERE user_id = $2 AND $1 = ANY (recovery_codes)`, usersTwoFactorTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	res, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &codeHash, &userID)
+	if err != nil {
+		return false, getDBError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// UseUserTOTPStep saves time step of accepted TOTP code. It returns false if code of the same
+// or later step was already accepted, so every code can be used only once.
+func (r *UserTwoFactorPostgres) UseUserTOTPStep(ctx context.Context, userID uint64, step int64) (bool, error) {
+	query := fmt.Sprintf(`
+UPDATE %s SET last_totp_step = $1
+WHERE user_id = $2 AND (last_totp_step IS NULL OR last_totp_step < $1)`, usersTwoFactorTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	res, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &step, &userID)
+	if err != nil {
+		return false, getDBError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (r *UserTwoFactorPostgres) DeleteUserTwoFactor(ctx context.Context, userID uint64) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, usersTwoFactorTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &userID); err != nil {
+		return err
+	}
+
+	return nil
+}
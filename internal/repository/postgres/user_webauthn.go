@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/l-orlov/matcha/internal/models"
+)
+
+const (
+	usersWebAuthnCredentialsTable = "users_webauthn_credentials"
+)
+
+type UserWebAuthnPostgres struct {
+	db        *sqlx.DB
+	dbTimeout time.Duration
+}
+
+func NewUserWebAuthnPostgres(db *sqlx.DB, dbTimeout time.Duration) *UserWebAuthnPostgres {
+	return &UserWebAuthnPostgres{
+		db:        db,
+		dbTimeout: dbTimeout,
+	}
+}
+
+func (r *UserWebAuthnPostgres) CreateWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (id, user_id, public_key, attestation_type, aaguid, sign_count, flags)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`, usersWebAuthnCredentialsTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	_, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &credential.ID, &credential.UserID, &credential.PublicKey,
+		&credential.AttestationType, &credential.AAGUID, &credential.SignCount, &credential.Flags)
+	if err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+// GetWebAuthnCredentialsByUserID returns user credentials after key, the oldest first.
+// Zero limit returns all credentials.
+func (r *UserWebAuthnPostgres) GetWebAuthnCredentialsByUserID(
+	ctx context.Context, userID uint64, after *models.WebAuthnCredentialKey, limit int,
+) ([]models.WebAuthnCredential, error) {
+	query := fmt.Sprintf(`
+SELECT id, user_id, public_key, attestation_type, aaguid, sign_count, flags, created_at, last_used_at
+FROM %s WHERE user_id=$1 AND (NOT $2 OR (created_at, id) > ($3, $4))
+ORDER BY created_at, id LIMIT NULLIF($5, 0)`, usersWebAuthnCredentialsTable)
+	var credentials []models.WebAuthnCredential
+
+	var key models.WebAuthnCredentialKey
+	if after != nil {
+		key = *after
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	err := getQueryer(dbCtx, r.db).SelectContext(dbCtx, &credentials, query,
+		&userID, after != nil, &key.CreatedAt, &key.ID, &limit)
+
+	return credentials, err
+}
+
+// UpdateWebAuthnCredentialUsage saves sign counter and authenticator flags of the last assertion.
+func (r *UserWebAuthnPostgres) UpdateWebAuthnCredentialUsage(
+	ctx context.Context, id []byte, signCount uint32, flags byte,
+) error {
+	query := fmt.Sprintf(`
+UPDATE %s SET sign_count = $1, flags = $2, last_used_at = NOW() WHERE id = $3`, usersWebAuthnCredentialsTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &signCount, int16(flags), &id); err != nil {
+		return getDBError(err)
+	}
+
+	return nil
+}
+
+func (r *UserWebAuthnPostgres) DeleteWebAuthnCredential(ctx context.Context, userID uint64, id []byte) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1 AND id = $2`, usersWebAuthnCredentialsTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &userID, &id); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserWebAuthnPostgres) DeleteWebAuthnCredentialsByUserID(ctx context.Context, userID uint64) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, usersWebAuthnCredentialsTable)
+
+	dbCtx, cancel := context.WithTimeout(ctx, r.dbTimeout)
+	defer cancel()
+
+	if _, err := getQueryer(dbCtx, r.db).ExecContext(dbCtx, query, &userID); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/google/uuid"
+)
+
+const rateLimitKeyPrefix = "rl:"
+
+// rateLimitScript implements sliding window log. Request timestamps are stored in sorted set.
+// It returns 0 if request is allowed, else milliseconds to wait until the oldest request leaves the window.
+var rateLimitScript = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+
+if redis.call('ZCARD', KEYS[1]) < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return 0
+end
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local retryAfter = tonumber(oldest[2]) + window - now
+if retryAfter < 1 then
+	retryAfter = 1
+end
+
+return retryAfter
+`)
+
+// AddRequest registers request by key if limit is not exceeded in sliding window.
+// It returns zero retryAfter on success.
+func (r *Redis) AddRequest(key string, limit int, window time.Duration) (retryAfter time.Duration, err error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	retryAfterMs, err := redis.Int64(rateLimitScript.Do(
+		conn, rateLimitKeyPrefix+key, now, window.Milliseconds(), limit, uuid.New().String(),
+	))
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(retryAfterMs) * time.Millisecond, nil
+}
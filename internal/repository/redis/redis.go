@@ -3,6 +3,8 @@ package redis
 import (
 	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -18,6 +20,18 @@ const (
 	userBlockingKeyPrefix              = "ub:"
 	emailConfirmTokenKeyPrefix         = "eConf:"
 	passwordResetConfirmTokenKeyPrefix = "rpConf:"
+	twoFactorChallengeTokenKeyPrefix   = "tfChallenge:"
+	twoFactorChallengeAttemptKeyPrefix = "tfAttempt:"
+	oauthStateKeyPrefix                = "oauthState:"
+	magicLinkTokenKeyPrefix            = "mlConf:"
+	magicLinkRequestKeyPrefix          = "mlReq:"
+	webAuthnSessionKeyPrefix           = "waSession:"
+	loginFailuresKeyPrefix             = "lf:"
+	loginLockKeyPrefix                 = "ll:"
+	loginAlertTokenKeyPrefix           = "laConf:"
+	dataExportRequestKeyPrefix         = "deReq:"
+	dataExportQueueKey                 = "deQueue"
+	pictureURLKeyPrefix                = "pURL:"
 )
 
 type (
@@ -27,6 +41,14 @@ type (
 		UserBlockingLifetime              int
 		EmailConfirmTokenLifetime         int
 		PasswordResetConfirmTokenLifetime int
+		TwoFactorChallengeTokenLifetime   int
+		OAuthStateLifetime                int
+		MagicLinkTokenLifetime            int
+		MagicLinkLimitWindow              int
+		WebAuthnSessionLifetime           int
+		LoginFailuresLifetime             int
+		LoginAlertTokenLifetime           int
+		DataExportRequestsInterval        int
 	}
 	Redis struct {
 		log     *logrus.Entry
@@ -178,6 +200,115 @@ func (r *Redis) DeleteUserToSession(userID, refreshToken string) error {
 	return nil
 }
 
+// DeleteUserSessions deletes all sessions of user with their access tokens.
+func (r *Redis) DeleteUserSessions(userID string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	keys, err := scanKeys(conn, userToSessionKeyPrefix+userID+":*")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := r.deleteUserToSession(conn, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetUserSessions returns all active sessions of user.
+func (r *Redis) GetUserSessions(userID string) ([]models.Session, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	keys, err := scanKeys(conn, userToSessionKeyPrefix+userID+":*")
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]models.Session, 0, len(keys))
+	for _, key := range keys {
+		refreshToken := key[strings.LastIndex(key, ":")+1:]
+
+		resp, err := redis.Bytes(conn.Do("GET", sessionKeyPrefix+refreshToken))
+		if err != nil {
+			if errors.Is(err, redis.ErrNil) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		var session models.Session
+		if err = json.Unmarshal(resp, &session); err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func scanKeys(conn redis.Conn, pattern string) ([]string, error) {
+	var keys []string
+
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return nil, err
+		}
+
+		pageKeys, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, pageKeys...)
+
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+func (r *Redis) deleteUserToSession(conn redis.Conn, userToSessionKey string) error {
+	accessTokenID, err := redis.String(conn.Do("GET", userToSessionKey))
+	if err != nil && !errors.Is(err, redis.ErrNil) {
+		return err
+	}
+
+	refreshToken := userToSessionKey[strings.LastIndex(userToSessionKey, ":")+1:]
+
+	_, err = conn.Do("DEL", userToSessionKey,
+		sessionKeyPrefix+refreshToken, accessTokenKeyPrefix+accessTokenID,
+	)
+
+	return err
+}
+
 func (r *Redis) GetAccessTokenData(accessTokenID string) (refreshToken string, err error) {
 	conn, err := r.getConnect()
 	if err != nil {
@@ -387,3 +518,571 @@ func (r *Redis) DeletePasswordResetConfirmToken(token string) error {
 
 	return nil
 }
+
+func (r *Redis) PutTwoFactorChallengeToken(userID uint64, token string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("SETEX", twoFactorChallengeTokenKeyPrefix+token,
+		r.options.TwoFactorChallengeTokenLifetime, userID,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) GetTwoFactorChallengeTokenData(token string) (userID uint64, err error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	userID, err = redis.Uint64(conn.Do("GET", twoFactorChallengeTokenKeyPrefix+token))
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// AddTwoFactorChallengeAttempt counts attempts to complete two-factor challenge. Counter lives as long as token.
+func (r *Redis) AddTwoFactorChallengeAttempt(token string) (int64, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	key := twoFactorChallengeAttemptKeyPrefix + token
+
+	count, err := redis.Int64(conn.Do("INCR", key))
+	if err != nil {
+		return 0, err
+	}
+
+	if count == 1 {
+		if _, err = conn.Do("EXPIRE", key, r.options.TwoFactorChallengeTokenLifetime); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+func (r *Redis) DeleteTwoFactorChallengeToken(token string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("DEL", twoFactorChallengeTokenKeyPrefix+token); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) PutOAuthState(state string, data models.OAuthState) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	dataBytes, err := json.Marshal(&data)
+	if err != nil {
+		return err
+	}
+
+	if _, err = conn.Do("SETEX", oauthStateKeyPrefix+state,
+		r.options.OAuthStateLifetime, dataBytes,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) GetOAuthState(state string) (*models.OAuthState, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	resp, err := redis.Bytes(conn.Do("GET", oauthStateKeyPrefix+state))
+	if err != nil {
+		return nil, err
+	}
+
+	data := &models.OAuthState{}
+	if err = json.Unmarshal(resp, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *Redis) DeleteOAuthState(state string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("DEL", oauthStateKeyPrefix+state); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) PutMagicLinkToken(userID uint64, token string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("SETEX", magicLinkTokenKeyPrefix+token,
+		r.options.MagicLinkTokenLifetime, userID,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeMagicLinkToken gets and deletes token in one transaction, so only one of concurrent requests
+// gets its data. It returns redis.ErrNil if token does not exist.
+func (r *Redis) ConsumeMagicLinkToken(token string) (userID uint64, err error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if err = conn.Send("MULTI"); err != nil {
+		return 0, err
+	}
+
+	if err = conn.Send("GET", magicLinkTokenKeyPrefix+token); err != nil {
+		return 0, err
+	}
+
+	if err = conn.Send("DEL", magicLinkTokenKeyPrefix+token); err != nil {
+		return 0, err
+	}
+
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+
+	// token which was not deleted by this transaction is consumed by another one
+	if deleted, err := redis.Int(values[1], nil); err != nil || deleted != 1 {
+		return 0, redis.ErrNil
+	}
+
+	return redis.Uint64(values[0], nil)
+}
+
+// AddMagicLinkRequest counts magic link requests for email in fixed window.
+func (r *Redis) AddMagicLinkRequest(email string) (int64, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	key := magicLinkRequestKeyPrefix + strings.ToLower(email)
+
+	count, err := redis.Int64(conn.Do("INCR", key))
+	if err != nil {
+		return 0, err
+	}
+
+	// window starts with the first request
+	if count == 1 {
+		if _, err = conn.Do("EXPIRE", key, r.options.MagicLinkLimitWindow); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+func (r *Redis) PutWebAuthnSession(key string, session models.WebAuthnSession) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	sessionBytes, err := json.Marshal(&session)
+	if err != nil {
+		return err
+	}
+
+	if _, err = conn.Do("SETEX", webAuthnSessionKeyPrefix+key,
+		r.options.WebAuthnSessionLifetime, sessionBytes,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) GetWebAuthnSession(key string) (*models.WebAuthnSession, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	resp, err := redis.Bytes(conn.Do("GET", webAuthnSessionKeyPrefix+key))
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.WebAuthnSession{}
+	if err = json.Unmarshal(resp, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (r *Redis) DeleteWebAuthnSession(key string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("DEL", webAuthnSessionKeyPrefix+key); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddLoginFailure counts failed logins by key. Counter lives while failures continue.
+func (r *Redis) AddLoginFailure(key string) (int64, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	count, err := redis.Int64(conn.Do("INCR", loginFailuresKeyPrefix+key))
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err = conn.Do("EXPIRE", loginFailuresKeyPrefix+key, r.options.LoginFailuresLifetime); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *Redis) DeleteLoginFailures(key string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("DEL", loginFailuresKeyPrefix+key); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) PutLoginLock(key string, lifetime time.Duration) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("SET", loginLockKeyPrefix+key, 1, "PX", lifetime.Milliseconds()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetLoginLockTTL returns time left until login by key is unlocked. It is zero if login is not locked.
+func (r *Redis) GetLoginLockTTL(key string) (time.Duration, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	ttl, err := redis.Int64(conn.Do("PTTL", loginLockKeyPrefix+key))
+	if err != nil {
+		return 0, err
+	}
+
+	// negative ttl means that key does not exist
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+func (r *Redis) PutLoginAlertToken(userID uint64, token string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("SETEX", loginAlertTokenKeyPrefix+token,
+		r.options.LoginAlertTokenLifetime, userID,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) GetLoginAlertTokenData(token string) (userID uint64, err error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	userID, err = redis.Uint64(conn.Do("GET", loginAlertTokenKeyPrefix+token))
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (r *Redis) DeleteLoginAlertToken(token string) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("DEL", loginAlertTokenKeyPrefix+token); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddDataExportRequest puts user to data export queue.
+// It returns false if user has already requested export within requests interval.
+func (r *Redis) AddDataExportRequest(userID uint64) (bool, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	_, err = redis.String(conn.Do("SET", dataExportRequestKeyPrefix+strconv.FormatUint(userID, 10), 1,
+		"EX", r.options.DataExportRequestsInterval, "NX",
+	))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if _, err = conn.Do("LPUSH", dataExportQueueKey, userID); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteDataExportRequest deletes mark of user data export request, so user can request export again.
+func (r *Redis) DeleteDataExportRequest(userID uint64) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("DEL", dataExportRequestKeyPrefix+strconv.FormatUint(userID, 10)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PopDataExportRequest takes user from data export queue. It waits for request not longer than timeout.
+func (r *Redis) PopDataExportRequest(timeout time.Duration) (userID uint64, ok bool, err error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	values, err := redis.Values(conn.Do("BRPOP", dataExportQueueKey, int(timeout.Seconds())))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return 0, false, nil
+		}
+
+		return 0, false, err
+	}
+
+	if userID, err = redis.Uint64(values[1], nil); err != nil {
+		return 0, false, err
+	}
+
+	return userID, true, nil
+}
+
+func (r *Redis) PutPictureURL(key, url string, lifetime time.Duration) error {
+	conn, err := r.getConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	if _, err = conn.Do("SET", pictureURLKeyPrefix+key, url, "PX", lifetime.Milliseconds()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPictureURL returns cached picture URL. It is empty if URL is not cached.
+func (r *Redis) GetPictureURL(key string) (string, error) {
+	conn, err := r.getConnect()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			r.log.Error(err)
+		}
+	}()
+
+	url, err := redis.String(conn.Do("GET", pictureURLKeyPrefix+key))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return url, nil
+}
@@ -9,9 +9,12 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/l-orlov/matcha/internal/config"
 	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository/local"
+	"github.com/l-orlov/matcha/internal/repository/memory"
 	"github.com/l-orlov/matcha/internal/repository/minio"
 	"github.com/l-orlov/matcha/internal/repository/postgres"
 	"github.com/l-orlov/matcha/internal/repository/redis"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,29 +26,105 @@ type (
 		GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 		UpdateUser(ctx context.Context, user models.User) error
 		UpdateUserPassword(ctx context.Context, userID uint64, password string) error
-		GetAllUsers(ctx context.Context) ([]models.User, error)
+		GetAllUsers(ctx context.Context, afterID uint64, limit int) ([]models.User, error)
 		DeleteUser(ctx context.Context, id uint64) error
+		DeleteScheduledUser(ctx context.Context, id uint64, before time.Time) (bool, error)
+		LockUser(ctx context.Context, id uint64) (bool, error)
 		ConfirmEmail(ctx context.Context, id uint64) error
+		AddUserRole(ctx context.Context, userID uint64, role string) error
+		ScheduleUserDeletion(ctx context.Context, userID uint64, deleteAt time.Time) error
+		UpdateUserState(ctx context.Context, userID uint64, state string) error
+		ReactivateUser(ctx context.Context, userID uint64) (bool, error)
+		GetUsersScheduledForDeletion(ctx context.Context, before time.Time, limit int) ([]uint64, error)
 		GetUserProfileByID(ctx context.Context, id uint64) (*models.UserProfile, error)
+		GetVisibleUserProfileByID(ctx context.Context, id uint64) (*models.UserProfile, error)
 		UpdateUserProfile(ctx context.Context, user models.UserProfile) error
-		UpdateUserAvatarPath(ctx context.Context, userID uint64, avatarPath string) error
+		UpdateUserAvatar(ctx context.Context, userID uint64, avatar models.UserAvatar) error
+		GetPendingUserAvatars(ctx context.Context, afterID uint64, limit int) ([]models.UserAvatar, error)
+		SetUserAvatarModeration(ctx context.Context, userID uint64, contentHash, status string) (bool, error)
 	}
 	UserPictures interface {
-		CreateUserPicture(ctx context.Context, picture models.UserPicture) error
+		CreateUserPicture(ctx context.Context, picture models.UserPicture, maxNum int) (bool, error)
 		GetUserPictureByUUID(ctx context.Context, uuid uuid.UUID) (*models.UserPicture, error)
-		GetUserPicturesByUserID(ctx context.Context, userID uint64) ([]models.UserPicture, error)
+		GetUserPicturesByUserID(
+			ctx context.Context, userID uint64, after *models.UserPictureKey, limit int,
+		) ([]models.UserPicture, error)
+		ReorderUserPictures(ctx context.Context, userID uint64, uuids []uuid.UUID) (bool, error)
+		UpdateUserPictureCaption(ctx context.Context, userID uint64, uuid uuid.UUID, caption string) (bool, error)
+		GetUserPicturesByPaths(ctx context.Context, paths []string) ([]models.UserPicture, error)
+		GetUserPicturesAfterUUID(ctx context.Context, after uuid.UUID, limit int) ([]models.UserPicture, error)
+		UpdateUserPictureVariants(ctx context.Context, uuid uuid.UUID, variants models.PictureVariants) error
+		GetPendingUserPictures(
+			ctx context.Context, after *models.PendingPictureKey, limit int,
+		) ([]models.UserPicture, error)
+		SetUserPictureModeration(ctx context.Context, uuid uuid.UUID, status string) (bool, error)
 		DeleteUserPicture(ctx context.Context, uuid uuid.UUID) error
+		DeleteUserPictureOfUser(ctx context.Context, userID uint64, uuid uuid.UUID) (bool, error)
+	}
+	PictureMatches interface {
+		GetPictureHashCandidates(ctx context.Context, userID uint64, hash int64, limit int) ([]models.PictureHash, error)
+		AddPictureMatches(ctx context.Context, matches []models.PictureMatch) error
+		GetPictureMatches(ctx context.Context, after *models.PictureMatchKey, limit int) ([]models.PictureMatch, error)
+		DeletePictureMatch(ctx context.Context, id uint64) (bool, error)
+	}
+	TxManager interface {
+		WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	}
+	StorageOutbox interface {
+		AddStorageOutboxRecord(ctx context.Context, objectPrefix string) error
+		AddStorageOutboxRecords(ctx context.Context, objectPrefixes []string) error
+		GetStorageOutboxRecords(ctx context.Context, before time.Time, limit int) ([]string, error)
+		DeleteStorageOutboxRecord(ctx context.Context, objectPrefix string) error
+		DeleteStorageOutboxRecords(ctx context.Context, objectPrefixes []string) error
+	}
+	Seed interface {
+		ReserveUserIDs(ctx context.Context, num int) ([]uint64, error)
+		CopyUsers(ctx context.Context, users []models.SeedUser) error
+	}
+	UserTwoFactor interface {
+		PutUserTwoFactorSecret(ctx context.Context, userID uint64, secret []byte) error
+		GetUserTwoFactor(ctx context.Context, userID uint64) (*models.UserTwoFactor, error)
+		EnableUserTwoFactor(ctx context.Context, userID uint64) error
+		UpdateUserRecoveryCodes(ctx context.Context, userID uint64, codeHashes []string) error
+		UseUserRecoveryCode(ctx context.Context, userID uint64, codeHash string) (bool, error)
+		UseUserTOTPStep(ctx context.Context, userID uint64, step int64) (bool, error)
+		DeleteUserTwoFactor(ctx context.Context, userID uint64) error
+	}
+	UserIdentity interface {
+		CreateUserIdentity(ctx context.Context, identity models.UserIdentity) error
+		GetUserIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error)
+		GetUserIdentitiesByUserID(ctx context.Context, userID uint64) ([]models.UserIdentity, error)
+		DeleteUserIdentitiesByUserID(ctx context.Context, userID uint64) error
+	}
+	UserWebAuthn interface {
+		CreateWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) error
+		GetWebAuthnCredentialsByUserID(
+			ctx context.Context, userID uint64, after *models.WebAuthnCredentialKey, limit int,
+		) ([]models.WebAuthnCredential, error)
+		UpdateWebAuthnCredentialUsage(ctx context.Context, id []byte, signCount uint32, flags byte) error
+		DeleteWebAuthnCredential(ctx context.Context, userID uint64, id []byte) error
+		DeleteWebAuthnCredentialsByUserID(ctx context.Context, userID uint64) error
+	}
+	UserLoginDevice interface {
+		GetUserLoginDevices(ctx context.Context, userID uint64) ([]models.UserLoginDevice, error)
+		UpsertUserLoginDevice(ctx context.Context, device models.UserLoginDevice) error
 	}
 	SessionCache interface {
 		PutSessionAndAccessToken(session models.Session, refreshToken string) error
 		GetSession(refreshToken string) (*models.Session, error)
 		DeleteSession(refreshToken string) error
 		DeleteUserToSession(userID, refreshToken string) error
+		DeleteUserSessions(userID string) error
+		GetUserSessions(userID string) ([]models.Session, error)
 		GetAccessTokenData(accessTokenID string) (refreshToken string, err error)
 		DeleteAccessToken(accessTokenID string) error
 		AddUserBlocking(fingerprint string) (int64, error)
 		GetUserBlocking(fingerprint string) (int, error)
 		DeleteUserBlocking(fingerprint string) error
+		AddLoginFailure(key string) (int64, error)
+		DeleteLoginFailures(key string) error
+		PutLoginLock(key string, lifetime time.Duration) error
+		GetLoginLockTTL(key string) (time.Duration, error)
 	}
 	VerificationCache interface {
 		PutEmailConfirmToken(userID uint64, token string) error
@@ -54,17 +133,65 @@ type (
 		PutPasswordResetConfirmToken(userID uint64, token string) error
 		GetPasswordResetConfirmTokenData(token string) (userID uint64, err error)
 		DeletePasswordResetConfirmToken(token string) error
+		PutTwoFactorChallengeToken(userID uint64, token string) error
+		GetTwoFactorChallengeTokenData(token string) (userID uint64, err error)
+		AddTwoFactorChallengeAttempt(token string) (int64, error)
+		DeleteTwoFactorChallengeToken(token string) error
+		PutOAuthState(state string, data models.OAuthState) error
+		GetOAuthState(state string) (*models.OAuthState, error)
+		DeleteOAuthState(state string) error
+		PutMagicLinkToken(userID uint64, token string) error
+		ConsumeMagicLinkToken(token string) (userID uint64, err error)
+		AddMagicLinkRequest(email string) (int64, error)
+		PutWebAuthnSession(key string, session models.WebAuthnSession) error
+		GetWebAuthnSession(key string) (*models.WebAuthnSession, error)
+		DeleteWebAuthnSession(key string) error
+		PutLoginAlertToken(userID uint64, token string) error
+		GetLoginAlertTokenData(token string) (userID uint64, err error)
+		DeleteLoginAlertToken(token string) error
+	}
+	RateLimitCache interface {
+		AddRequest(key string, limit int, window time.Duration) (retryAfter time.Duration, err error)
+	}
+	DataExportCache interface {
+		AddDataExportRequest(userID uint64) (bool, error)
+		DeleteDataExportRequest(userID uint64) error
+		PopDataExportRequest(timeout time.Duration) (userID uint64, ok bool, err error)
+	}
+	PictureURLCache interface {
+		PutPictureURL(key, url string, lifetime time.Duration) error
+		GetPictureURL(key string) (string, error)
 	}
 	Storage interface {
+		ProvisionBucket(ctx context.Context, bucket string, settings models.StorageBucketSettings) error
 		PutFile(ctx context.Context, bucketName, objectName, contentType string, reader io.Reader) error
+		GetFile(ctx context.Context, bucket, objectName string) ([]byte, error)
 		GetFileURL(ctx context.Context, bucket, objectName string, expires time.Duration) (url string, err error)
+		GetFileUpload(
+			ctx context.Context, bucket, objectName, contentType string, maxSize int64, expires time.Duration,
+		) (*models.StorageUpload, error)
+		GetFileInfo(ctx context.Context, bucket, objectName string) (*models.StorageObject, error)
+		CopyFile(ctx context.Context, bucket, srcObjectName, dstObjectName string) error
 		DeleteFile(ctx context.Context, bucket, objectName string) error
+		ListFiles(ctx context.Context, bucket, prefix string) ([]models.StorageObject, error)
+		DeleteFilesByPrefix(ctx context.Context, bucket, prefix string) error
 	}
 	Repository struct {
+		TxManager
 		User
 		UserPictures
+		PictureMatches
+		StorageOutbox
+		Seed
+		UserTwoFactor
+		UserIdentity
+		UserWebAuthn
+		UserLoginDevice
 		SessionCache
 		VerificationCache
+		RateLimitCache
+		DataExportCache
+		PictureURLCache
 		Storage
 	}
 )
@@ -72,8 +199,20 @@ type (
 func NewRepository(
 	cfg *config.Config, log *logrus.Logger, db *sqlx.DB,
 ) (*Repository, error) {
+	txManager, err := postgres.NewTxManager(db, cfg.PostgresDB.Transactions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create transaction manager")
+	}
+
 	userRepo := postgres.NewUserPostgres(db, cfg.PostgresDB.Timeout.Duration())
 	userPicturesRepo := postgres.NewUserPicturesPostgres(db, cfg.PostgresDB.Timeout.Duration())
+	pictureMatchesRepo := postgres.NewPictureMatchesPostgres(db, cfg.PostgresDB.Timeout.Duration())
+	storageOutboxRepo := postgres.NewStorageOutboxPostgres(db, cfg.PostgresDB.Timeout.Duration())
+	seedRepo := postgres.NewSeedPostgres(db, cfg.PostgresDB.Timeout.Duration())
+	userTwoFactorRepo := postgres.NewUserTwoFactorPostgres(db, cfg.PostgresDB.Timeout.Duration())
+	userIdentityRepo := postgres.NewUserIdentityPostgres(db, cfg.PostgresDB.Timeout.Duration())
+	userWebAuthnRepo := postgres.NewUserWebAuthnPostgres(db, cfg.PostgresDB.Timeout.Duration())
+	userLoginDeviceRepo := postgres.NewUserLoginDevicePostgres(db, cfg.PostgresDB.Timeout.Duration())
 
 	cacheLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "cache-redis"})
 	cacheOptions := redis.Options{
@@ -82,26 +221,64 @@ func NewRepository(
 		UserBlockingLifetime:              int(cfg.UserBlocking.Lifetime.Duration().Seconds()),
 		EmailConfirmTokenLifetime:         int(cfg.Verification.EmailConfirmTokenLifetime.Duration().Seconds()),
 		PasswordResetConfirmTokenLifetime: int(cfg.Verification.PasswordResetConfirmTokenLifetime.Duration().Seconds()),
+		TwoFactorChallengeTokenLifetime:   int(cfg.Verification.TwoFactorChallengeTokenLifetime.Duration().Seconds()),
+		OAuthStateLifetime:                int(cfg.OAuth.StateLifetime.Duration().Seconds()),
+		MagicLinkTokenLifetime:            int(cfg.Verification.MagicLinkTokenLifetime.Duration().Seconds()),
+		MagicLinkLimitWindow:              int(cfg.MagicLinkLimit.Window.Duration().Seconds()),
+		WebAuthnSessionLifetime:           int(cfg.WebAuthn.SessionLifetime.Duration().Seconds()),
+		LoginFailuresLifetime:             int(cfg.LoginLockout.FailuresLifetime.Duration().Seconds()),
+		LoginAlertTokenLifetime:           int(cfg.Verification.LoginAlertTokenLifetime.Duration().Seconds()),
+		DataExportRequestsInterval:        int(cfg.DataExport.RequestsInterval.Duration().Seconds()),
 	}
 	cache := redis.New(cfg.Redis, cacheLogEntry, cacheOptions)
 
-	storageEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "minio-storage"})
-	storage, err := minio.New(minio.Config{
-		Endpoint:  cfg.Minio.Endpoint.String(),
-		AccessKey: cfg.Minio.AccessKey,
-		SecretKey: cfg.Minio.SecretKey,
-		UseSSL:    cfg.Minio.UseSSL,
-		Timeout:   cfg.Minio.Timeout.Duration(),
-	}, storageEntry)
+	storage, err := newStorage(cfg, log)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Repository{
+		TxManager:         txManager,
 		User:              userRepo,
 		UserPictures:      userPicturesRepo,
+		PictureMatches:    pictureMatchesRepo,
+		StorageOutbox:     storageOutboxRepo,
+		Seed:              seedRepo,
+		UserTwoFactor:     userTwoFactorRepo,
+		UserIdentity:      userIdentityRepo,
+		UserWebAuthn:      userWebAuthnRepo,
+		UserLoginDevice:   userLoginDeviceRepo,
 		SessionCache:      cache,
 		VerificationCache: cache,
+		RateLimitCache:    cache,
+		DataExportCache:   cache,
+		PictureURLCache:   cache,
 		Storage:           storage,
 	}, nil
 }
+
+func newStorage(cfg *config.Config, log *logrus.Logger) (Storage, error) {
+	switch cfg.Storage.Backend {
+	case config.StorageBackendMinio, "":
+		storageEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "minio-storage"})
+		return minio.New(minio.Config{
+			Endpoint:  cfg.Minio.Endpoint.String(),
+			AccessKey: cfg.Minio.AccessKey,
+			SecretKey: cfg.Minio.SecretKey,
+			UseSSL:    cfg.Minio.UseSSL,
+			Timeout:   cfg.Minio.Timeout.Duration(),
+		}, storageEntry)
+	case config.StorageBackendLocal:
+		storageEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "local-storage"})
+		return local.New(local.Config{
+			RootDir:       cfg.Storage.Local.RootDir,
+			BaseURL:       cfg.Storage.Local.BaseURL,
+			SignKey:       cfg.Storage.Local.SignKey,
+			MaxUploadSize: cfg.Storage.Local.MaxUploadSize,
+		}, storageEntry)
+	case config.StorageBackendMemory:
+		return memory.New(), nil
+	default:
+		return nil, errors.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
@@ -0,0 +1,406 @@
+// Package storagetest is conformance suite of repository.Storage implementations.
+// Every backend must pass it, e.g. from test of backend package:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) repository.Storage { return memory.New() }, "test")
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+)
+
+const (
+	contentType   = "image/jpeg"
+	urlExpires    = time.Minute
+	maxUploadSize = 1 << 10
+)
+
+var bucketSettings = models.StorageBucketSettings{
+	LifecycleRules: []models.StorageLifecycleRule{{Prefix: "uploads/", ExpirationDays: 1}},
+}
+
+// Run runs all conformance tests. newStorage must return empty storage, bucket is provisioned by tests.
+func Run(t *testing.T, newStorage func(t *testing.T) repository.Storage, bucket string) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, storage repository.Storage, bucket string)
+	}{
+		{name: "ProvisionBucket", test: testProvisionBucket},
+		{name: "PutAndGetFile", test: testPutAndGetFile},
+		{name: "PutFileOverwrites", test: testPutFileOverwrites},
+		{name: "GetMissingFile", test: testGetMissingFile},
+		{name: "GetFileInfo", test: testGetFileInfo},
+		{name: "GetFileURLs", test: testGetFileURLs},
+		{name: "UploadConstraints", test: testUploadConstraints},
+		{name: "CopyFile", test: testCopyFile},
+		{name: "CopyMissingFile", test: testCopyMissingFile},
+		{name: "DeleteFile", test: testDeleteFile},
+		{name: "ListFiles", test: testListFiles},
+		{name: "DeleteFilesByPrefix", test: testDeleteFilesByPrefix},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newStorage(t)
+			if err := storage.ProvisionBucket(context.Background(), bucket, bucketSettings); err != nil {
+				t.Fatalf("ProvisionBucket: %v", err)
+			}
+
+			tt.test(t, storage, bucket)
+		})
+	}
+}
+
+func testProvisionBucket(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	putFile(t, storage, bucket, "users/1/avatar", "avatar")
+
+	// provisioning is done on every startup, so it must keep existing files
+	if err := storage.ProvisionBucket(ctx, bucket, bucketSettings); err != nil {
+		t.Fatalf("ProvisionBucket of existing bucket: %v", err)
+	}
+
+	checkFileNames(t, storage, bucket, "", []string{"users/1/avatar"})
+}
+
+func testPutAndGetFile(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	putFile(t, storage, bucket, "users/1/avatar", "avatar")
+
+	data, err := storage.GetFile(ctx, bucket, "users/1/avatar")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	if string(data) != "avatar" {
+		t.Errorf("GetFile returned %q, want %q", data, "avatar")
+	}
+}
+
+func testPutFileOverwrites(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	putFile(t, storage, bucket, "users/1/avatar", "old")
+	putFile(t, storage, bucket, "users/1/avatar", "new")
+
+	data, err := storage.GetFile(ctx, bucket, "users/1/avatar")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	if string(data) != "new" {
+		t.Errorf("GetFile returned %q, want %q", data, "new")
+	}
+}
+
+func testGetMissingFile(t *testing.T, storage repository.Storage, bucket string) {
+	if _, err := storage.GetFile(context.Background(), bucket, "users/1/missing"); err == nil {
+		t.Error("GetFile of missing file returned no error")
+	}
+}
+
+func testGetFileInfo(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	putFile(t, storage, bucket, "users/1/avatar", "avatar")
+
+	info, err := storage.GetFileInfo(ctx, bucket, "users/1/avatar")
+	if err != nil {
+		t.Fatalf("GetFileInfo: %v", err)
+	}
+
+	if info == nil {
+		t.Fatal("GetFileInfo returned nil for existing file")
+	}
+
+	if info.Name != "users/1/avatar" || info.Size != int64(len("avatar")) || info.ContentType != contentType {
+		t.Errorf("GetFileInfo returned %+v", *info)
+	}
+
+	if info.LastModified.IsZero() {
+		t.Error("GetFileInfo returned zero last modified time")
+	}
+
+	info, err = storage.GetFileInfo(ctx, bucket, "users/1/missing")
+	if err != nil {
+		t.Fatalf("GetFileInfo of missing file: %v", err)
+	}
+
+	if info != nil {
+		t.Errorf("GetFileInfo of missing file returned %+v, want nil", *info)
+	}
+}
+
+func testGetFileURLs(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	putFile(t, storage, bucket, "users/1/avatar", "avatar")
+
+	url, err := storage.GetFileURL(ctx, bucket, "users/1/avatar", urlExpires)
+	if err != nil {
+		t.Fatalf("GetFileURL: %v", err)
+	}
+
+	if url == "" {
+		t.Error("GetFileURL returned empty URL")
+	}
+
+	upload, err := storage.GetFileUpload(ctx, bucket, "users/1/uploads/1", contentType, maxUploadSize, urlExpires)
+	if err != nil {
+		t.Fatalf("GetFileUpload: %v", err)
+	}
+
+	if upload.URL == "" {
+		t.Error("GetFileUpload returned empty URL")
+	}
+}
+
+// testUploadConstraints uploads files by upload forms, so storage URLs must be reachable from tests.
+// Storage which serves its URLs itself must implement http.RoundTripper.
+func testUploadConstraints(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	client := http.DefaultClient
+	if transport, ok := storage.(http.RoundTripper); ok {
+		client = &http.Client{Transport: transport}
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		wantOK      bool
+	}{
+		{name: "valid", contentType: contentType, data: "picture", wantOK: true},
+		{name: "too large", contentType: contentType, data: strings.Repeat("a", maxUploadSize+1)},
+		{name: "empty", contentType: contentType, data: ""},
+		{name: "other content type", contentType: "text/html", data: "picture"},
+	}
+
+	for i, tt := range tests {
+		objectName := fmt.Sprintf("users/1/uploads/%d", i)
+		upload, err := storage.GetFileUpload(ctx, bucket, objectName, contentType, maxUploadSize, urlExpires)
+		if err != nil {
+			t.Fatalf("GetFileUpload: %v", err)
+		}
+
+		status := postUploadForm(t, client, upload, tt.contentType, tt.data)
+		if ok := status >= 200 && status < 300; ok != tt.wantOK {
+			t.Errorf("%s: upload returned status %d", tt.name, status)
+		}
+
+		info, err := storage.GetFileInfo(ctx, bucket, objectName)
+		if err != nil {
+			t.Fatalf("GetFileInfo: %v", err)
+		}
+
+		if !tt.wantOK {
+			if info != nil {
+				t.Errorf("%s: rejected upload is stored", tt.name)
+			}
+			continue
+		}
+
+		if info == nil || info.Size != int64(len(tt.data)) || info.ContentType != contentType {
+			t.Errorf("%s: GetFileInfo of uploaded file returned %+v", tt.name, info)
+		}
+	}
+}
+
+func testCopyFile(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	putFile(t, storage, bucket, "users/1/pictures/1", "picture")
+
+	if err := storage.CopyFile(ctx, bucket, "users/1/pictures/1", "users/1/avatar"); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+
+	// copy must not depend on source
+	if err := storage.DeleteFile(ctx, bucket, "users/1/pictures/1"); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+
+	data, err := storage.GetFile(ctx, bucket, "users/1/avatar")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+
+	if string(data) != "picture" {
+		t.Errorf("GetFile of copy returned %q, want %q", data, "picture")
+	}
+
+	info, err := storage.GetFileInfo(ctx, bucket, "users/1/avatar")
+	if err != nil {
+		t.Fatalf("GetFileInfo: %v", err)
+	}
+
+	if info == nil || info.ContentType != contentType {
+		t.Errorf("GetFileInfo of copy returned %+v, want content type %s", info, contentType)
+	}
+}
+
+func testCopyMissingFile(t *testing.T, storage repository.Storage, bucket string) {
+	if err := storage.CopyFile(context.Background(), bucket, "users/1/missing", "users/1/avatar"); err == nil {
+		t.Error("CopyFile of missing file returned no error")
+	}
+}
+
+func testDeleteFile(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	putFile(t, storage, bucket, "users/1/avatar", "avatar")
+
+	if err := storage.DeleteFile(ctx, bucket, "users/1/avatar"); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+
+	info, err := storage.GetFileInfo(ctx, bucket, "users/1/avatar")
+	if err != nil {
+		t.Fatalf("GetFileInfo: %v", err)
+	}
+
+	if info != nil {
+		t.Error("file exists after DeleteFile")
+	}
+
+	if err := storage.DeleteFile(ctx, bucket, "users/1/avatar"); err != nil {
+		t.Errorf("DeleteFile of missing file: %v", err)
+	}
+}
+
+func testListFiles(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	for _, name := range []string{
+		"users/2/avatar", "users/1/pictures/1_thumbnail", "users/1/pictures/1", "users/10/avatar",
+	} {
+		putFile(t, storage, bucket, name, name)
+	}
+
+	checkFileNames(t, storage, bucket, "users/1/", []string{"users/1/pictures/1", "users/1/pictures/1_thumbnail"})
+	checkFileNames(t, storage, bucket, "users/1", []string{
+		"users/1/pictures/1", "users/1/pictures/1_thumbnail", "users/10/avatar",
+	})
+	checkFileNames(t, storage, bucket, "", []string{
+		"users/1/pictures/1", "users/1/pictures/1_thumbnail", "users/10/avatar", "users/2/avatar",
+	})
+	checkFileNames(t, storage, bucket, "users/3/", nil)
+
+	objects, err := storage.ListFiles(ctx, bucket, "users/2/")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+
+	if len(objects) != 1 || objects[0].Size != int64(len("users/2/avatar")) || objects[0].LastModified.IsZero() {
+		t.Errorf("ListFiles returned %+v", objects)
+	}
+}
+
+func testDeleteFilesByPrefix(t *testing.T, storage repository.Storage, bucket string) {
+	ctx := context.Background()
+	for _, name := range []string{
+		"users/1/pictures/1", "users/1/pictures/1_thumbnail", "users/1/pictures/2", "users/10/avatar",
+	} {
+		putFile(t, storage, bucket, name, name)
+	}
+
+	if err := storage.DeleteFilesByPrefix(ctx, bucket, "users/1/pictures/1"); err != nil {
+		t.Fatalf("DeleteFilesByPrefix: %v", err)
+	}
+
+	checkFileNames(t, storage, bucket, "", []string{"users/1/pictures/2", "users/10/avatar"})
+
+	if err := storage.DeleteFilesByPrefix(ctx, bucket, "users/1/"); err != nil {
+		t.Fatalf("DeleteFilesByPrefix: %v", err)
+	}
+
+	checkFileNames(t, storage, bucket, "", []string{"users/10/avatar"})
+
+	if err := storage.DeleteFilesByPrefix(ctx, bucket, "users/3/"); err != nil {
+		t.Errorf("DeleteFilesByPrefix without files: %v", err)
+	}
+}
+
+// postUploadForm sends upload form like browser does: fields, then content type field set by client, then file.
+func postUploadForm(
+	t *testing.T, client *http.Client, upload *models.StorageUpload, fileContentType, data string,
+) (status int) {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range upload.Fields {
+		if name == "Content-Type" {
+			value = fileContentType
+		}
+
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatalf("WriteField: %v", err)
+		}
+	}
+
+	file, err := writer.CreateFormFile(models.StorageUploadFileField, "picture")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+
+	if _, err := file.Write([]byte(data)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	resp, err := client.Post(upload.URL, writer.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	return resp.StatusCode
+}
+
+func putFile(t *testing.T, storage repository.Storage, bucket, name, data string) {
+	t.Helper()
+
+	err := storage.PutFile(context.Background(), bucket, name, contentType, bytes.NewReader([]byte(data)))
+	if err != nil {
+		t.Fatalf("PutFile %s: %v", name, err)
+	}
+}
+
+func checkFileNames(t *testing.T, storage repository.Storage, bucket, prefix string, want []string) {
+	t.Helper()
+
+	objects, err := storage.ListFiles(context.Background(), bucket, prefix)
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+
+	names := fileNames(objects)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("ListFiles with prefix %q returned [%s], want [%s]",
+			prefix, strings.Join(names, ", "), strings.Join(want, ", "))
+	}
+}
+
+func fileNames(objects []models.StorageObject) []string {
+	var names []string
+	for i := range objects {
+		names = append(names, objects[i].Name)
+	}
+
+	return names
+}
@@ -0,0 +1,107 @@
+// Package uploadform reads presigned upload forms for storage backends which serve their URLs themselves.
+// Like S3 POST policy form, it is multipart form where fields precede file,
+// so constraints are checked before file is read.
+package uploadform
+
+import (
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/pkg/errors"
+)
+
+// maxFieldSize limits size of form field value.
+const maxFieldSize = 4 << 10
+
+var (
+	ErrNoFile   = errors.New("upload form has no file")
+	ErrTooLarge = errors.New("uploaded file is too large")
+	ErrEmpty    = errors.New("uploaded file is empty")
+)
+
+// Form is upload form which file is not read yet.
+type Form struct {
+	Fields map[string]string
+	// File fails with ErrTooLarge when it is longer than max size and with ErrEmpty when it is empty.
+	File io.Reader
+}
+
+// Read reads form fields until file and returns reader of file limited by maxSize.
+func Read(r *http.Request, maxSize int64) (*Form, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, ErrNoFile
+			}
+
+			return nil, err
+		}
+
+		if part.FormName() == models.StorageUploadFileField {
+			return &Form{
+				Fields: fields,
+				File:   &limitedReader{part: part, left: maxSize},
+			}, nil
+		}
+
+		value, err := readField(part)
+		if err != nil {
+			return nil, err
+		}
+
+		fields[part.FormName()] = value
+	}
+}
+
+func readField(part *multipart.Part) (string, error) {
+	value, err := ioutil.ReadAll(io.LimitReader(part, maxFieldSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(value) > maxFieldSize {
+		return "", errors.Errorf("upload form field %s is too large", part.FormName())
+	}
+
+	return string(value), nil
+}
+
+// limitedReader unlike io.LimitReader fails when limit is exceeded, so partially read file is not stored.
+type limitedReader struct {
+	part *multipart.Part
+	left int64
+	read bool
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// one byte more than left is requested to know if file is longer
+	if int64(len(p)) > r.left+1 {
+		p = p[:r.left+1]
+	}
+
+	n, err := r.part.Read(p)
+	if n > 0 {
+		r.read = true
+	}
+
+	if int64(n) > r.left {
+		return 0, ErrTooLarge
+	}
+	r.left -= int64(n)
+
+	if errors.Is(err, io.EOF) && !r.read {
+		return n, ErrEmpty
+	}
+
+	return n, err
+}
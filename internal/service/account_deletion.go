@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/config"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	AccountDeletionService struct {
+		cfg         config.AccountDeletion
+		log         *logrus.Entry
+		userDirPath string
+		bucket      string
+		repo        *repository.Repository
+	}
+	// AccountPurger periodically purges accounts which deletion grace period is over.
+	AccountPurger struct {
+		log      *logrus.Entry
+		svc      AccountDeletion
+		interval time.Duration
+		done     chan struct{}
+		wg       sync.WaitGroup
+	}
+)
+
+func NewAccountDeletionService(
+	cfg config.AccountDeletion, log *logrus.Entry,
+	userDirPath, bucket string, repo *repository.Repository,
+) *AccountDeletionService {
+	return &AccountDeletionService{
+		cfg:         cfg,
+		log:         log,
+		userDirPath: userDirPath,
+		bucket:      bucket,
+		repo:        repo,
+	}
+}
+
+// ScheduleAccountDeletion checks user password and schedules account deletion after grace period.
+// All user sessions are revoked, and signing in again reactivates account.
+func (s *AccountDeletionService) ScheduleAccountDeletion(
+	ctx context.Context, userID uint64, password string,
+) (deleteAt time.Time, err error) {
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if user == nil {
+		return time.Time{}, ierrors.NewBusiness(ErrUserNotFound, "")
+	}
+
+	if !models.CheckPasswordHash(user.Password, password) {
+		return time.Time{}, ierrors.NewBusiness(ErrWrongPassword, "")
+	}
+
+	deleteAt = time.Now().Add(s.cfg.GracePeriod.Duration())
+
+	if err := s.repo.User.ScheduleUserDeletion(ctx, userID, deleteAt); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := s.repo.SessionCache.DeleteUserSessions(strconv.FormatUint(userID, 10)); err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to revoke user sessions")
+	}
+
+	return deleteAt, nil
+}
+
+// PurgeAccount deletes all user data: sessions, database rows and files in storage.
+// Rows in other tables are deleted by cascade.
+func (s *AccountDeletionService) PurgeAccount(ctx context.Context, userID uint64) error {
+	_, err := s.purgeAccount(ctx, userID, func(ctx context.Context) (bool, error) {
+		return true, s.repo.User.DeleteUser(ctx, userID)
+	})
+
+	return err
+}
+
+// PurgeDeletedAccounts purges one batch of accounts which grace period is over.
+func (s *AccountDeletionService) PurgeDeletedAccounts(ctx context.Context) (int, error) {
+	now := time.Now()
+	userIDs, err := s.repo.User.GetUsersScheduledForDeletion(ctx, now, s.cfg.PurgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var purgedNum int
+	for _, userID := range userIDs {
+		// user could sign in and reactivate account after it was selected
+		purged, err := s.purgeAccount(ctx, userID, func(ctx context.Context) (bool, error) {
+			return s.repo.User.DeleteScheduledUser(ctx, userID, now)
+		})
+		if err != nil {
+			s.log.Errorf("failed to purge account %d: %v", userID, err)
+			continue
+		}
+
+		if purged {
+			purgedNum++
+		}
+	}
+
+	return purgedNum, nil
+}
+
+// purgeAccount deletes user row with deleteUser and adds storage outbox record of user files
+// in one transaction, so files are deleted by reconciler even if deleting them right away fails.
+// Messages are not stored yet, so there is nothing to anonymize. When they are, their anonymization
+// belongs to this transaction, as messages stay for other participants after user row is deleted.
+func (s *AccountDeletionService) purgeAccount(
+	ctx context.Context, userID uint64, deleteUser func(ctx context.Context) (bool, error),
+) (bool, error) {
+	userDirPath, err := prepareFilePath(s.userDirPath, map[string]interface{}{"UserID": userID})
+	if err != nil {
+		return false, err
+	}
+
+	// empty prefix would match files of all users
+	if userDirPath == "" {
+		return false, errors.New("empty user files prefix")
+	}
+
+	var deleted bool
+	if err := s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		deleted, err = deleteUser(ctx)
+		if err != nil || !deleted {
+			return err
+		}
+
+		return s.repo.StorageOutbox.AddStorageOutboxRecord(ctx, userDirPath)
+	}); err != nil {
+		return false, err
+	}
+
+	if !deleted {
+		return false, nil
+	}
+
+	if err := s.repo.SessionCache.DeleteUserSessions(strconv.FormatUint(userID, 10)); err != nil {
+		return true, errors.Wrap(err, "failed to revoke user sessions")
+	}
+
+	if err := s.repo.Storage.DeleteFilesByPrefix(ctx, s.bucket, userDirPath); err != nil {
+		s.log.Error(errors.Wrapf(err, "failed to delete user files %s, left for reconciler", userDirPath))
+		return true, nil
+	}
+
+	if err := s.repo.StorageOutbox.DeleteStorageOutboxRecord(ctx, userDirPath); err != nil {
+		s.log.Error(errors.Wrapf(err, "failed to delete storage outbox record %s", userDirPath))
+	}
+
+	return true, nil
+}
+
+func NewAccountPurger(log *logrus.Entry, svc AccountDeletion, interval time.Duration) *AccountPurger {
+	return &AccountPurger{
+		log:      log,
+		svc:      svc,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *AccountPurger) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				p.purge()
+			}
+		}
+	}()
+}
+
+func (p *AccountPurger) Shutdown() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+func (p *AccountPurger) purge() {
+	purgedNum, err := p.svc.PurgeDeletedAccounts(context.Background())
+	if err != nil {
+		p.log.Errorf("failed to purge deleted accounts: %v", err)
+		return
+	}
+
+	if purgedNum != 0 {
+		p.log.Infof("purged %d deleted accounts", purgedNum)
+	}
+}
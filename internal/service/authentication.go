@@ -2,7 +2,12 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/l-orlov/matcha/internal/config"
 	ierrors "github.com/l-orlov/matcha/internal/errors"
 	"github.com/l-orlov/matcha/internal/models"
@@ -11,53 +16,245 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var ErrBlockedByLimit = errors.New("user is blocked due to exceeding the error limit")
+var (
+	ErrBlockedByLimit         = errors.New("user is blocked due to exceeding the error limit")
+	ErrMagicLinkRequestsLimit = errors.New("too many sign in link requests for this email")
+	ErrLoginLocked            = errors.New("login is temporarily locked due to failed attempts")
+)
 
 type (
 	AuthenticationService struct {
-		cfg  *config.Config
-		log  *logrus.Entry
-		repo *repository.Repository
+		cfg       *config.Config
+		log       *logrus.Entry
+		repo      *repository.Repository
+		twoFactor TwoFactor
+		webAuthn  WebAuthn
 	}
 )
 
 func NewAuthenticationService(
 	cfg *config.Config, log *logrus.Entry, repo *repository.Repository,
+	twoFactor TwoFactor, webAuthn WebAuthn,
 ) *AuthenticationService {
 	return &AuthenticationService{
-		cfg:  cfg,
-		log:  log,
-		repo: repo,
+		cfg:       cfg,
+		log:       log,
+		repo:      repo,
+		twoFactor: twoFactor,
+		webAuthn:  webAuthn,
 	}
 }
 
 func (s *AuthenticationService) AuthenticateUserByUsername(
-	ctx context.Context, username, password, fingerprint string,
+	ctx context.Context, username, password, fingerprint, clientIP string,
 ) (userID uint64, err error) {
 	if err := s.checkUserBlocking(fingerprint); err != nil {
 		return 0, err
 	}
 
+	if err := s.checkLoginLock(ipLoginKey(clientIP)); err != nil {
+		return 0, err
+	}
+
 	user, err := s.repo.User.GetUserByUsername(ctx, username)
 	if err != nil {
 		return 0, err
 	}
 
 	if user == nil {
+		s.addLoginFailure(ipLoginKey(clientIP), s.cfg.LoginLockout.IPMaxFailures)
 		return 0, ierrors.NewBusiness(ErrUserNotFound, "")
 	}
 
+	if err := s.checkLoginLock(accountLoginKey(user.ID)); err != nil {
+		return 0, err
+	}
+
 	if err := s.checkUserPasswordHash(fingerprint, user.Password, password); err != nil {
+		s.addLoginFailure(accountLoginKey(user.ID), s.cfg.LoginLockout.AccountMaxFailures)
+		s.addLoginFailure(ipLoginKey(clientIP), s.cfg.LoginLockout.IPMaxFailures)
+		return 0, err
+	}
+
+	if err := s.repo.SessionCache.DeleteUserBlocking(fingerprint); err != nil {
+		s.log.Errorf("err while DeleteUserBlocking: %v", err)
+	}
+
+	if err := s.repo.SessionCache.DeleteLoginFailures(accountLoginKey(user.ID)); err != nil {
+		s.log.Errorf("err while DeleteLoginFailures: %v", err)
+	}
+
+	return user.ID, nil
+}
+
+// AuthenticateUserByTwoFactorCode completes sign in of user with enabled two-factor authentication.
+// Wrong codes count toward the same fingerprint blocking as wrong passwords. Attempts are also counted
+// per challenge, which is invalidated after max attempts, so codes can not be guessed with one password check.
+func (s *AuthenticationService) AuthenticateUserByTwoFactorCode(
+	ctx context.Context, challengeToken, code, fingerprint, clientIP string,
+) (userID uint64, err error) {
+	if err := s.checkUserBlocking(fingerprint); err != nil {
+		return 0, err
+	}
+
+	if err := s.checkLoginLock(ipLoginKey(clientIP)); err != nil {
+		return 0, err
+	}
+
+	userID, err = s.repo.VerificationCache.GetTwoFactorChallengeTokenData(challengeToken)
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return 0, ierrors.NewBusiness(ErrTwoFactorChallengeNotFound, "")
+		}
+
+		return 0, err
+	}
+
+	if err := s.checkLoginLock(accountLoginKey(userID)); err != nil {
+		return 0, err
+	}
+
+	// attempt is counted before code is checked, so concurrent attempts can not exceed limit
+	attempts, err := s.repo.VerificationCache.AddTwoFactorChallengeAttempt(challengeToken)
+	if err != nil {
+		return 0, err
+	}
+
+	if attempts > int64(s.cfg.TwoFactor.ChallengeMaxAttempts) {
+		s.deleteTwoFactorChallengeToken(challengeToken)
+		return 0, ierrors.NewBusiness(ErrTwoFactorChallengeNotFound, "")
+	}
+
+	if err := s.twoFactor.ValidateTwoFactorCode(ctx, userID, code); err != nil {
+		if errors.Is(err, ErrWrongTwoFactorCode) {
+			if _, err := s.repo.SessionCache.AddUserBlocking(fingerprint); err != nil {
+				s.log.Errorf("err while AddUserBlocking: %v", err)
+			}
+
+			s.addLoginFailure(accountLoginKey(userID), s.cfg.LoginLockout.AccountMaxFailures)
+			s.addLoginFailure(ipLoginKey(clientIP), s.cfg.LoginLockout.IPMaxFailures)
+
+			if attempts >= int64(s.cfg.TwoFactor.ChallengeMaxAttempts) {
+				s.deleteTwoFactorChallengeToken(challengeToken)
+			}
+		}
+
+		return 0, err
+	}
+
+	s.deleteTwoFactorChallengeToken(challengeToken)
+
+	if err := s.repo.SessionCache.DeleteUserBlocking(fingerprint); err != nil {
+		s.log.Errorf("err while DeleteUserBlocking: %v", err)
+	}
+
+	return userID, nil
+}
+
+// AuthenticateUserByPasskey completes passwordless sign in by passkey.
+func (s *AuthenticationService) AuthenticateUserByPasskey(
+	ctx context.Context, sessionID string, response io.Reader, fingerprint string,
+) (userID uint64, err error) {
+	if err := s.checkUserBlocking(fingerprint); err != nil {
+		return 0, err
+	}
+
+	userID, err = s.webAuthn.FinishPasskeyLogin(ctx, sessionID, response)
+	if err != nil {
+		s.addUserBlockingOnPasskeyError(fingerprint, err)
+		return 0, err
+	}
+
+	if err := s.repo.SessionCache.DeleteUserBlocking(fingerprint); err != nil {
+		s.log.Errorf("err while DeleteUserBlocking: %v", err)
+	}
+
+	return userID, nil
+}
+
+// AuthenticateUserByPasskeyTwoFactor completes sign in of user with passkey as second factor.
+func (s *AuthenticationService) AuthenticateUserByPasskeyTwoFactor(
+	ctx context.Context, challengeToken string, response io.Reader, fingerprint string,
+) (userID uint64, err error) {
+	if err := s.checkUserBlocking(fingerprint); err != nil {
+		return 0, err
+	}
+
+	challengeUserID, err := s.repo.VerificationCache.GetTwoFactorChallengeTokenData(challengeToken)
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return 0, ierrors.NewBusiness(ErrTwoFactorChallengeNotFound, "")
+		}
+
+		return 0, err
+	}
+
+	userID, err = s.webAuthn.FinishPasskeyTwoFactor(ctx, challengeToken, response)
+	if err != nil {
+		s.addUserBlockingOnPasskeyError(fingerprint, err)
 		return 0, err
 	}
 
+	if userID != challengeUserID {
+		return 0, ierrors.NewBusiness(ErrTwoFactorChallengeNotFound, "")
+	}
+
+	s.deleteTwoFactorChallengeToken(challengeToken)
+
 	if err := s.repo.SessionCache.DeleteUserBlocking(fingerprint); err != nil {
 		s.log.Errorf("err while DeleteUserBlocking: %v", err)
 	}
 
+	return userID, nil
+}
+
+// CheckMagicLinkRequest checks that sign in link can be sent to email and returns id of its user.
+// Requests are limited per email and by fingerprint blocking, so links can not be used to spam inboxes.
+// Link requests are counted separately from failed sign ins, so they do not block password sign in.
+// Unknown email is not an error: zero id is returned and link must not be sent,
+// so response does not reveal if email is registered.
+func (s *AuthenticationService) CheckMagicLinkRequest(
+	ctx context.Context, email, fingerprint string,
+) (userID uint64, err error) {
+	if err := s.checkUserBlocking(fingerprint); err != nil {
+		return 0, err
+	}
+
+	if err := s.checkUserBlocking(magicLinkBlockingKey(fingerprint)); err != nil {
+		return 0, err
+	}
+
+	if _, err := s.repo.SessionCache.AddUserBlocking(magicLinkBlockingKey(fingerprint)); err != nil {
+		s.log.Errorf("err while AddUserBlocking: %v", err)
+	}
+
+	count, err := s.repo.VerificationCache.AddMagicLinkRequest(email)
+	if err != nil {
+		return 0, err
+	}
+
+	if count > int64(s.cfg.MagicLinkLimit.MaxRequests) {
+		return 0, ierrors.NewBusiness(ErrMagicLinkRequestsLimit, "")
+	}
+
+	user, err := s.repo.User.GetUserByEmail(ctx, email)
+	if err != nil {
+		return 0, err
+	}
+
+	if user == nil {
+		return 0, nil
+	}
+
 	return user.ID, nil
 }
 
+func (s *AuthenticationService) deleteTwoFactorChallengeToken(token string) {
+	if err := s.repo.VerificationCache.DeleteTwoFactorChallengeToken(token); err != nil {
+		s.log.Errorf("err while DeleteTwoFactorChallengeToken: %v", err)
+	}
+}
+
 func (s *AuthenticationService) checkUserBlocking(fingerprint string) error {
 	count, err := s.repo.SessionCache.GetUserBlocking(fingerprint)
 	if err != nil {
@@ -71,6 +268,69 @@ func (s *AuthenticationService) checkUserBlocking(fingerprint string) error {
 	return nil
 }
 
+// checkLoginLock returns error if login by key is locked after failed attempts.
+func (s *AuthenticationService) checkLoginLock(key string) error {
+	ttl, err := s.repo.SessionCache.GetLoginLockTTL(key)
+	if err != nil {
+		s.log.Errorf("err while GetLoginLockTTL: %v", err)
+	}
+
+	if ttl > 0 {
+		return ierrors.NewBusiness(ErrLoginLocked, fmt.Sprintf("try again in %s", ttl.Round(time.Second)))
+	}
+
+	return nil
+}
+
+// addLoginFailure counts failed login by key and locks login when maxFailures is reached.
+// Every next failure doubles lock duration.
+func (s *AuthenticationService) addLoginFailure(key string, maxFailures int) {
+	if maxFailures <= 0 {
+		return
+	}
+
+	count, err := s.repo.SessionCache.AddLoginFailure(key)
+	if err != nil {
+		s.log.Errorf("err while AddLoginFailure: %v", err)
+		return
+	}
+
+	if count < int64(maxFailures) {
+		return
+	}
+
+	if err := s.repo.SessionCache.PutLoginLock(key, s.loginLockout(count-int64(maxFailures))); err != nil {
+		s.log.Errorf("err while PutLoginLock: %v", err)
+	}
+}
+
+func (s *AuthenticationService) loginLockout(exceededNum int64) time.Duration {
+	lockout := s.cfg.LoginLockout.BaseLockout.Duration()
+	maxLockout := s.cfg.LoginLockout.MaxLockout.Duration()
+
+	for i := int64(0); i < exceededNum && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+
+	if lockout > maxLockout {
+		return maxLockout
+	}
+
+	return lockout
+}
+
+func accountLoginKey(userID uint64) string {
+	return "u:" + strconv.FormatUint(userID, 10)
+}
+
+func ipLoginKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+func magicLinkBlockingKey(fingerprint string) string {
+	return "ml:" + fingerprint
+}
+
 func (s *AuthenticationService) checkUserPasswordHash(fingerprint, hash, password string) error {
 	if !models.CheckPasswordHash(hash, password) {
 		if _, err := s.repo.SessionCache.AddUserBlocking(fingerprint); err != nil {
@@ -82,3 +342,13 @@ func (s *AuthenticationService) checkUserPasswordHash(fingerprint, hash, passwor
 
 	return nil
 }
+
+func (s *AuthenticationService) addUserBlockingOnPasskeyError(fingerprint string, err error) {
+	if !errors.Is(err, ErrPasskeyVerificationFailed) && !errors.Is(err, ErrPasskeyCloneWarning) {
+		return
+	}
+
+	if _, err := s.repo.SessionCache.AddUserBlocking(fingerprint); err != nil {
+		s.log.Errorf("err while AddUserBlocking: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestAuthenticationService(t *testing.T, cfg *config.Config) (*AuthenticationService, uint64) {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	repo := newFakeRepository()
+	userID, err := repo.User.CreateUser(context.Background(), models.UserToCreate{
+		Email:    "alice@example.com",
+		Username: "alice",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewAuthenticationService(cfg, logrus.NewEntry(log), repo, nil, nil), userID
+}
+
+func TestMagicLinkRequestOfBlockedFingerprint(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestAuthenticationService(t, &config.Config{
+		UserBlocking:   config.UserBlocking{MaxErrors: 2},
+		MagicLinkLimit: config.MagicLinkLimit{MaxRequests: 10},
+	})
+
+	// fingerprint is blocked by failed sign ins
+	for i := 0; i < 2; i++ {
+		if _, err := svc.repo.SessionCache.AddUserBlocking("fingerprint"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := svc.CheckMagicLinkRequest(ctx, "alice@example.com", "fingerprint"); !errors.Is(err, ErrBlockedByLimit) {
+		t.Errorf("CheckMagicLinkRequest of blocked fingerprint returned %v, want %v", err, ErrBlockedByLimit)
+	}
+}
+
+func TestMagicLinkRequestsBlockFingerprint(t *testing.T) {
+	ctx := context.Background()
+	svc, userID := newTestAuthenticationService(t, &config.Config{
+		UserBlocking:   config.UserBlocking{MaxErrors: 2},
+		MagicLinkLimit: config.MagicLinkLimit{MaxRequests: 10},
+	})
+
+	// emails differ, so only fingerprint blocking limits requests
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		if _, err := svc.CheckMagicLinkRequest(ctx, email, "fingerprint"); err != nil {
+			t.Fatalf("CheckMagicLinkRequest of %s: %v", email, err)
+		}
+	}
+
+	_, err := svc.CheckMagicLinkRequest(ctx, "carol@example.com", "fingerprint")
+	if !errors.Is(err, ErrBlockedByLimit) {
+		t.Errorf("CheckMagicLinkRequest over fingerprint limit returned %v, want %v", err, ErrBlockedByLimit)
+	}
+
+	// link requests are counted apart from failed sign ins
+	if count, _ := svc.repo.SessionCache.GetUserBlocking("fingerprint"); count != 0 {
+		t.Errorf("link requests counted %d failed sign ins of fingerprint", count)
+	}
+
+	gotID, err := svc.CheckMagicLinkRequest(ctx, "alice@example.com", "other fingerprint")
+	if err != nil || gotID != userID {
+		t.Errorf("CheckMagicLinkRequest from other fingerprint returned %d, %v, want %d", gotID, err, userID)
+	}
+}
+
+func TestMagicLinkRequestLimitPerEmail(t *testing.T) {
+	ctx := context.Background()
+	svc, userID := newTestAuthenticationService(t, &config.Config{
+		UserBlocking:   config.UserBlocking{MaxErrors: 100},
+		MagicLinkLimit: config.MagicLinkLimit{MaxRequests: 2},
+	})
+
+	for i := 0; i < 2; i++ {
+		gotID, err := svc.CheckMagicLinkRequest(ctx, "alice@example.com", "fingerprint")
+		if err != nil || gotID != userID {
+			t.Fatalf("CheckMagicLinkRequest of registered email returned %d, %v, want %d", gotID, err, userID)
+		}
+
+		// unknown email looks like success, link is not sent for zero id
+		gotID, err = svc.CheckMagicLinkRequest(ctx, "bob@example.com", "fingerprint")
+		if err != nil || gotID != 0 {
+			t.Fatalf("CheckMagicLinkRequest of unknown email returned %d, %v, want 0 and no error", gotID, err)
+		}
+	}
+
+	// limit is the same for registered and unknown emails
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		_, err := svc.CheckMagicLinkRequest(ctx, email, "fingerprint")
+		if !errors.Is(err, ErrMagicLinkRequestsLimit) {
+			t.Errorf("CheckMagicLinkRequest of %s over limit returned %v, want %v", email, err, ErrMagicLinkRequestsLimit)
+		}
+	}
+}
@@ -1,66 +1,58 @@
 package service
 
 import (
+	"context"
+	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/gomodule/redigo/redis"
 	"github.com/google/uuid"
 	"github.com/l-orlov/matcha/internal/config"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
 	"github.com/l-orlov/matcha/internal/models"
 	"github.com/l-orlov/matcha/internal/repository"
 	"github.com/pkg/errors"
 )
 
 var (
-	ErrNotActiveAccessToken = errors.New("not active accessToken")
-	ErrSessionNotFound      = errors.New("session not found")
+	ErrNotActiveAccessToken  = errors.New("not active accessToken")
+	ErrAccessTokenExpired    = errors.New("accessToken is expired")
+	ErrNotValidTokenIssuer   = errors.New("not valid token issuer")
+	ErrNotValidTokenAudience = errors.New("not valid token audience")
+	ErrSessionNotFound       = errors.New("session not found")
 )
 
 type (
 	AuthorizationService struct {
-		cfg  *config.Config
-		repo repository.SessionCache
+		cfg      *config.Config
+		repo     repository.SessionCache
+		userRepo repository.User
 	}
 )
 
 func NewAuthorizationService(cfg *config.Config, repo *repository.Repository) *AuthorizationService {
 	return &AuthorizationService{
-		cfg:  cfg,
-		repo: repo,
+		cfg:      cfg,
+		repo:     repo,
+		userRepo: repo.User,
 	}
 }
 
-func (s *AuthorizationService) CreateSession(userID string) (accessToken, refreshToken string, err error) {
-	accessTokenID := uuid.New().String()
-	accessToken, err = newToken(
-		userID, accessTokenID, s.cfg.JWT.SigningKey, s.cfg.JWT.AccessTokenLifetime.Duration(),
-	)
-	if err != nil {
-		return "", "", err
-	}
-
-	refreshToken = uuid.New().String()
-
-	err = s.repo.PutSessionAndAccessToken(models.Session{
-		UserID:        userID,
-		AccessTokenID: accessTokenID,
-	}, refreshToken)
-	if err != nil {
-		return "", "", err
-	}
-
-	return accessToken, refreshToken, nil
+func (s *AuthorizationService) CreateSession(
+	ctx context.Context, userID uint64,
+) (accessToken, refreshToken string, err error) {
+	return s.createSession(ctx, userID, uuid.New().String())
 }
 
-func (s *AuthorizationService) ValidateAccessToken(accessToken string) (*jwt.StandardClaims, error) {
-	accessTokenClaims, err := validateToken(accessToken, s.cfg.JWT.SigningKey)
+func (s *AuthorizationService) ValidateAccessToken(accessToken string) (*models.AccessTokenClaims, error) {
+	accessTokenClaims, err := validateToken(accessToken, s.cfg.JWT)
 	if err != nil {
 		return nil, err
 	}
 
 	// check accessToken is active
-	if _, err := s.repo.GetAccessTokenData(accessTokenClaims.Id); err != nil {
+	if _, err := s.repo.GetAccessTokenData(accessTokenClaims.ID); err != nil {
 		if errors.Is(err, redis.ErrNil) {
 			return nil, ErrNotActiveAccessToken
 		}
@@ -72,7 +64,7 @@ func (s *AuthorizationService) ValidateAccessToken(accessToken string) (*jwt.Sta
 }
 
 func (s *AuthorizationService) RefreshSession(
-	currentRefreshToken string,
+	ctx context.Context, currentRefreshToken string,
 ) (accessToken, refreshToken string, err error) {
 	session, err := s.repo.GetSession(currentRefreshToken)
 	if err != nil {
@@ -83,6 +75,11 @@ func (s *AuthorizationService) RefreshSession(
 		return "", "", err
 	}
 
+	userID, err := strconv.ParseUint(session.UserID, 10, 64)
+	if err != nil {
+		return "", "", err
+	}
+
 	if err = s.repo.DeleteSession(currentRefreshToken); err != nil {
 		return "", "", err
 	}
@@ -95,16 +92,22 @@ func (s *AuthorizationService) RefreshSession(
 		return "", "", err
 	}
 
-	return s.CreateSession(session.UserID)
+	// sessions created before session ids were introduced get a new one
+	sessionID := session.ID
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+
+	return s.createSession(ctx, userID, sessionID)
 }
 
 func (s *AuthorizationService) RevokeSession(accessToken string) error {
-	accessTokenClaims, err := validateToken(accessToken, s.cfg.JWT.SigningKey)
+	accessTokenClaims, err := validateToken(accessToken, s.cfg.JWT)
 	if err != nil {
 		return err
 	}
 
-	refreshToken, err := s.repo.GetAccessTokenData(accessTokenClaims.Id)
+	refreshToken, err := s.repo.GetAccessTokenData(accessTokenClaims.ID)
 	if err != nil {
 		if errors.Is(err, redis.ErrNil) {
 			return ErrNotActiveAccessToken
@@ -113,7 +116,7 @@ func (s *AuthorizationService) RevokeSession(accessToken string) error {
 		return err
 	}
 
-	if err := s.repo.DeleteAccessToken(accessTokenClaims.Id); err != nil {
+	if err := s.repo.DeleteAccessToken(accessTokenClaims.ID); err != nil {
 		return err
 	}
 
@@ -131,24 +134,71 @@ func (s *AuthorizationService) RevokeSession(accessToken string) error {
 	return nil
 }
 
-func (s *AuthorizationService) GetAccessTokenClaims(accessToken string) (*jwt.StandardClaims, error) {
-	return getTokenClaims(accessToken, s.cfg.JWT.SigningKey)
+func (s *AuthorizationService) GetAccessTokenClaims(accessToken string) (*models.AccessTokenClaims, error) {
+	return getTokenClaims(accessToken, s.cfg.JWT)
 }
 
-func newToken(userID, tokenID string, signingKey []byte, lifetime time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
-		Id:        tokenID,
-		NotBefore: time.Now().Unix(),
-		ExpiresAt: time.Now().Add(lifetime).Unix(),
-		IssuedAt:  time.Now().Unix(),
-		Subject:   userID,
+func (s *AuthorizationService) createSession(
+	ctx context.Context, userID uint64, sessionID string,
+) (accessToken, refreshToken string, err error) {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if user == nil {
+		return "", "", ierrors.NewBusiness(ErrUserNotFound, "")
+	}
+
+	accessTokenID := uuid.New().String()
+	accessToken, err = newToken(user, sessionID, accessTokenID, s.cfg.JWT)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken = uuid.New().String()
+
+	err = s.repo.PutSessionAndAccessToken(models.Session{
+		ID:            sessionID,
+		UserID:        strconv.FormatUint(userID, 10),
+		AccessTokenID: accessTokenID,
+	}, refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func newToken(user *models.User, sessionID, tokenID string, cfg config.JWT) (string, error) {
+	now := time.Now()
+
+	roles := []string(user.Roles)
+	if len(roles) == 0 {
+		roles = []string{models.RoleUser}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &models.AccessTokenClaims{
+		UserID:           user.ID,
+		Roles:            roles,
+		SessionID:        sessionID,
+		IsEmailConfirmed: user.IsEmailConfirmed,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    cfg.Issuer,
+			Audience:  jwt.ClaimStrings{cfg.Audience},
+			Subject:   strconv.FormatUint(user.ID, 10),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cfg.AccessTokenLifetime.Duration())),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
 	})
 
-	return token.SignedString(signingKey)
+	return token.SignedString([]byte(cfg.SigningKey))
 }
 
-func validateToken(token string, signingKey []byte) (*jwt.StandardClaims, error) {
-	claims, err := getTokenClaims(token, signingKey)
+func validateToken(token string, cfg config.JWT) (*models.AccessTokenClaims, error) {
+	claims, err := getTokenClaims(token, cfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "not valid token")
 	}
@@ -156,17 +206,30 @@ func validateToken(token string, signingKey []byte) (*jwt.StandardClaims, error)
 	return claims, nil
 }
 
-func getTokenClaims(tokenString string, signingKey []byte) (*jwt.StandardClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+func getTokenClaims(tokenString string, cfg config.JWT) (*models.AccessTokenClaims, error) {
+	claims := &models.AccessTokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return signingKey, nil
+		return []byte(cfg.SigningKey), nil
 	})
 	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrAccessTokenExpired
+		}
+
 		return nil, err
 	}
 
-	return token.Claims.(*jwt.StandardClaims), nil
+	if !claims.VerifyIssuer(cfg.Issuer, true) {
+		return nil, ErrNotValidTokenIssuer
+	}
+
+	if !claims.VerifyAudience(cfg.Audience, true) {
+		return nil, ErrNotValidTokenAudience
+	}
+
+	return claims, nil
 }
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/config"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dataExportPopTimeout   = 5 * time.Second
+	dataExportContentType  = "application/zip"
+	dataExportFilesDirName = "files/"
+)
+
+var ErrDataExportAlreadyRequested = errors.New("data export is already requested, try again later")
+
+type (
+	DataExportService struct {
+		cfg           config.DataExport
+		log           *logrus.Entry
+		pathTemplates config.FilePathTemplates
+		bucket        string
+		repo          *repository.Repository
+		mailer        Mailer
+	}
+	// DataExportWorker builds requested data exports in background.
+	DataExportWorker struct {
+		log        *logrus.Entry
+		svc        DataExport
+		workersNum int
+		done       chan struct{}
+		wg         sync.WaitGroup
+	}
+)
+
+func NewDataExportService(
+	cfg config.DataExport, log *logrus.Entry, pathTemplates config.FilePathTemplates,
+	bucket string, repo *repository.Repository, mailer Mailer,
+) *DataExportService {
+	return &DataExportService{
+		cfg:           cfg,
+		log:           log,
+		pathTemplates: pathTemplates,
+		bucket:        bucket,
+		repo:          repo,
+		mailer:        mailer,
+	}
+}
+
+// RequestDataExport queues export of user personal data. Download link is sent by email when it is ready.
+func (s *DataExportService) RequestDataExport(ctx context.Context, userID uint64) error {
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return ierrors.NewBusiness(ErrUserNotFound, "")
+	}
+
+	ok, err := s.repo.DataExportCache.AddDataExportRequest(userID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ierrors.NewBusiness(ErrDataExportAlreadyRequested, "")
+	}
+
+	return nil
+}
+
+// ProcessDataExportRequest builds export for the next request from queue.
+// It returns false if there was no request during waiting.
+func (s *DataExportService) ProcessDataExportRequest(ctx context.Context) (bool, error) {
+	userID, ok, err := s.repo.DataExportCache.PopDataExportRequest(dataExportPopTimeout)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	if err := s.exportUserData(ctx, userID); err != nil {
+		// request is marked before it is queued, mark is deleted to let user request export again
+		if err := s.repo.DataExportCache.DeleteDataExportRequest(userID); err != nil {
+			s.log.Errorf("err while DeleteDataExportRequest: %v", err)
+		}
+
+		return true, errors.Wrapf(err, "failed to export data of user %d", userID)
+	}
+
+	return true, nil
+}
+
+func (s *DataExportService) exportUserData(ctx context.Context, userID uint64) error {
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// user could be deleted while request was in queue
+	if user == nil {
+		return nil
+	}
+
+	archive, err := s.buildDataExportArchive(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	path, err := prepareFilePath(s.pathTemplates.UserDataExport, map[string]interface{}{"UserID": userID})
+	if err != nil {
+		return err
+	}
+
+	// outbox record makes reconciler delete archive after grace period which is not less than link lifetime,
+	// record of previous export is replaced to not delete new archive earlier
+	if err := s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.StorageOutbox.DeleteStorageOutboxRecord(ctx, path); err != nil {
+			return err
+		}
+
+		return s.repo.StorageOutbox.AddStorageOutboxRecord(ctx, path)
+	}); err != nil {
+		return err
+	}
+
+	if err := s.repo.Storage.PutFile(
+		ctx, s.bucket, path, dataExportContentType, bytes.NewReader(archive),
+	); err != nil {
+		return err
+	}
+
+	url, err := s.repo.Storage.GetFileURL(ctx, s.bucket, path, s.cfg.URLLifetime.Duration())
+	if err != nil {
+		return err
+	}
+
+	s.mailer.SendDataExport(user.Email, url)
+
+	return nil
+}
+
+// buildDataExportArchive collects all data stored about user to ZIP with JSON files and original pictures.
+func (s *DataExportService) buildDataExportArchive(ctx context.Context, userID uint64) ([]byte, error) {
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor, err := s.repo.UserTwoFactor.GetUserTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := s.repo.UserIdentity.GetUserIdentitiesByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := s.repo.UserWebAuthn.GetWebAuthnCredentialsByUserID(ctx, userID, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := s.repo.User.GetUserProfileByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pictures, err := s.repo.UserPictures.GetUserPicturesByUserID(ctx, userID, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	loginDevices, err := s.repo.UserLoginDevice.GetUserLoginDevices(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.repo.SessionCache.GetUserSessions(strconv.FormatUint(userID, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	// likes and visits are stored only as counters of profile, messages and notifications are not stored yet,
+	// so archive has no files of them
+	files := map[string]interface{}{
+		"account.json": map[string]interface{}{
+			"user":               user,
+			"isTwoFactorEnabled": twoFactor != nil && twoFactor.IsEnabled,
+			"identities":         identities,
+			"passkeys":           passkeys,
+		},
+		"profile.json":       profile,
+		"pictures.json":      pictures,
+		"login_devices.json": loginDevices,
+		"sessions.json":      sessions,
+	}
+	for name, data := range files {
+		if err := writeJSONToArchive(archive, name, data); err != nil {
+			return nil, err
+		}
+	}
+
+	var picturePaths []string
+	if profile != nil && profile.AvatarPath != "" {
+		picturePaths = append(picturePaths, profile.AvatarPath)
+	}
+	for _, picture := range pictures {
+		picturePaths = append(picturePaths, picture.PicturePath)
+	}
+
+	for _, path := range picturePaths {
+		if err := s.copyFileToArchive(ctx, archive, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *DataExportService) copyFileToArchive(ctx context.Context, archive *zip.Writer, path string) error {
+	data, err := s.repo.Storage.GetFile(ctx, s.bucket, path)
+	if err != nil {
+		return err
+	}
+
+	w, err := archive.Create(dataExportFilesDirName + path)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+func writeJSONToArchive(archive *zip.Writer, name string, data interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(data)
+}
+
+func NewDataExportWorker(log *logrus.Entry, svc DataExport, workersNum int) *DataExportWorker {
+	return &DataExportWorker{
+		log:        log,
+		svc:        svc,
+		workersNum: workersNum,
+		done:       make(chan struct{}),
+	}
+}
+
+func (w *DataExportWorker) Start() {
+	for i := 0; i < w.workersNum; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+
+			for {
+				select {
+				case <-w.done:
+					return
+				default:
+				}
+
+				if _, err := w.svc.ProcessDataExportRequest(context.Background()); err != nil {
+					w.log.Error(err)
+					w.wait(dataExportPopTimeout)
+				}
+			}
+		}()
+	}
+}
+
+func (w *DataExportWorker) Shutdown() {
+	close(w.done)
+	w.wg.Wait()
+}
+
+// wait pauses worker after error, so it does not spin when redis is not available.
+func (w *DataExportWorker) wait(d time.Duration) {
+	select {
+	case <-w.done:
+	case <-time.After(d):
+	}
+}
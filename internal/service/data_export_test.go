@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	fakeDataExportCache struct {
+		mu        sync.Mutex
+		requested map[uint64]bool
+		queue     []uint64
+	}
+	failingUserTwoFactor struct {
+		repository.UserTwoFactor
+	}
+)
+
+var errTestStorage = errors.New("test storage error")
+
+func (c *fakeDataExportCache) AddDataExportRequest(userID uint64) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.requested[userID] {
+		return false, nil
+	}
+	c.requested[userID] = true
+	c.queue = append(c.queue, userID)
+
+	return true, nil
+}
+
+func (c *fakeDataExportCache) DeleteDataExportRequest(userID uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.requested, userID)
+
+	return nil
+}
+
+func (c *fakeDataExportCache) PopDataExportRequest(time.Duration) (uint64, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.queue) == 0 {
+		return 0, false, nil
+	}
+	userID := c.queue[0]
+	c.queue = c.queue[1:]
+
+	return userID, true, nil
+}
+
+func (f failingUserTwoFactor) GetUserTwoFactor(context.Context, uint64) (*models.UserTwoFactor, error) {
+	return nil, errTestStorage
+}
+
+func TestFailedDataExportCanBeRequestedAgain(t *testing.T) {
+	ctx := context.Background()
+
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	repo := newFakeRepository()
+	repo.DataExportCache = &fakeDataExportCache{requested: make(map[uint64]bool)}
+	repo.UserTwoFactor = failingUserTwoFactor{}
+
+	userID, err := repo.User.CreateUser(ctx, models.UserToCreate{Email: "alice@example.com", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewDataExportService(config.DataExport{}, logrus.NewEntry(log), config.FilePathTemplates{
+		UserDataExport: "users/{{ .UserID }}/export/data.zip",
+	}, "test", repo, nil)
+
+	if err := svc.RequestDataExport(ctx, userID); err != nil {
+		t.Fatalf("RequestDataExport: %v", err)
+	}
+
+	if err := svc.RequestDataExport(ctx, userID); !errors.Is(err, ErrDataExportAlreadyRequested) {
+		t.Fatalf("repeated RequestDataExport returned %v, want %v", err, ErrDataExportAlreadyRequested)
+	}
+
+	if _, err := svc.ProcessDataExportRequest(ctx); !errors.Is(err, errTestStorage) {
+		t.Fatalf("ProcessDataExportRequest returned %v, want %v", err, errTestStorage)
+	}
+
+	if err := svc.RequestDataExport(ctx, userID); err != nil {
+		t.Errorf("RequestDataExport after failed export: %v", err)
+	}
+}
@@ -0,0 +1,419 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+)
+
+// Fakes keep data in memory and implement only methods used by tested services,
+// the rest of interface methods panic on nil embedded interface.
+type (
+	fakeTxManager struct {
+		txNum int
+	}
+	fakeUsers struct {
+		repository.User
+		mu     sync.Mutex
+		users  map[uint64]*models.User
+		nextID uint64
+	}
+	fakeUserIdentities struct {
+		repository.UserIdentity
+		mu         sync.Mutex
+		identities []models.UserIdentity
+	}
+	fakeUserWebAuthn struct {
+		repository.UserWebAuthn
+		mu          sync.Mutex
+		credentials []models.WebAuthnCredential
+	}
+	// fakeSessionCache counts fingerprint blocking, login failures and locks are not kept.
+	fakeSessionCache struct {
+		repository.SessionCache
+		mu       sync.Mutex
+		blocking map[string]int
+		// revokedUsers are users whose sessions are deleted
+		revokedUsers []string
+	}
+	fakeVerificationCache struct {
+		repository.VerificationCache
+		mu               sync.Mutex
+		oauthStates      map[string]models.OAuthState
+		webAuthnSessions map[string]models.WebAuthnSession
+		challengeTokens  map[string]uint64
+		magicLinkCounts  map[string]int64
+		loginAlertTokens map[string]uint64
+		// challengeAttempts are attempts to complete two-factor challenges by token
+		challengeAttempts map[string]int64
+	}
+)
+
+func newFakeRepository() *repository.Repository {
+	return &repository.Repository{
+		TxManager:    &fakeTxManager{},
+		User:         &fakeUsers{users: make(map[uint64]*models.User)},
+		UserIdentity: &fakeUserIdentities{},
+		UserWebAuthn: &fakeUserWebAuthn{},
+		SessionCache: &fakeSessionCache{blocking: make(map[string]int)},
+		VerificationCache: &fakeVerificationCache{
+			oauthStates:       make(map[string]models.OAuthState),
+			webAuthnSessions:  make(map[string]models.WebAuthnSession),
+			challengeTokens:   make(map[string]uint64),
+			magicLinkCounts:   make(map[string]int64),
+			loginAlertTokens:  make(map[string]uint64),
+			challengeAttempts: make(map[string]int64),
+		},
+	}
+}
+
+func (m *fakeTxManager) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	m.txNum++
+	return fn(ctx)
+}
+
+func (r *fakeUsers) CreateUser(ctx context.Context, user models.UserToCreate) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	r.users[r.nextID] = &models.User{
+		ID:        r.nextID,
+		Email:     user.Email,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+
+	return r.nextID, nil
+}
+
+func (r *fakeUsers) GetUserByID(ctx context.Context, id uint64) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, nil
+	}
+	userCopy := *user
+
+	return &userCopy, nil
+}
+
+func (r *fakeUsers) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.find(func(user *models.User) bool { return user.Username == username }), nil
+}
+
+func (r *fakeUsers) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.find(func(user *models.User) bool { return user.Email == email }), nil
+}
+
+func (r *fakeUsers) ConfirmEmail(ctx context.Context, id uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[id]; ok {
+		user.IsEmailConfirmed = true
+	}
+
+	return nil
+}
+
+func (r *fakeUsers) UpdateUserPassword(ctx context.Context, userID uint64, password string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[userID]; ok {
+		user.Password = password
+	}
+
+	return nil
+}
+
+func (r *fakeUsers) find(match func(user *models.User) bool) *models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if match(user) {
+			userCopy := *user
+			return &userCopy
+		}
+	}
+
+	return nil
+}
+
+func (r *fakeUserIdentities) CreateUserIdentity(ctx context.Context, identity models.UserIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.identities = append(r.identities, identity)
+
+	return nil
+}
+
+func (r *fakeUserIdentities) GetUserIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			identityCopy := identity
+			return &identityCopy, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *fakeUserIdentities) DeleteUserIdentitiesByUserID(ctx context.Context, userID uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var identities []models.UserIdentity
+	for _, identity := range r.identities {
+		if identity.UserID != userID {
+			identities = append(identities, identity)
+		}
+	}
+	r.identities = identities
+
+	return nil
+}
+
+func (r *fakeUserWebAuthn) CreateWebAuthnCredential(ctx context.Context, credential models.WebAuthnCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.credentials = append(r.credentials, credential)
+
+	return nil
+}
+
+func (r *fakeUserWebAuthn) GetWebAuthnCredentialsByUserID(
+	ctx context.Context, userID uint64, after *models.WebAuthnCredentialKey, limit int,
+) ([]models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var credentials []models.WebAuthnCredential
+	for _, credential := range r.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+
+	return credentials, nil
+}
+
+func (r *fakeUserWebAuthn) UpdateWebAuthnCredentialUsage(
+	ctx context.Context, id []byte, signCount uint32, flags byte,
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.credentials {
+		if string(r.credentials[i].ID) == string(id) {
+			r.credentials[i].SignCount = signCount
+			r.credentials[i].Flags = int16(flags)
+		}
+	}
+
+	return nil
+}
+
+func (r *fakeUserWebAuthn) DeleteWebAuthnCredentialsByUserID(ctx context.Context, userID uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var credentials []models.WebAuthnCredential
+	for _, credential := range r.credentials {
+		if credential.UserID != userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	r.credentials = credentials
+
+	return nil
+}
+
+func (c *fakeSessionCache) GetUserBlocking(fingerprint string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.blocking[fingerprint], nil
+}
+
+func (c *fakeSessionCache) AddUserBlocking(fingerprint string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blocking[fingerprint]++
+
+	return int64(c.blocking[fingerprint]), nil
+}
+
+func (c *fakeSessionCache) DeleteUserBlocking(fingerprint string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.blocking, fingerprint)
+
+	return nil
+}
+
+func (c *fakeSessionCache) DeleteUserSessions(userID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.revokedUsers = append(c.revokedUsers, userID)
+
+	return nil
+}
+
+func (c *fakeSessionCache) AddLoginFailure(string) (int64, error)         { return 1, nil }
+func (c *fakeSessionCache) DeleteLoginFailures(string) error              { return nil }
+func (c *fakeSessionCache) PutLoginLock(string, time.Duration) error      { return nil }
+func (c *fakeSessionCache) GetLoginLockTTL(string) (time.Duration, error) { return 0, nil }
+
+func (c *fakeVerificationCache) PutOAuthState(state string, data models.OAuthState) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.oauthStates[state] = data
+
+	return nil
+}
+
+func (c *fakeVerificationCache) GetOAuthState(state string) (*models.OAuthState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, ok := c.oauthStates[state]
+	if !ok {
+		return nil, redis.ErrNil
+	}
+
+	return &data, nil
+}
+
+func (c *fakeVerificationCache) DeleteOAuthState(state string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.oauthStates, state)
+
+	return nil
+}
+
+func (c *fakeVerificationCache) GetTwoFactorChallengeTokenData(token string) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	userID, ok := c.challengeTokens[token]
+	if !ok {
+		return 0, redis.ErrNil
+	}
+
+	return userID, nil
+}
+
+func (c *fakeVerificationCache) putChallengeToken(token string, userID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.challengeTokens[token] = userID
+}
+
+func (c *fakeVerificationCache) AddTwoFactorChallengeAttempt(token string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.challengeAttempts[token]++
+
+	return c.challengeAttempts[token], nil
+}
+
+func (c *fakeVerificationCache) DeleteTwoFactorChallengeToken(token string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.challengeTokens, token)
+
+	return nil
+}
+
+func (c *fakeVerificationCache) PutWebAuthnSession(key string, session models.WebAuthnSession) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.webAuthnSessions[key] = session
+
+	return nil
+}
+
+func (c *fakeVerificationCache) GetWebAuthnSession(key string) (*models.WebAuthnSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session, ok := c.webAuthnSessions[key]
+	if !ok {
+		return nil, redis.ErrNil
+	}
+
+	return &session, nil
+}
+
+func (c *fakeVerificationCache) DeleteWebAuthnSession(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.webAuthnSessions, key)
+
+	return nil
+}
+
+func (c *fakeVerificationCache) AddMagicLinkRequest(email string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.magicLinkCounts[email]++
+
+	return c.magicLinkCounts[email], nil
+}
+
+func (c *fakeVerificationCache) PutLoginAlertToken(userID uint64, token string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loginAlertTokens[token] = userID
+
+	return nil
+}
+
+func (c *fakeVerificationCache) GetLoginAlertTokenData(token string) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	userID, ok := c.loginAlertTokens[token]
+	if !ok {
+		return 0, redis.ErrNil
+	}
+
+	return userID, nil
+}
+
+func (c *fakeVerificationCache) DeleteLoginAlertToken(token string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.loginAlertTokens, token)
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const revokedPasswordLength = 32
+
+var ErrLoginAlertTokenNotFound = errors.New("login alert token not found or expired")
+
+type (
+	LoginAlertService struct {
+		log          *logrus.Entry
+		repo         *repository.Repository
+		verification Verification
+		mailer       Mailer
+		generator    RandomTokenGenerator
+	}
+)
+
+func NewLoginAlertService(
+	log *logrus.Entry, repo *repository.Repository,
+	verification Verification, mailer Mailer, generator RandomTokenGenerator,
+) *LoginAlertService {
+	return &LoginAlertService{
+		log:          log,
+		repo:         repo,
+		verification: verification,
+		mailer:       mailer,
+		generator:    generator,
+	}
+}
+
+// RegisterLogin remembers device and country of successful login.
+// If user has signed in before and device or country is new, user is alerted by email.
+func (s *LoginAlertService) RegisterLogin(ctx context.Context, userID uint64, info models.LoginInfo) error {
+	devices, err := s.repo.UserLoginDevice.GetUserLoginDevices(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	deviceHash := hashUserAgent(info.UserAgent)
+	isNewDevice, isNewCountry := true, info.Country != ""
+	for _, device := range devices {
+		if device.DeviceHash == deviceHash {
+			isNewDevice = false
+		}
+		if device.Country == info.Country {
+			isNewCountry = false
+		}
+	}
+
+	if err := s.repo.UserLoginDevice.UpsertUserLoginDevice(ctx, models.UserLoginDevice{
+		UserID:     userID,
+		DeviceHash: deviceHash,
+		Country:    info.Country,
+		UserAgent:  info.UserAgent,
+		LastIP:     info.IP,
+	}); err != nil {
+		return err
+	}
+
+	// the first login is not suspicious
+	if len(devices) == 0 || (!isNewDevice && !isNewCountry) {
+		return nil
+	}
+
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return ierrors.NewBusiness(ErrUserNotFound, "")
+	}
+
+	loginAlertToken, err := s.verification.CreateLoginAlertToken(userID)
+	if err != nil {
+		return err
+	}
+
+	s.mailer.SendLoginAlert(user.Email, info, loginAlertToken)
+
+	return nil
+}
+
+// RevokeAccessByLoginAlert handles "this wasn't me" link from login alert.
+// It revokes all sessions of user and replaces password with random one, so it must be reset.
+// Passkeys, OAuth identities and two-factor secret are revoked too, as intruder could add them
+// to sign in without password. Magic links are still sent only to email of user.
+func (s *LoginAlertService) RevokeAccessByLoginAlert(
+	ctx context.Context, loginAlertToken string,
+) (userID uint64, err error) {
+	userID, err = s.verification.VerifyLoginAlertToken(loginAlertToken)
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return 0, ierrors.NewBusiness(ErrLoginAlertTokenNotFound, "")
+		}
+
+		return 0, err
+	}
+
+	if err := s.repo.SessionCache.DeleteUserSessions(strconv.FormatUint(userID, 10)); err != nil {
+		return 0, errors.Wrap(err, "failed to revoke user sessions")
+	}
+
+	randomPassword, err := s.generator.Generate(revokedPasswordLength, randomTokenDigitsNum, 0, false, true)
+	if err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := models.HashPassword(randomPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.User.UpdateUserPassword(ctx, userID, hashedPassword); err != nil {
+			return err
+		}
+
+		if err := s.repo.UserWebAuthn.DeleteWebAuthnCredentialsByUserID(ctx, userID); err != nil {
+			return err
+		}
+
+		if err := s.repo.UserIdentity.DeleteUserIdentitiesByUserID(ctx, userID); err != nil {
+			return err
+		}
+
+		return s.repo.UserTwoFactor.DeleteUserTwoFactor(ctx, userID)
+	}); err != nil {
+		return 0, errors.Wrap(err, "failed to revoke user credentials")
+	}
+
+	return userID, nil
+}
+
+func hashUserAgent(userAgent string) string {
+	hash := sha256.Sum256([]byte(userAgent))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/sethvargo/go-password/password"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRevokeAccessByLoginAlertRevokesPasswordlessSignIn(t *testing.T) {
+	ctx := context.Background()
+	_, repo, userID, _ := newTestTwoFactorServices(t, 5)
+
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	generator, err := password.NewGenerator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verification := NewVerificationService(logrus.NewEntry(log), repo.VerificationCache, generator)
+	svc := NewLoginAlertService(logrus.NewEntry(log), repo, verification, nil, generator)
+
+	// intruder added own ways to sign in without password
+	if err := repo.UserWebAuthn.CreateWebAuthnCredential(ctx, models.WebAuthnCredential{
+		ID: []byte("passkey"), UserID: userID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.UserIdentity.CreateUserIdentity(ctx, models.UserIdentity{
+		Provider: "oidc", Subject: "intruder", UserID: userID,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := verification.CreateLoginAlertToken(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.RevokeAccessByLoginAlert(ctx, token); err != nil {
+		t.Fatalf("RevokeAccessByLoginAlert: %v", err)
+	}
+
+	credentials, err := repo.UserWebAuthn.GetWebAuthnCredentialsByUserID(ctx, userID, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials) != 0 {
+		t.Errorf("passkeys are not revoked: %d left", len(credentials))
+	}
+
+	if identity, _ := repo.UserIdentity.GetUserIdentity(ctx, "oidc", "intruder"); identity != nil {
+		t.Errorf("OAuth identity is not revoked: %+v", identity)
+	}
+
+	if twoFactor, _ := repo.UserTwoFactor.GetUserTwoFactor(ctx, userID); twoFactor != nil {
+		t.Errorf("two-factor secret is not revoked")
+	}
+
+	user, err := repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password == "" {
+		t.Errorf("password is not replaced")
+	}
+
+	if revoked := repo.SessionCache.(*fakeSessionCache).revokedUsers; len(revoked) != 1 {
+		t.Errorf("sessions are revoked %d times, want 1", len(revoked))
+	}
+}
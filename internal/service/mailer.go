@@ -1,6 +1,7 @@
 package service
 
 import (
+	"github.com/l-orlov/matcha/internal/models"
 	"github.com/l-orlov/task-tracker/pkg/mailer"
 	"gopkg.in/mail.v2"
 )
@@ -50,3 +51,52 @@ func (m *MailerService) SendResetPasswordConfirm(toEmail, token string) {
 
 	m.mailer.SendMessage(msg)
 }
+
+func (m *MailerService) SendMagicLink(toEmail, token string) {
+	msg := mail.NewMessage()
+
+	msg.SetHeader("From", m.cfg.From)
+	msg.SetHeader("To", toEmail)
+	msg.SetHeader("Subject", "Matcha sign in")
+	msg.SetBody("text/plain",
+		"Hello.\nTo sign in go by this link. It can be used only once.\n"+
+			m.cfg.AppDomain+"/auth/magic-link/sign-in?token="+token+
+			"\nIf you did not request it, just ignore this email.")
+
+	m.mailer.SendMessage(msg)
+}
+
+func (m *MailerService) SendLoginAlert(toEmail string, info models.LoginInfo, token string) {
+	country := info.Country
+	if country == "" {
+		country = "unknown"
+	}
+
+	msg := mail.NewMessage()
+
+	msg.SetHeader("From", m.cfg.From)
+	msg.SetHeader("To", toEmail)
+	msg.SetHeader("Subject", "Matcha new sign in")
+	msg.SetBody("text/plain",
+		"Hello.\nYour account was signed in from a new device or location.\n"+
+			"Device: "+info.UserAgent+"\nIP: "+info.IP+"\nCountry: "+country+
+			"\nIf it was not you, go by this link to sign out everywhere, remove passkeys, linked accounts\n"+
+			"and two-factor authentication and reset your password.\n"+
+			m.cfg.AppDomain+"/auth/not-me?token="+token)
+
+	m.mailer.SendMessage(msg)
+}
+
+func (m *MailerService) SendDataExport(toEmail, url string) {
+	msg := mail.NewMessage()
+
+	msg.SetHeader("From", m.cfg.From)
+	msg.SetHeader("To", toEmail)
+	msg.SetHeader("Subject", "Matcha personal data export")
+	msg.SetBody("text/plain",
+		"Hello.\nExport of your personal data is ready. Download it by this link. It expires soon.\n"+
+			url+
+			"\nIf you did not request it, change your password.")
+
+	m.mailer.SendMessage(msg)
+}
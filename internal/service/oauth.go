@@ -0,0 +1,554 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gomodule/redigo/redis"
+	"github.com/l-orlov/matcha/internal/config"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oauthStateLength        = 32
+	oauthCodeVerifierLength = 32
+	oauthNonceLength        = 32
+	oauthPasswordLength     = 32
+	oauthUsernameMaxLength  = 40
+	oauthUsernameSuffixLen  = 4
+	oauthUsernameAttempts   = 5
+
+	defaultSubjectClaim       = "sub"
+	defaultEmailClaim         = "email"
+	defaultEmailVerifiedClaim = "email_verified"
+	defaultUsernameClaim      = "preferred_username"
+	defaultFirstNameClaim     = "given_name"
+	defaultLastNameClaim      = "family_name"
+)
+
+var (
+	ErrOAuthProviderNotFound    = errors.New("oauth provider not found")
+	ErrOAuthStateNotFound       = errors.New("oauth state not found")
+	ErrOAuthStateMismatch       = errors.New("oauth state does not belong to this browser")
+	ErrOAuthEmptySubject        = errors.New("oauth provider returned empty user subject")
+	ErrOAuthEmailNotVerified    = errors.New("oauth provider did not return verified email")
+	ErrOAuthAccountNotConfirmed = errors.New("account with this email must be confirmed before linking")
+	ErrOAuthNotValidIDToken     = errors.New("oauth provider returned not valid id token")
+
+	notValidUsernameSymbols = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+)
+
+type (
+	OAuthService struct {
+		log        *logrus.Entry
+		repo       *repository.Repository
+		generator  RandomTokenGenerator
+		httpClient *http.Client
+		providers  map[string]*oauthProvider
+	}
+	oauthProvider struct {
+		cfg config.OAuthProvider
+
+		// mu guards fields which are set on the first usage of provider
+		mu          sync.Mutex
+		oauth       *oauth2.Config
+		userInfoURL string
+		verifier    *oidc.IDTokenVerifier
+	}
+	oauthEmail struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+)
+
+func NewOAuthService(
+	cfg config.OAuth, log *logrus.Entry, repo *repository.Repository, generator RandomTokenGenerator,
+) *OAuthService {
+	providers := make(map[string]*oauthProvider, len(cfg.Providers))
+	for name, providerCfg := range cfg.Providers {
+		if providerCfg.ClientID != "" {
+			providers[name] = &oauthProvider{cfg: providerCfg}
+		}
+	}
+
+	return &OAuthService{
+		log:        log,
+		repo:       repo,
+		generator:  generator,
+		httpClient: &http.Client{Timeout: cfg.Timeout.Duration()},
+		providers:  providers,
+	}
+}
+
+// GetOAuthLoginURL starts authorization code flow with PKCE
+// and returns URL of provider login page to redirect user to. State must be kept in browser
+// which started login and passed to AuthenticateUserByOAuth, so callback of another login is rejected.
+func (s *OAuthService) GetOAuthLoginURL(
+	ctx context.Context, providerName string,
+) (loginURL, state string, err error) {
+	provider, err := s.getProvider(ctx, providerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = randomURLSafeString(oauthStateLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	codeVerifier, err := randomURLSafeString(oauthCodeVerifierLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	codeChallenge := sha256.Sum256([]byte(codeVerifier))
+	stateData := models.OAuthState{
+		Provider:     providerName,
+		CodeVerifier: codeVerifier,
+	}
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(codeChallenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+
+	// nonce binds id token of OpenID Connect provider to this login
+	if provider.verifier != nil {
+		if stateData.Nonce, err = randomURLSafeString(oauthNonceLength); err != nil {
+			return "", "", err
+		}
+
+		opts = append(opts, oidc.Nonce(stateData.Nonce))
+	}
+
+	if err = s.repo.VerificationCache.PutOAuthState(state, stateData); err != nil {
+		return "", "", errors.Wrap(err, "failed to put oauth state to cache")
+	}
+
+	return provider.oauth.AuthCodeURL(state, opts...), state, nil
+}
+
+// AuthenticateUserByOAuth completes authorization code flow. It links provider identity
+// to existing user by verified email or creates new user. State of callback must match browserState
+// kept by browser, otherwise attacker could sign victim in to attacker account by sending own callback URL.
+func (s *OAuthService) AuthenticateUserByOAuth(
+	ctx context.Context, providerName, state, browserState, code string,
+) (userID uint64, err error) {
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return 0, ierrors.NewBusiness(ErrOAuthStateMismatch, "")
+	}
+
+	stateData, err := s.repo.VerificationCache.GetOAuthState(state)
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return 0, ierrors.NewBusiness(ErrOAuthStateNotFound, "")
+		}
+
+		return 0, err
+	}
+
+	if err = s.repo.VerificationCache.DeleteOAuthState(state); err != nil {
+		s.log.Error(errors.Wrap(err, "failed to delete oauth state from cache"))
+	}
+
+	if stateData.Provider != providerName {
+		return 0, ierrors.NewBusiness(ErrOAuthStateNotFound, "")
+	}
+
+	provider, err := s.getProvider(ctx, providerName)
+	if err != nil {
+		return 0, err
+	}
+
+	clientCtx := context.WithValue(ctx, oauth2.HTTPClient, s.httpClient)
+
+	token, err := provider.oauth.Exchange(clientCtx, code,
+		oauth2.SetAuthURLParam("code_verifier", stateData.CodeVerifier),
+	)
+	if err != nil {
+		return 0, ierrors.NewBusiness(errors.Wrap(err, "failed to exchange oauth code"), "")
+	}
+
+	userInfo, err := s.getUserInfo(clientCtx, provider, token, stateData.Nonce)
+	if err != nil {
+		return 0, err
+	}
+
+	// user must not be created without identity if linking fails
+	err = s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		userID, err = s.linkOrCreateUser(ctx, providerName, userInfo)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (s *OAuthService) linkOrCreateUser(
+	ctx context.Context, providerName string, userInfo *models.OAuthUserInfo,
+) (uint64, error) {
+	identity, err := s.repo.UserIdentity.GetUserIdentity(ctx, providerName, userInfo.Subject)
+	if err != nil {
+		return 0, err
+	}
+
+	if identity != nil {
+		return identity.UserID, nil
+	}
+
+	if userInfo.Email == "" || !userInfo.IsEmailVerified {
+		return 0, ierrors.NewBusiness(ErrOAuthEmailNotVerified, "")
+	}
+
+	var userID uint64
+	user, err := s.repo.User.GetUserByEmail(ctx, userInfo.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	if user != nil {
+		// not confirmed account could be registered by someone else with this email
+		if !user.IsEmailConfirmed {
+			return 0, ierrors.NewBusiness(ErrOAuthAccountNotConfirmed, "")
+		}
+
+		userID = user.ID
+	} else {
+		if userID, err = s.createUser(ctx, userInfo); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = s.repo.UserIdentity.CreateUserIdentity(ctx, models.UserIdentity{
+		Provider: providerName,
+		Subject:  userInfo.Subject,
+		UserID:   userID,
+		Email:    userInfo.Email,
+	}); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (s *OAuthService) createUser(ctx context.Context, userInfo *models.OAuthUserInfo) (uint64, error) {
+	username, err := s.getFreeUsername(ctx, userInfo)
+	if err != nil {
+		return 0, err
+	}
+
+	// user can set own password later by password reset
+	randomPassword, err := s.generator.Generate(oauthPasswordLength, randomTokenDigitsNum, 0, false, true)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to generate password")
+	}
+
+	hashedPassword, err := models.HashPassword(randomPassword)
+	if err != nil {
+		return 0, ierrors.New(err)
+	}
+
+	userID, err := s.repo.User.CreateUser(ctx, models.UserToCreate{
+		Email:     userInfo.Email,
+		Username:  username,
+		FirstName: userInfo.FirstName,
+		LastName:  userInfo.LastName,
+		Password:  hashedPassword,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err = s.repo.User.ConfirmEmail(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (s *OAuthService) getFreeUsername(ctx context.Context, userInfo *models.OAuthUserInfo) (string, error) {
+	base := userInfo.Username
+	if base == "" {
+		base = strings.Split(userInfo.Email, "@")[0]
+	}
+
+	base = notValidUsernameSymbols.ReplaceAllString(base, "")
+	if len(base) > oauthUsernameMaxLength {
+		base = base[:oauthUsernameMaxLength]
+	}
+
+	username := base
+	for i := 0; i < oauthUsernameAttempts; i++ {
+		if username != "" {
+			user, err := s.repo.User.GetUserByUsername(ctx, username)
+			if err != nil {
+				return "", err
+			}
+
+			if user == nil {
+				return username, nil
+			}
+		}
+
+		suffix, err := s.generator.Generate(oauthUsernameSuffixLen, oauthUsernameSuffixLen, 0, true, true)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to generate username suffix")
+		}
+
+		username = base + "_" + suffix
+	}
+
+	return "", ierrors.NewBusiness(ErrUsernameIsTaken, "")
+}
+
+func (s *OAuthService) getUserInfo(
+	ctx context.Context, provider *oauthProvider, token *oauth2.Token, nonce string,
+) (*models.OAuthUserInfo, error) {
+	claims, err := s.getClaims(ctx, provider, token, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := provider.cfg
+	userInfo := &models.OAuthUserInfo{
+		Subject:   claimString(claims, cfg.SubjectClaim, defaultSubjectClaim),
+		Email:     claimString(claims, cfg.EmailClaim, defaultEmailClaim),
+		Username:  claimString(claims, cfg.UsernameClaim, defaultUsernameClaim),
+		FirstName: claimString(claims, cfg.FirstNameClaim, defaultFirstNameClaim),
+		LastName:  claimString(claims, cfg.LastNameClaim, defaultLastNameClaim),
+	}
+
+	if cfg.TrustEmail {
+		userInfo.IsEmailVerified = true
+	} else {
+		userInfo.IsEmailVerified = claimBool(claims, cfg.EmailVerifiedClaim, defaultEmailVerifiedClaim)
+	}
+
+	if cfg.EmailsURL != "" {
+		if userInfo.Email, userInfo.IsEmailVerified, err = s.getPrimaryEmail(ctx, provider, token); err != nil {
+			return nil, err
+		}
+	}
+
+	if userInfo.Subject == "" {
+		return nil, ErrOAuthEmptySubject
+	}
+
+	return userInfo, nil
+}
+
+// getClaims returns claims of verified id token of OpenID Connect provider completed by user info.
+// User info is the only source of claims for other providers.
+func (s *OAuthService) getClaims(
+	ctx context.Context, provider *oauthProvider, token *oauth2.Token, nonce string,
+) (map[string]interface{}, error) {
+	var idTokenClaims map[string]interface{}
+	if provider.verifier != nil {
+		var err error
+		if idTokenClaims, err = s.verifyIDToken(ctx, provider, token, nonce); err != nil {
+			return nil, err
+		}
+	}
+
+	if provider.userInfoURL == "" {
+		return idTokenClaims, nil
+	}
+
+	claims := make(map[string]interface{})
+	if err := s.getProviderJSON(ctx, provider, token, provider.userInfoURL, &claims); err != nil {
+		return nil, errors.Wrap(err, "failed to get oauth user info")
+	}
+
+	if idTokenClaims == nil {
+		return claims, nil
+	}
+
+	// user info is not signed, so it only adds claims missing in id token of the same user
+	if claimString(claims, defaultSubjectClaim, "") != claimString(idTokenClaims, defaultSubjectClaim, "") {
+		return nil, ierrors.NewBusiness(errors.Wrap(ErrOAuthNotValidIDToken, "subject of user info differs"), "")
+	}
+
+	for name, value := range idTokenClaims {
+		claims[name] = value
+	}
+
+	return claims, nil
+}
+
+func (s *OAuthService) verifyIDToken(
+	ctx context.Context, provider *oauthProvider, token *oauth2.Token, nonce string,
+) (map[string]interface{}, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ierrors.NewBusiness(errors.Wrap(ErrOAuthNotValidIDToken, "no id token"), "")
+	}
+
+	// verifier checks signature, issuer, audience and expiration
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, ierrors.NewBusiness(errors.Wrap(ErrOAuthNotValidIDToken, err.Error()), "")
+	}
+
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, ierrors.NewBusiness(errors.Wrap(ErrOAuthNotValidIDToken, "nonce mismatch"), "")
+	}
+
+	claims := make(map[string]interface{})
+	if err = idToken.Claims(&claims); err != nil {
+		return nil, errors.Wrap(err, "failed to decode id token claims")
+	}
+
+	return claims, nil
+}
+
+// getPrimaryEmail returns primary email of user from provider emails list
+// and whether it is verified. Other emails are not used, they could be not verified.
+func (s *OAuthService) getPrimaryEmail(
+	ctx context.Context, provider *oauthProvider, token *oauth2.Token,
+) (email string, isVerified bool, err error) {
+	var emails []oauthEmail
+	if err = s.getProviderJSON(ctx, provider, token, provider.cfg.EmailsURL, &emails); err != nil {
+		return "", false, errors.Wrap(err, "failed to get oauth user emails")
+	}
+
+	for _, item := range emails {
+		if item.Primary {
+			return item.Email, item.Verified, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+func (s *OAuthService) getProviderJSON(
+	ctx context.Context, provider *oauthProvider, token *oauth2.Token, url string, v interface{},
+) error {
+	resp, err := provider.oauth.Client(ctx, token).Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			s.log.Error(err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("status %d", resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+// getProvider returns configured provider discovering its endpoints on first usage.
+func (s *OAuthService) getProvider(ctx context.Context, name string) (*oauthProvider, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, ierrors.NewBusiness(ErrOAuthProviderNotFound, "")
+	}
+
+	if err := provider.init(ctx, s.httpClient); err != nil {
+		return nil, err
+	}
+
+	return provider, nil
+}
+
+// init sets endpoints of provider, OpenID Connect endpoints are discovered by issuer.
+// Lock is held per provider, so slow discovery does not block other providers,
+// and failed discovery is retried on the next usage.
+func (p *oauthProvider) init(ctx context.Context, httpClient *http.Client) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return nil
+	}
+
+	endpoint := oauth2.Endpoint{
+		AuthURL:  p.cfg.AuthURL,
+		TokenURL: p.cfg.TokenURL,
+	}
+	userInfoURL := p.cfg.UserInfoURL
+
+	if p.cfg.Issuer != "" {
+		// keys of id token are fetched later with client of this context, its cancellation is ignored
+		discovered, err := oidc.NewProvider(oidc.ClientContext(ctx, httpClient), p.cfg.Issuer)
+		if err != nil {
+			return errors.Wrap(err, "failed to discover openid configuration")
+		}
+
+		endpoint = discovered.Endpoint()
+		userInfoURL = discovered.UserInfoEndpoint()
+		p.verifier = discovered.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+	}
+
+	p.userInfoURL = userInfoURL
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     endpoint,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+	}
+
+	return nil
+}
+
+func claimString(claims map[string]interface{}, name, defaultName string) string {
+	if name == "" {
+		name = defaultName
+	}
+
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	default:
+		return ""
+	}
+}
+
+func claimBool(claims map[string]interface{}, name, defaultName string) bool {
+	if name == "" {
+		name = defaultName
+	}
+
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	default:
+		return false
+	}
+}
+
+func randomURLSafeString(bytesNum int) (string, error) {
+	b := make([]byte, bytesNum)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate random string")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
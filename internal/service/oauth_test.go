@@ -0,0 +1,545 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sethvargo/go-password/password"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testOAuthClientID = "matcha"
+	testOAuthKeyID    = "test-key"
+)
+
+type (
+	// stubOAuthProvider is OpenID Connect provider which also serves GitHub-like user and emails endpoints.
+	// Authorization is skipped: test authorizes login URL with claims of user and gets code to pass to callback.
+	stubOAuthProvider struct {
+		t      *testing.T
+		server *httptest.Server
+		key    *rsa.PrivateKey
+
+		mu     sync.Mutex
+		codes  map[string]stubOAuthGrant
+		tokens map[string]stubOAuthGrant
+		// idTokenNonce replaces nonce of login URL in id token when it is set
+		idTokenNonce string
+	}
+	stubOAuthGrant struct {
+		codeChallenge string
+		nonce         string
+		claims        map[string]interface{}
+		emails        []oauthEmail
+	}
+)
+
+func newStubOAuthProvider(t *testing.T) *stubOAuthProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &stubOAuthProvider{
+		t:      t,
+		key:    key,
+		codes:  make(map[string]stubOAuthGrant),
+		tokens: make(map[string]stubOAuthGrant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/jwks", p.handleJWKS)
+	mux.HandleFunc("/token", p.handleToken)
+	mux.HandleFunc("/userinfo", p.handleUserInfo)
+	mux.HandleFunc("/user/emails", p.handleEmails)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// authorize imitates user consent on login page and returns code which provider redirects with.
+func (p *stubOAuthProvider) authorize(loginURL string, claims map[string]interface{}, emails []oauthEmail) string {
+	p.t.Helper()
+
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		p.t.Fatalf("expected PKCE challenge in login URL %s", loginURL)
+	}
+
+	code := "code-" + query.Get("state")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.codes[code] = stubOAuthGrant{
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+		claims:        claims,
+		emails:        emails,
+	}
+
+	return code
+}
+
+func (p *stubOAuthProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	p.writeJSON(w, map[string]interface{}{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"userinfo_endpoint":                     p.server.URL + "/userinfo",
+		"jwks_uri":                              p.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *stubOAuthProvider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	p.writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": testOAuthKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *stubOAuthProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	code := r.PostForm.Get("code")
+	grant, ok := p.codes[code]
+	delete(p.codes, code)
+
+	codeChallenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(codeChallenge[:]) != grant.codeChallenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	nonce := grant.nonce
+	if p.idTokenNonce != "" {
+		nonce = p.idTokenNonce
+	}
+
+	idTokenClaims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   testOAuthClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range grant.claims {
+		idTokenClaims[name] = value
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, idTokenClaims)
+	idToken.Header["kid"] = testOAuthKeyID
+	signedIDToken, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken := "token-" + code
+	p.tokens[accessToken] = grant
+
+	p.writeJSON(w, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signedIDToken,
+	})
+}
+
+func (p *stubOAuthProvider) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	grant, ok := p.getGrant(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	p.writeJSON(w, grant.claims)
+}
+
+func (p *stubOAuthProvider) handleEmails(w http.ResponseWriter, r *http.Request) {
+	grant, ok := p.getGrant(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	p.writeJSON(w, grant.emails)
+}
+
+func (p *stubOAuthProvider) getGrant(r *http.Request) (stubOAuthGrant, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	grant, ok := p.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+
+	return grant, ok
+}
+
+func (p *stubOAuthProvider) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		p.t.Error(err)
+	}
+}
+
+func newTestOAuthService(t *testing.T, provider *stubOAuthProvider) (*OAuthService, *repository.Repository) {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	generator, err := password.NewGenerator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newFakeRepository()
+	svc := NewOAuthService(config.OAuth{
+		Providers: map[string]config.OAuthProvider{
+			"oidc": {
+				ClientID:    testOAuthClientID,
+				RedirectURL: "http://localhost:8080/auth/oauth/oidc/callback",
+				Scopes:      []string{"openid", "email", "profile"},
+				Issuer:      provider.server.URL,
+			},
+			"github": {
+				ClientID:      testOAuthClientID,
+				RedirectURL:   "http://localhost:8080/auth/oauth/github/callback",
+				AuthURL:       provider.server.URL + "/authorize",
+				TokenURL:      provider.server.URL + "/token",
+				UserInfoURL:   provider.server.URL + "/userinfo",
+				EmailsURL:     provider.server.URL + "/user/emails",
+				SubjectClaim:  "id",
+				UsernameClaim: "login",
+			},
+		},
+	}, logrus.NewEntry(log), repo, generator)
+
+	return svc, repo
+}
+
+// signIn goes through authorization code flow and returns result of callback.
+func signIn(
+	t *testing.T, svc *OAuthService, provider *stubOAuthProvider, providerName string,
+	claims map[string]interface{}, emails []oauthEmail,
+) (uint64, error) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	loginURL, browserState, err := svc.GetOAuthLoginURL(ctx, providerName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := provider.authorize(loginURL, claims, emails)
+
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svc.AuthenticateUserByOAuth(ctx, providerName, u.Query().Get("state"), browserState, code)
+}
+
+func createConfirmedUser(t *testing.T, repo *repository.Repository, email string) uint64 {
+	t.Helper()
+
+	ctx := context.Background()
+
+	userID, err := repo.User.CreateUser(ctx, models.UserToCreate{Email: email, Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.User.ConfirmEmail(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	return userID
+}
+
+func getIdentity(t *testing.T, repo *repository.Repository, providerName, subject string) *models.UserIdentity {
+	t.Helper()
+
+	identity, err := repo.UserIdentity.GetUserIdentity(context.Background(), providerName, subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return identity
+}
+
+func TestOAuthService_CreatesUser(t *testing.T) {
+	provider := newStubOAuthProvider(t)
+	svc, repo := newTestOAuthService(t, provider)
+
+	userID, err := signIn(t, svc, provider, "oidc", map[string]interface{}{
+		"sub":                "subject-1",
+		"email":              "bob@example.com",
+		"email_verified":     true,
+		"preferred_username": "bob",
+		"given_name":         "Bob",
+		"family_name":        "Brown",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.User.GetUserByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.Email != "bob@example.com" || user.Username != "bob" || !user.IsEmailConfirmed {
+		t.Fatalf("expected new user with confirmed email, got %+v", user)
+	}
+
+	identity := getIdentity(t, repo, "oidc", "subject-1")
+	if identity == nil || identity.UserID != userID {
+		t.Fatalf("expected identity of user %d, got %+v", userID, identity)
+	}
+
+	if txNum := repo.TxManager.(*fakeTxManager).txNum; txNum != 1 {
+		t.Fatalf("expected user to be created in transaction, got %d transactions", txNum)
+	}
+
+	// next sign in finds user by identity
+	secondUserID, err := signIn(t, svc, provider, "oidc", map[string]interface{}{
+		"sub":            "subject-1",
+		"email":          "bob@example.com",
+		"email_verified": true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secondUserID != userID {
+		t.Fatalf("expected user %d, got %d", userID, secondUserID)
+	}
+}
+
+func TestOAuthService_LinksUserByVerifiedEmail(t *testing.T) {
+	provider := newStubOAuthProvider(t)
+	svc, repo := newTestOAuthService(t, provider)
+	existingID := createConfirmedUser(t, repo, "alice@example.com")
+
+	userID, err := signIn(t, svc, provider, "oidc", map[string]interface{}{
+		"sub":            "subject-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != existingID {
+		t.Fatalf("expected identity to be linked to user %d, got %d", existingID, userID)
+	}
+
+	if identity := getIdentity(t, repo, "oidc", "subject-1"); identity == nil || identity.UserID != existingID {
+		t.Fatalf("expected identity of user %d, got %+v", existingID, identity)
+	}
+}
+
+func TestOAuthService_UnverifiedEmailDoesNotLink(t *testing.T) {
+	provider := newStubOAuthProvider(t)
+	svc, repo := newTestOAuthService(t, provider)
+	createConfirmedUser(t, repo, "alice@example.com")
+
+	_, err := signIn(t, svc, provider, "oidc", map[string]interface{}{
+		"sub":            "subject-1",
+		"email":          "alice@example.com",
+		"email_verified": false,
+	}, nil)
+	if !errors.Is(err, ErrOAuthEmailNotVerified) {
+		t.Fatalf("expected %v, got %v", ErrOAuthEmailNotVerified, err)
+	}
+
+	if identity := getIdentity(t, repo, "oidc", "subject-1"); identity != nil {
+		t.Fatalf("expected identity not to be linked, got %+v", identity)
+	}
+}
+
+func TestOAuthService_UnverifiedUserInfoEmailIsNotTrusted(t *testing.T) {
+	provider := newStubOAuthProvider(t)
+	svc, repo := newTestOAuthService(t, provider)
+	existingID := createConfirmedUser(t, repo, "alice@example.com")
+
+	// email of GitHub profile is not verified, only primary verified email of emails list counts
+	_, err := signIn(t, svc, provider, "github", map[string]interface{}{
+		"id":    json.Number("42"),
+		"login": "mallory",
+		"email": "alice@example.com",
+	}, []oauthEmail{
+		{Email: "mallory@example.com", Primary: true, Verified: false},
+		{Email: "alice@example.com", Primary: false, Verified: true},
+	})
+	if !errors.Is(err, ErrOAuthEmailNotVerified) {
+		t.Fatalf("expected %v, got %v", ErrOAuthEmailNotVerified, err)
+	}
+
+	userID, err := signIn(t, svc, provider, "github", map[string]interface{}{
+		"id":    json.Number("43"),
+		"login": "alice",
+	}, []oauthEmail{
+		{Email: "alice@example.com", Primary: true, Verified: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != existingID {
+		t.Fatalf("expected identity to be linked to user %d, got %d", existingID, userID)
+	}
+}
+
+func TestOAuthService_PKCEVerifier(t *testing.T) {
+	provider := newStubOAuthProvider(t)
+	svc, repo := newTestOAuthService(t, provider)
+	ctx := context.Background()
+
+	loginURL, state, err := svc.GetOAuthLoginURL(ctx, "oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := provider.authorize(loginURL, map[string]interface{}{
+		"sub":            "subject-1",
+		"email":          "bob@example.com",
+		"email_verified": true,
+	}, nil)
+
+	// code intercepted by attacker can not be exchanged without verifier of login state
+	stateData, err := repo.VerificationCache.GetOAuthState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateData.CodeVerifier = "attacker verifier"
+	if err = repo.VerificationCache.PutOAuthState(state, *stateData); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = svc.AuthenticateUserByOAuth(ctx, "oidc", state, state, code); err == nil {
+		t.Fatal("expected code exchange with wrong verifier to fail")
+	}
+
+	if identity := getIdentity(t, repo, "oidc", "subject-1"); identity != nil {
+		t.Fatalf("expected identity not to be created, got %+v", identity)
+	}
+}
+
+func TestOAuthService_StateMismatch(t *testing.T) {
+	provider := newStubOAuthProvider(t)
+	svc, _ := newTestOAuthService(t, provider)
+	ctx := context.Background()
+
+	_, err := svc.AuthenticateUserByOAuth(ctx, "oidc", "unknown state", "unknown state", "code")
+	if !errors.Is(err, ErrOAuthStateNotFound) {
+		t.Fatalf("expected %v, got %v", ErrOAuthStateNotFound, err)
+	}
+
+	// state of one provider can not be used in callback of another one
+	_, state, err := svc.GetOAuthLoginURL(ctx, "github")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.AuthenticateUserByOAuth(ctx, "oidc", state, state, "code")
+	if !errors.Is(err, ErrOAuthStateNotFound) {
+		t.Fatalf("expected %v, got %v", ErrOAuthStateNotFound, err)
+	}
+}
+
+func TestOAuthService_LoginCSRF(t *testing.T) {
+	provider := newStubOAuthProvider(t)
+	svc, repo := newTestOAuthService(t, provider)
+	ctx := context.Background()
+
+	// attacker completes own authorization and sends callback URL to victim
+	loginURL, attackerState, err := svc.GetOAuthLoginURL(ctx, "oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := provider.authorize(loginURL, map[string]interface{}{
+		"sub":            "attacker",
+		"email":          "attacker@example.com",
+		"email_verified": true,
+	}, nil)
+
+	// victim browser has state of own login or none at all
+	_, victimState, err := svc.GetOAuthLoginURL(ctx, "oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, browserState := range []string{victimState, ""} {
+		_, err = svc.AuthenticateUserByOAuth(ctx, "oidc", attackerState, browserState, code)
+		if !errors.Is(err, ErrOAuthStateMismatch) {
+			t.Fatalf("expected %v, got %v", ErrOAuthStateMismatch, err)
+		}
+	}
+
+	if identity := getIdentity(t, repo, "oidc", "attacker"); identity != nil {
+		t.Fatalf("expected identity not to be created, got %+v", identity)
+	}
+}
+
+func TestOAuthService_NonceMismatch(t *testing.T) {
+	provider := newStubOAuthProvider(t)
+	provider.idTokenNonce = "replayed nonce"
+	svc, repo := newTestOAuthService(t, provider)
+
+	_, err := signIn(t, svc, provider, "oidc", map[string]interface{}{
+		"sub":            "subject-1",
+		"email":          "bob@example.com",
+		"email_verified": true,
+	}, nil)
+	if !errors.Is(err, ErrOAuthNotValidIDToken) {
+		t.Fatalf("expected %v, got %v", ErrOAuthNotValidIDToken, err)
+	}
+
+	if identity := getIdentity(t, repo, "oidc", "subject-1"); identity != nil {
+		t.Fatalf("expected identity not to be created, got %+v", identity)
+	}
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/pagination"
+)
+
+// Names of paginated lists. Cursor is signed with list name, so it is valid only for its list.
+const (
+	usersList           = "users"
+	userPicturesList    = "user-pictures"
+	pendingPicturesList = "pending-pictures"
+	pendingAvatarsList  = "pending-avatars"
+	pictureMatchesList  = "picture-matches"
+	passkeysList        = "passkeys"
+)
+
+// decodePageRequest returns limit of requested page and decodes key from its cursor.
+// It returns false if the first page is requested, so key is not set.
+func decodePageRequest(
+	paginator *pagination.Paginator, list string, req pagination.Request, key interface{},
+) (bool, int, error) {
+	limit, err := paginator.Limit(req)
+	if err != nil {
+		return false, 0, ierrors.NewBusiness(err, "")
+	}
+
+	ok, err := paginator.DecodeCursor(list, req.Cursor, key)
+	if err != nil {
+		return false, 0, ierrors.NewBusiness(err, "")
+	}
+
+	return ok, limit, nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+const (
+	differenceHashWidth  = 9
+	differenceHashHeight = 8
+)
+
+// differenceHash returns perceptual dHash of image. Image is scaled down to 9x8 grayscale and every bit
+// tells whether pixel is brighter than its right neighbour, so hash survives resizing and re-encoding.
+// Hashes of similar images differ in few bits.
+func differenceHash(img image.Image) uint64 {
+	gray := image.NewGray(image.Rect(0, 0, differenceHashWidth, differenceHashHeight))
+	draw.Draw(gray, gray.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(gray, gray.Bounds(), img, img.Bounds(), draw.Over, nil)
+
+	var hash uint64
+	for y := 0; y < differenceHashHeight; y++ {
+		for x := 0; x < differenceHashWidth-1; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y > gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// pictureDifferenceHash decodes picture and returns its dHash.
+func pictureDifferenceHash(picture []byte) (uint64, error) {
+	img, _, err := image.Decode(bytes.NewReader(picture))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decode picture")
+	}
+
+	return differenceHash(img), nil
+}
+
+func parsePictureHash(s string) (uint64, error) {
+	if _, err := hex.DecodeString(s); err != nil || len(s) != 16 {
+		return 0, errors.Errorf("not valid picture hash %q", s)
+	}
+
+	return strconv.ParseUint(s, 16, 64)
+}
+
+func pictureHashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type ModerationVerdict string
+
+const (
+	ModerationApprove ModerationVerdict = "approve"
+	ModerationReject  ModerationVerdict = "reject"
+	// ModerationReview hides picture from other users until moderator reviews it.
+	ModerationReview ModerationVerdict = "review"
+)
+
+type (
+	// PictureModerator checks processed JPEG picture before it is stored.
+	PictureModerator interface {
+		ModeratePicture(ctx context.Context, picture []byte) (ModerationVerdict, error)
+	}
+	// PictureBlocklistModerator compares perceptual hash of picture with hashes of blocked pictures.
+	PictureBlocklistModerator struct {
+		hashes         []uint64
+		rejectDistance int
+		reviewDistance int
+	}
+	// HTTPPictureModerator calls external classifier.
+	HTTPPictureModerator struct {
+		log    *logrus.Entry
+		url    string
+		client *http.Client
+	}
+	httpModerationResponse struct {
+		Verdict ModerationVerdict `json:"verdict"`
+	}
+)
+
+func NewPictureModerator(cfg config.PictureModeration, log *logrus.Entry) (PictureModerator, error) {
+	switch cfg.Moderator {
+	case "", config.PictureModeratorBlocklist:
+		return NewPictureBlocklistModerator(cfg.Blocklist)
+	case config.PictureModeratorHTTP:
+		return NewHTTPPictureModerator(cfg.HTTP, log)
+	default:
+		return nil, errors.Errorf("unknown picture moderator %q", cfg.Moderator)
+	}
+}
+
+func NewPictureBlocklistModerator(cfg config.PictureBlocklist) (*PictureBlocklistModerator, error) {
+	hashes := make([]uint64, len(cfg.Hashes))
+	for i := range cfg.Hashes {
+		hash, err := parsePictureHash(cfg.Hashes[i])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse picture blocklist")
+		}
+
+		hashes[i] = hash
+	}
+
+	return &PictureBlocklistModerator{
+		hashes:         hashes,
+		rejectDistance: cfg.RejectDistance,
+		reviewDistance: cfg.ReviewDistance,
+	}, nil
+}
+
+func (m *PictureBlocklistModerator) ModeratePicture(_ context.Context, picture []byte) (ModerationVerdict, error) {
+	if len(m.hashes) == 0 {
+		return ModerationApprove, nil
+	}
+
+	hash, err := pictureDifferenceHash(picture)
+	if err != nil {
+		return "", err
+	}
+
+	verdict := ModerationApprove
+	for _, blocked := range m.hashes {
+		distance := pictureHashDistance(hash, blocked)
+		if distance <= m.rejectDistance {
+			return ModerationReject, nil
+		}
+
+		if distance <= m.reviewDistance {
+			verdict = ModerationReview
+		}
+	}
+
+	return verdict, nil
+}
+
+func NewHTTPPictureModerator(cfg config.HTTPPictureModerator, log *logrus.Entry) (*HTTPPictureModerator, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("picture moderator URL is not set")
+	}
+
+	return &HTTPPictureModerator{
+		log:    log,
+		url:    cfg.URL,
+		client: &http.Client{Timeout: cfg.Timeout.Duration()},
+	}, nil
+}
+
+func (m *HTTPPictureModerator) ModeratePicture(ctx context.Context, picture []byte) (ModerationVerdict, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(picture))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", pictureContentType)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to call picture moderator")
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			m.log.Error(err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("picture moderator responded with status %d", resp.StatusCode)
+	}
+
+	var response httpModerationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", errors.Wrap(err, "failed to decode picture moderator response")
+	}
+
+	switch response.Verdict {
+	case ModerationApprove, ModerationReject, ModerationReview:
+		return response.Verdict, nil
+	default:
+		return "", errors.Errorf("unknown picture moderator verdict %q", response.Verdict)
+	}
+}
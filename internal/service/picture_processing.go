@@ -0,0 +1,302 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+
+	// decoders of supported upload formats
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/l-orlov/matcha/internal/config"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const pictureContentType = "image/jpeg"
+
+// uploadContentTypes are content types of supported upload formats.
+var uploadContentTypes = map[string]struct{}{
+	"image/jpeg": {},
+	"image/png":  {},
+	"image/gif":  {},
+	"image/webp": {},
+}
+
+var (
+	ErrNotValidPicture = errors.New("file is not a supported image")
+	ErrPictureTooLarge = errors.New("image is too large")
+	ErrNotValidUpload  = errors.New("upload must be a supported image of not zero size")
+)
+
+type (
+	// PictureProcessor validates uploaded pictures and re-encodes them to JPEG.
+	// Re-encoding drops all metadata, EXIF orientation is applied to pixels before.
+	PictureProcessor struct {
+		cfg config.PictureProcessing
+	}
+	ProcessedPicture struct {
+		Original []byte
+		// Variants are resized copies by variant name.
+		Variants map[string][]byte
+		// ContentHash is hex SHA-256 of original. Variants are made from original, so it identifies them too.
+		ContentHash string
+		// PerceptualHash is dHash of picture, it is close for visually similar pictures.
+		PerceptualHash uint64
+	}
+)
+
+func NewPictureProcessor(cfg config.PictureProcessing) *PictureProcessor {
+	return &PictureProcessor{
+		cfg: cfg,
+	}
+}
+
+func (p *PictureProcessor) Process(r io.Reader) (*ProcessedPicture, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, p.cfg.MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > p.cfg.MaxFileSize {
+		return nil, ierrors.NewBusiness(ErrPictureTooLarge, "")
+	}
+
+	// check dimensions by header before decoding to not allocate memory for decompression bombs
+	imgConfig, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ierrors.NewBusiness(ErrNotValidPicture, "")
+	}
+
+	if imgConfig.Width <= 0 || imgConfig.Height <= 0 {
+		return nil, ierrors.NewBusiness(ErrNotValidPicture, "")
+	}
+
+	if int64(imgConfig.Width)*int64(imgConfig.Height) > p.cfg.MaxPixels {
+		return nil, ierrors.NewBusiness(ErrPictureTooLarge, "")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ierrors.NewBusiness(ErrNotValidPicture, "")
+	}
+
+	// fitting box is square, so picture is resized before orientation is applied to not transform full size pixels
+	resized := resizeToFit(img, p.cfg.MaxSize)
+	if format == "jpeg" {
+		resized = applyOrientation(resized, jpegOrientation(data))
+	}
+	img = resized
+
+	processed := &ProcessedPicture{
+		Variants:       make(map[string][]byte, len(p.cfg.Variants)),
+		PerceptualHash: differenceHash(img),
+	}
+
+	if processed.Original, err = p.encode(img); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(processed.Original)
+	processed.ContentHash = hex.EncodeToString(hash[:])
+
+	for _, variant := range p.cfg.Variants {
+		if processed.Variants[variant.Name], err = p.encode(resizeToFit(img, variant.MaxSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	return processed, nil
+}
+
+// CheckUpload checks declared content type and size of file before it is read.
+func (p *PictureProcessor) CheckUpload(contentType string, size int64) error {
+	if _, ok := uploadContentTypes[contentType]; !ok || size <= 0 {
+		return ierrors.NewBusiness(ErrNotValidUpload, "")
+	}
+
+	if size > p.cfg.MaxFileSize {
+		return ierrors.NewBusiness(ErrPictureTooLarge, "")
+	}
+
+	return nil
+}
+
+// VariantNames returns names of variants which are made for every picture.
+func (p *PictureProcessor) VariantNames() []string {
+	names := make([]string, len(p.cfg.Variants))
+	for i := range p.cfg.Variants {
+		names[i] = p.cfg.Variants[i].Name
+	}
+
+	return names
+}
+
+func (p *PictureProcessor) encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: p.cfg.JPEGQuality}); err != nil {
+		return nil, errors.Wrap(err, "failed to encode picture")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// resizeToFit scales image down to fit into square with maxSize side keeping aspect ratio.
+// Transparent pixels are flattened on white background, because JPEG has no alpha channel.
+func resizeToFit(img image.Image, maxSize int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if maxSize > 0 && (width > maxSize || height > maxSize) {
+		if width >= height {
+			height = height * maxSize / width
+			width = maxSize
+		} else {
+			width = width * maxSize / height
+			height = maxSize
+		}
+
+		if width == 0 {
+			width = 1
+		}
+		if height == 0 {
+			height = 1
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	return dst
+}
+
+// applyOrientation transforms image according to EXIF orientation tag value. Pixels are copied directly,
+// generic At and Set convert color of every pixel.
+func applyOrientation(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = width-1-x, y
+			case 3: // rotate 180
+				dx, dy = width-1-x, height-1-y
+			case 4: // flip vertical
+				dx, dy = x, height-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = height-1-y, x
+			case 7: // transverse
+				dx, dy = height-1-y, width-1-x
+			case 8: // rotate 270 clockwise
+				dx, dy = y, width-1-x
+			}
+			src := img.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			copy(dst.Pix[dst.PixOffset(dx, dy):], img.Pix[src:src+4])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation finds EXIF orientation in JPEG segments. It returns 1 (normal) if tag is not found.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+
+		marker := data[i+1]
+		// start of scan or end of image: metadata segments are over
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+
+		// APP1 segment keeps EXIF
+		if marker == 0xE1 {
+			if orientation := exifOrientation(data[i+4 : i+2+size]); orientation != 0 {
+				return orientation
+			}
+		}
+
+		i += 2 + size
+	}
+
+	return 1
+}
+
+func exifOrientation(segment []byte) int {
+	const (
+		exifHeader     = "Exif\x00\x00"
+		orientationTag = 0x0112
+		ifdEntrySize   = 12
+	)
+
+	if len(segment) < len(exifHeader)+8 || string(segment[:len(exifHeader)]) != exifHeader {
+		return 0
+	}
+
+	tiff := segment[len(exifHeader):]
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifdOffset := order.Uint32(tiff[4:])
+	if ifdOffset > uint32(len(tiff)-2) {
+		return 0
+	}
+
+	entriesNum := int(order.Uint16(tiff[ifdOffset:]))
+	for i := 0; i < entriesNum; i++ {
+		entry := int(ifdOffset) + 2 + i*ifdEntrySize
+		if entry+ifdEntrySize > len(tiff) {
+			return 0
+		}
+
+		if order.Uint16(tiff[entry:]) == orientationTag {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/l-orlov/matcha/internal/config"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	// 3x2 image with distinct pixels, transformed images are listed row by row
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.SetRGBA(i%3, i/3, color.RGBA{R: uint8(i), A: 0xFF})
+	}
+
+	tests := []struct {
+		orientation int
+		width       int
+		want        []uint8
+	}{
+		{orientation: 1, width: 3, want: []uint8{0, 1, 2, 3, 4, 5}},
+		{orientation: 2, width: 3, want: []uint8{2, 1, 0, 5, 4, 3}},
+		{orientation: 3, width: 3, want: []uint8{5, 4, 3, 2, 1, 0}},
+		{orientation: 4, width: 3, want: []uint8{3, 4, 5, 0, 1, 2}},
+		{orientation: 5, width: 2, want: []uint8{0, 3, 1, 4, 2, 5}},
+		{orientation: 6, width: 2, want: []uint8{3, 0, 4, 1, 5, 2}},
+		{orientation: 7, width: 2, want: []uint8{5, 2, 4, 1, 3, 0}},
+		{orientation: 8, width: 2, want: []uint8{2, 5, 1, 4, 0, 3}},
+	}
+
+	for _, tt := range tests {
+		dst := applyOrientation(src, tt.orientation)
+		if dst.Bounds().Dx() != tt.width || dst.Bounds().Dy() != 6/tt.width {
+			t.Errorf("orientation %d: got size %v", tt.orientation, dst.Bounds().Size())
+			continue
+		}
+
+		var got []uint8
+		for y := 0; y < dst.Bounds().Dy(); y++ {
+			for x := 0; x < tt.width; x++ {
+				got = append(got, dst.RGBAAt(x, y).R)
+			}
+		}
+
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("orientation %d: got pixels %v, want %v", tt.orientation, got, tt.want)
+		}
+	}
+}
+
+func TestProcessAppliesOrientationAfterResize(t *testing.T) {
+	processor := NewPictureProcessor(config.PictureProcessing{
+		MaxFileSize: 1 << 20,
+		MaxPixels:   1 << 20,
+		MaxSize:     20,
+		JPEGQuality: 90,
+	})
+
+	// landscape picture rotated by EXIF becomes portrait fitting the same square
+	picture, err := processor.Process(bytes.NewReader(jpegWithOrientation(t, 40, 20, 6)))
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+
+	imgConfig, err := jpeg.DecodeConfig(bytes.NewReader(picture.Original))
+	if err != nil {
+		t.Fatalf("DecodeConfig: %v", err)
+	}
+
+	if imgConfig.Width != 10 || imgConfig.Height != 20 {
+		t.Errorf("processed picture is %dx%d, want 10x20", imgConfig.Width, imgConfig.Height)
+	}
+}
+
+// jpegWithOrientation encodes JPEG with EXIF APP1 segment which has only orientation tag.
+func jpegWithOrientation(t *testing.T, width, height int, orientation uint16) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	tiff := []byte("MM\x00\x2A\x00\x00\x00\x08\x00\x01")
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := buf.Bytes()
+
+	return append(append(append([]byte(nil), data[:2]...), app1...), data[2:]...)
+}
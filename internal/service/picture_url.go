@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PictureURLPath is path prefix of signed picture URLs served by application.
+	PictureURLPath = "/pictures/"
+	// PictureURLExpiresParam and PictureURLSignatureParam are query params of signed picture URL.
+	PictureURLExpiresParam   = "expires"
+	PictureURLSignatureParam = "signature"
+	// pictureVersionUnknown is version of pictures stored before content hashes were saved.
+	pictureVersionUnknown = "0"
+)
+
+var ErrNotValidPictureURL = errors.New("picture URL is not valid or expired")
+
+type (
+	PictureURLService struct {
+		cfg    config.PictureURLs
+		log    *logrus.Entry
+		bucket string
+		repo   *repository.Repository
+	}
+)
+
+func NewPictureURLService(
+	cfg config.PictureURLs, log *logrus.Entry, bucket string, repo *repository.Repository,
+) (*PictureURLService, error) {
+	switch cfg.Mode {
+	case "", config.PictureURLModeSigned, config.PictureURLModePresigned:
+	default:
+		return nil, errors.Errorf("unknown picture URLs mode %q", cfg.Mode)
+	}
+
+	if cfg.Expiry.Duration() < time.Second {
+		return nil, errors.New("picture URLs expiry must be at least one second")
+	}
+
+	return &PictureURLService{
+		cfg:    cfg,
+		log:    log,
+		bucket: bucket,
+		repo:   repo,
+	}, nil
+}
+
+// GetPictureURL returns URL of picture object with content hash. Errors are only logged,
+// so picture without URL does not fail whole profile.
+func (s *PictureURLService) GetPictureURL(ctx context.Context, path, contentHash string) string {
+	var pictureURL string
+	var err error
+	if s.cfg.Mode == config.PictureURLModePresigned {
+		pictureURL, err = s.getPresignedURL(ctx, path, contentHash)
+	} else {
+		pictureURL = s.getSignedURL(path, contentHash)
+	}
+
+	if err != nil {
+		s.log.Errorf("failed to get picture URL by path %s: %v", path, err)
+		return ""
+	}
+
+	return pictureURL
+}
+
+// GetSignedPicture checks signature of picture URL and returns picture object. It returns nil if object is not found.
+func (s *PictureURLService) GetSignedPicture(
+	ctx context.Context, request models.SignedPictureRequest,
+) ([]byte, error) {
+	if time.Now().Unix() > request.Expires || !hmac.Equal(
+		[]byte(request.Signature), []byte(s.sign(request.Path, request.Version, request.Expires)),
+	) {
+		return nil, ErrNotValidPictureURL
+	}
+
+	info, err := s.repo.Storage.GetFileInfo(ctx, s.bucket, request.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		return nil, nil
+	}
+
+	return s.repo.Storage.GetFile(ctx, s.bucket, request.Path)
+}
+
+// getSignedURL returns URL served by application. Its expiry is rounded up to the end of the next
+// expiry window, so URL is the same for all requests within window and valid for at least expiry.
+func (s *PictureURLService) getSignedURL(path, contentHash string) string {
+	version := contentHash
+	if version == "" {
+		version = pictureVersionUnknown
+	}
+
+	window := int64(s.cfg.Expiry.Duration().Seconds())
+	expires := (time.Now().Unix()/window + 2) * window
+
+	query := url.Values{}
+	query.Set(PictureURLExpiresParam, strconv.FormatInt(expires, 10))
+	query.Set(PictureURLSignatureParam, s.sign(path, version, expires))
+
+	u := url.URL{Path: PictureURLPath + version + "/" + path}
+
+	return strings.TrimSuffix(s.cfg.BaseURL, "/") + u.EscapedPath() + "?" + query.Encode()
+}
+
+// getPresignedURL returns storage presigned URL cached until cache margin before it expires.
+// Cache key contains content hash, so replaced picture gets new URL.
+func (s *PictureURLService) getPresignedURL(ctx context.Context, path, contentHash string) (string, error) {
+	key := s.bucket + "/" + path + "@" + contentHash
+
+	cached, err := s.repo.PictureURLCache.GetPictureURL(key)
+	if err != nil {
+		s.log.Errorf("failed to get cached picture URL %s: %v", key, err)
+	} else if cached != "" {
+		return cached, nil
+	}
+
+	expiry := s.cfg.Expiry.Duration()
+	pictureURL, err := s.repo.Storage.GetFileURL(ctx, s.bucket, path, expiry)
+	if err != nil {
+		return "", err
+	}
+
+	if lifetime := expiry - s.cfg.CacheMargin.Duration(); lifetime > 0 {
+		if err := s.repo.PictureURLCache.PutPictureURL(key, pictureURL, lifetime); err != nil {
+			s.log.Errorf("failed to cache picture URL %s: %v", key, err)
+		}
+	}
+
+	return pictureURL, nil
+}
+
+func (s *PictureURLService) sign(path, version string, expires int64) string {
+	mac := hmac.New(sha256.New, s.cfg.SignKey)
+	mac.Write([]byte(path + "\n" + version + "\n" + strconv.FormatInt(expires, 10)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
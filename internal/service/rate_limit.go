@@ -0,0 +1,105 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// memoryRateLimiterSweepInterval is how often keys with expired requests are removed.
+const memoryRateLimiterSweepInterval = time.Minute
+
+type (
+	RateLimitService struct {
+		log    *logrus.Entry
+		repo   repository.RateLimitCache
+		memory *memoryRateLimiter
+	}
+	// memoryRateLimiter is sliding window log limiter used when redis is not available.
+	// It limits requests per instance only.
+	memoryRateLimiter struct {
+		mu        sync.Mutex
+		windows   map[string]*memoryRateLimitWindow
+		lastSweep time.Time
+	}
+	memoryRateLimitWindow struct {
+		window   time.Duration
+		requests []time.Time
+	}
+)
+
+func NewRateLimitService(log *logrus.Entry, repo repository.RateLimitCache) *RateLimitService {
+	return &RateLimitService{
+		log:  log,
+		repo: repo,
+		memory: &memoryRateLimiter{
+			windows:   make(map[string]*memoryRateLimitWindow),
+			lastSweep: time.Now(),
+		},
+	}
+}
+
+// AllowRequest registers request by key and checks that limit in window is not exceeded.
+// If request is not allowed it returns time after which request can be retried.
+func (s *RateLimitService) AllowRequest(key string, limit int, window time.Duration) (bool, time.Duration) {
+	retryAfter, err := s.repo.AddRequest(key, limit, window)
+	if err != nil {
+		s.log.Warnf("failed to check rate limit in cache, using in-memory limiter: %v", err)
+		retryAfter = s.memory.addRequest(key, limit, window, time.Now())
+	}
+
+	return retryAfter == 0, retryAfter
+}
+
+func (l *memoryRateLimiter) addRequest(key string, limit int, window time.Duration, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= memoryRateLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	w, ok := l.windows[key]
+	if !ok {
+		w = &memoryRateLimitWindow{window: window}
+		l.windows[key] = w
+	}
+	w.window = window
+	w.removeExpired(now)
+
+	if len(w.requests) < limit {
+		w.requests = append(w.requests, now)
+		return 0
+	}
+
+	retryAfter := w.requests[0].Add(window).Sub(now)
+	if retryAfter <= 0 {
+		retryAfter = time.Millisecond
+	}
+
+	return retryAfter
+}
+
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	for key, w := range l.windows {
+		if w.removeExpired(now); len(w.requests) == 0 {
+			delete(l.windows, key)
+		}
+	}
+
+	l.lastSweep = now
+}
+
+func (w *memoryRateLimitWindow) removeExpired(now time.Time) {
+	expiredNum := 0
+	for _, requestTime := range w.requests {
+		if now.Sub(requestTime) < w.window {
+			break
+		}
+		expiredNum++
+	}
+
+	w.requests = w.requests[expiredNum:]
+}
@@ -0,0 +1,472 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	seedAvatarSize = 400
+	seedEmailHost  = "example.com"
+	// kilometers in one degree of latitude
+	kmPerDegree = 111.32
+)
+
+type (
+	// SeedService generates fake profiles for load testing and demos.
+	SeedService struct {
+		log           *logrus.Entry
+		pathTemplates config.FilePathTemplates
+		bucket        string
+		repo          *repository.Repository
+		processor     *PictureProcessor
+	}
+	// SeedParams are parameters of fake profiles generation. Profile depends only on Seed and its index,
+	// Offset is index of the first profile. Usernames contain index, so next run must start after previous one.
+	SeedParams struct {
+		Seed       int64
+		Offset     int
+		Count      int
+		BatchSize  int
+		WorkersNum int
+		Cities     []string
+		// Radius is max distance from city center in kilometers.
+		Radius float64
+		// Password is password of all profiles, it is hashed once as bcrypt is too slow for every profile.
+		Password string
+		Avatars  bool
+	}
+	seedCity struct {
+		lat, lon float64
+	}
+	// seedAvatar is silhouette drawn as avatar: head with hair over shoulders on gradient background.
+	seedAvatar struct {
+		backgroundTop, backgroundBottom color.RGBA
+		skin, hair, clothes             color.RGBA
+		headX, headY, headRadius        int
+		hairRadius                      int
+		shouldersWidth, shouldersHeight int
+	}
+)
+
+var seedCities = map[string]seedCity{
+	"moscow":           {lat: 55.7558, lon: 37.6173},
+	"saint-petersburg": {lat: 59.9343, lon: 30.3351},
+	"kazan":            {lat: 55.7887, lon: 49.1221},
+	"novosibirsk":      {lat: 55.0084, lon: 82.9357},
+	"paris":            {lat: 48.8566, lon: 2.3522},
+	"london":           {lat: 51.5074, lon: -0.1278},
+	"berlin":           {lat: 52.5200, lon: 13.4050},
+	"new-york":         {lat: 40.7128, lon: -74.0060},
+}
+
+var (
+	seedMaleNames = []string{
+		"Alexander", "Dmitry", "Maxim", "Ivan", "Artem", "Nikita", "Mikhail", "Egor", "Andrey", "Ilya",
+		"Pierre", "Louis", "Lucas", "Hugo", "James", "Oliver", "Thomas", "Daniel", "Felix", "Paul",
+	}
+	seedFemaleNames = []string{
+		"Anna", "Maria", "Sofia", "Anastasia", "Daria", "Polina", "Elena", "Victoria", "Alisa", "Ksenia",
+		"Camille", "Chloe", "Emma", "Lea", "Olivia", "Amelia", "Charlotte", "Hannah", "Lena", "Mia",
+	}
+	seedLastNames = []string{
+		"Ivanov", "Smirnov", "Kuznetsov", "Popov", "Sokolov", "Lebedev", "Kozlov", "Novikov", "Morozov",
+		"Volkov", "Martin", "Bernard", "Dubois", "Laurent", "Smith", "Brown", "Taylor", "Wilson", "Muller",
+		"Schmidt", "Fischer", "Weber", "Meyer", "Garcia", "Rossi",
+	}
+	seedTags = []string{
+		"travel", "music", "movies", "books", "cooking", "hiking", "yoga", "running", "cycling", "photography",
+		"art", "dancing", "gaming", "coffee", "wine", "dogs", "cats", "football", "swimming", "skiing",
+		"theatre", "jazz", "rock", "science", "startups", "languages", "climbing", "surfing", "chess", "vegan",
+	}
+	seedBiographySentences = []string{
+		"I love long walks in the old town.",
+		"Coffee first, then everything else.",
+		"Looking for someone to explore new places with.",
+		"Weekend hiker and amateur photographer.",
+		"I can cook a decent risotto.",
+		"Books, board games and good conversations.",
+		"Always planning the next trip.",
+		"Dog person, but cats like me too.",
+		"Trying to learn my third language.",
+		"Concerts and festivals are my thing.",
+		"I work in tech and garden on weekends.",
+		"Sunsets are better with company.",
+	}
+	seedBackgrounds = []color.RGBA{
+		{R: 0xf6, G: 0xd5, B: 0xe4, A: 0xff}, {R: 0xd4, G: 0xe6, B: 0xf7, A: 0xff},
+		{R: 0xd8, G: 0xf0, B: 0xd5, A: 0xff}, {R: 0xfa, G: 0xeb, B: 0xc8, A: 0xff},
+		{R: 0xe3, G: 0xd9, B: 0xf5, A: 0xff}, {R: 0xcf, G: 0xef, B: 0xec, A: 0xff},
+	}
+	seedSkinTones = []color.RGBA{
+		{R: 0xf3, G: 0xd3, B: 0xb8, A: 0xff}, {R: 0xe8, G: 0xbe, B: 0x9b, A: 0xff},
+		{R: 0xc9, G: 0x95, B: 0x6c, A: 0xff}, {R: 0x8d, G: 0x5a, B: 0x3b, A: 0xff},
+	}
+	seedHairColors = []color.RGBA{
+		{R: 0x2b, G: 0x1d, B: 0x14, A: 0xff}, {R: 0x5a, G: 0x38, B: 0x25, A: 0xff},
+		{R: 0xa6, G: 0x74, B: 0x3c, A: 0xff}, {R: 0xe0, G: 0xc0, B: 0x7a, A: 0xff},
+		{R: 0x8c, G: 0x8c, B: 0x8c, A: 0xff},
+	}
+)
+
+func NewSeedService(
+	log *logrus.Entry, pathTemplates config.FilePathTemplates, bucket string,
+	repo *repository.Repository, processor *PictureProcessor,
+) *SeedService {
+	return &SeedService{
+		log:           log,
+		pathTemplates: pathTemplates,
+		bucket:        bucket,
+		repo:          repo,
+		processor:     processor,
+	}
+}
+
+// Seed generates profiles and inserts them by batches with COPY. Avatars of batch are uploaded before
+// it is inserted, storage outbox records of user dirs make reconciler delete them if batch is not inserted.
+func (s *SeedService) Seed(ctx context.Context, params SeedParams) (int, error) {
+	cities, err := checkSeedParams(params)
+	if err != nil {
+		return 0, err
+	}
+
+	passwordHash, err := models.HashPassword(params.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	var seededNum int
+	for seededNum < params.Count {
+		num := params.Count - seededNum
+		if num > params.BatchSize {
+			num = params.BatchSize
+		}
+
+		ids, err := s.repo.Seed.ReserveUserIDs(ctx, num)
+		if err != nil {
+			return seededNum, err
+		}
+
+		users := make([]models.SeedUser, num)
+		avatars := make([]seedAvatar, num)
+		for i := range users {
+			index := params.Offset + seededNum + i
+			rnd := rand.New(rand.NewSource(seedProfileSource(params.Seed, index)))
+
+			users[i] = generateSeedUser(rnd, index, cities, params.Radius)
+			users[i].ID = ids[i]
+			users[i].Password = passwordHash
+			avatars[i] = generateSeedAvatar(rnd)
+		}
+
+		if params.Avatars {
+			err = s.insertSeedUsersWithAvatars(ctx, users, avatars, params.WorkersNum)
+		} else {
+			err = s.repo.Seed.CopyUsers(ctx, users)
+		}
+		if err != nil {
+			return seededNum, err
+		}
+
+		seededNum += num
+		s.log.Infof("seeded %d of %d profiles", seededNum, params.Count)
+	}
+
+	return seededNum, nil
+}
+
+func checkSeedParams(params SeedParams) ([]seedCity, error) {
+	if params.Count <= 0 || params.BatchSize <= 0 || params.WorkersNum <= 0 {
+		return nil, errors.New("count, batch size and workers number must be positive")
+	}
+
+	if params.Offset < 0 {
+		return nil, errors.New("offset must not be negative")
+	}
+
+	if params.Radius <= 0 {
+		return nil, errors.New("radius must be positive")
+	}
+
+	if params.Password == "" {
+		return nil, errors.New("empty password")
+	}
+
+	if len(params.Cities) == 0 {
+		return nil, errors.New("no cities")
+	}
+
+	cities := make([]seedCity, len(params.Cities))
+	for i, name := range params.Cities {
+		city, ok := seedCities[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.Errorf("unknown city %q", name)
+		}
+
+		cities[i] = city
+	}
+
+	return cities, nil
+}
+
+// SeedCities returns names of cities which profiles can be placed around.
+func SeedCities() []string {
+	names := make([]string, 0, len(seedCities))
+	for name := range seedCities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (s *SeedService) insertSeedUsersWithAvatars(
+	ctx context.Context, users []models.SeedUser, avatars []seedAvatar, workersNum int,
+) error {
+	userDirs := make([]string, len(users))
+	for i := range users {
+		userDir, err := prepareFilePath(s.pathTemplates.UserDir, map[string]interface{}{"UserID": users[i].ID})
+		if err != nil {
+			return err
+		}
+
+		// empty prefix would match files of all users
+		if userDir == "" {
+			return errors.New("empty user files prefix")
+		}
+
+		userDirs[i] = userDir
+	}
+
+	if err := s.repo.StorageOutbox.AddStorageOutboxRecords(ctx, userDirs); err != nil {
+		return err
+	}
+
+	if err := s.putSeedAvatars(ctx, users, avatars, workersNum); err != nil {
+		return err
+	}
+
+	return s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Seed.CopyUsers(ctx, users); err != nil {
+			return err
+		}
+
+		return s.repo.StorageOutbox.DeleteStorageOutboxRecords(ctx, userDirs)
+	})
+}
+
+// putSeedAvatars draws and uploads avatars by workers. It stops on the first error.
+func (s *SeedService) putSeedAvatars(
+	ctx context.Context, users []models.SeedUser, avatars []seedAvatar, workersNum int,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indexes := make(chan int)
+	// every worker sends not more than one error
+	errs := make(chan error, workersNum)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workersNum; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range indexes {
+				if err := s.putSeedAvatar(ctx, &users[index], avatars[index]); err != nil {
+					errs <- errors.Wrapf(err, "failed to put avatar of user %d", users[index].ID)
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+loop:
+	for i := range users {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	close(indexes)
+	wg.Wait()
+	close(errs)
+
+	if err, ok := <-errs; ok {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+func (s *SeedService) putSeedAvatar(ctx context.Context, user *models.SeedUser, avatar seedAvatar) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, avatar.draw()); err != nil {
+		return err
+	}
+
+	// avatar is processed as uploaded one, so it has the same variants and hashes
+	picture, err := s.processor.Process(&buf)
+	if err != nil {
+		return err
+	}
+
+	path, err := prepareFilePath(s.pathTemplates.UserAvatar, map[string]interface{}{
+		"UserID": user.ID,
+		"UUID":   uuid.New(),
+	})
+	if err != nil {
+		return err
+	}
+
+	variants, err := putProcessedPicture(ctx, s.repo.Storage, s.bucket, path, picture)
+	if err != nil {
+		return err
+	}
+
+	user.AvatarPath = path
+	user.AvatarVariants = variants
+	user.AvatarContentHash = picture.ContentHash
+	user.AvatarPerceptualHash = int64(picture.PerceptualHash)
+
+	return nil
+}
+
+// seedProfileSource mixes seed with profile index by splitmix64, so profiles do not depend on batch size.
+func seedProfileSource(seed int64, index int) int64 {
+	x := uint64(seed) + uint64(index)*0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+
+	return int64(x ^ (x >> 31))
+}
+
+func generateSeedUser(rnd *rand.Rand, index int, cities []seedCity, radius float64) models.SeedUser {
+	var user models.SeedUser
+
+	user.Gender = models.GenderMale
+	firstNames := seedMaleNames
+	if rnd.Intn(2) == 0 {
+		user.Gender = models.GenderFemale
+		firstNames = seedFemaleNames
+	}
+
+	switch n := rnd.Intn(100); {
+	case n < 70:
+		user.SexualPreferences = models.SexualPreferencesHeterosexual
+	case n < 85:
+		user.SexualPreferences = models.SexualPreferencesHomosexual
+	default:
+		user.SexualPreferences = models.SexualPreferencesBisexual
+	}
+
+	user.FirstName = firstNames[rnd.Intn(len(firstNames))]
+	user.LastName = seedLastNames[rnd.Intn(len(seedLastNames))]
+	user.Username = strings.ToLower(fmt.Sprintf("%s.%s.%d", user.FirstName, user.LastName, index))
+	user.Email = user.Username + "@" + seedEmailHost
+	user.IsEmailConfirmed = true
+
+	sentences := rnd.Perm(len(seedBiographySentences))[:1+rnd.Intn(3)]
+	biography := make([]string, len(sentences))
+	for i, n := range sentences {
+		biography[i] = seedBiographySentences[n]
+	}
+	user.Biography = strings.Join(biography, " ")
+
+	tags := rnd.Perm(len(seedTags))[:2+rnd.Intn(5)]
+	user.Tags = make([]string, len(tags))
+	for i, n := range tags {
+		user.Tags[i] = seedTags[n]
+	}
+
+	// point is uniformly distributed over circle around city
+	city := cities[rnd.Intn(len(cities))]
+	distance := radius * math.Sqrt(rnd.Float64())
+	angle := 2 * math.Pi * rnd.Float64()
+	lat := city.lat + distance*math.Cos(angle)/kmPerDegree
+	lon := city.lon + distance*math.Sin(angle)/(kmPerDegree*math.Cos(city.lat*math.Pi/180))
+	user.GPSPosition = fmt.Sprintf("%.6f,%.6f", lat, lon)
+
+	user.ViewsNum = rnd.Intn(2000)
+	user.LikesNum = rnd.Intn(user.ViewsNum/5 + 1)
+
+	return user
+}
+
+func generateSeedAvatar(rnd *rand.Rand) seedAvatar {
+	background := seedBackgrounds[rnd.Intn(len(seedBackgrounds))]
+
+	return seedAvatar{
+		backgroundTop:    background,
+		backgroundBottom: seedBackgrounds[rnd.Intn(len(seedBackgrounds))],
+		skin:             seedSkinTones[rnd.Intn(len(seedSkinTones))],
+		hair:             seedHairColors[rnd.Intn(len(seedHairColors))],
+		clothes: color.RGBA{
+			R: uint8(rnd.Intn(200)), G: uint8(rnd.Intn(200)), B: uint8(rnd.Intn(200)), A: 0xff,
+		},
+		headX:           seedAvatarSize/2 - 30 + rnd.Intn(61),
+		headY:           seedAvatarSize*2/5 - 20 + rnd.Intn(41),
+		headRadius:      65 + rnd.Intn(30),
+		hairRadius:      5 + rnd.Intn(20),
+		shouldersWidth:  140 + rnd.Intn(50),
+		shouldersHeight: 100 + rnd.Intn(50),
+	}
+}
+
+func (a seedAvatar) draw() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, seedAvatarSize, seedAvatarSize))
+
+	// shoulders start a bit above chin
+	shouldersX, shouldersY := a.headX, a.headY+a.headRadius*6/5+a.shouldersHeight*9/10
+	hairX, hairY := a.headX, a.headY-a.hairRadius
+	for y := 0; y < seedAvatarSize; y++ {
+		for x := 0; x < seedAvatarSize; x++ {
+			var c color.RGBA
+			switch {
+			case insideEllipse(x-a.headX, y-a.headY, a.headRadius, a.headRadius+a.headRadius/5):
+				c = a.skin
+			case insideEllipse(x-hairX, y-hairY, a.headRadius+a.hairRadius, a.headRadius+a.hairRadius):
+				c = a.hair
+			case insideEllipse(x-shouldersX, y-shouldersY, a.shouldersWidth, a.shouldersHeight):
+				c = a.clothes
+			default:
+				c = blendColors(a.backgroundTop, a.backgroundBottom, y, seedAvatarSize)
+			}
+
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func insideEllipse(dx, dy, rx, ry int) bool {
+	return float64(dx*dx)/float64(rx*rx)+float64(dy*dy)/float64(ry*ry) <= 1
+}
+
+func blendColors(from, to color.RGBA, step, steps int) color.RGBA {
+	blend := func(a, b uint8) uint8 {
+		return uint8((int(a)*(steps-step) + int(b)*step) / steps)
+	}
+
+	return color.RGBA{R: blend(from.R, to.R), G: blend(from.G, to.G), B: blend(from.B, to.B), A: 0xff}
+}
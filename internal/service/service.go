@@ -3,11 +3,13 @@ package service
 import (
 	"context"
 	"io"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/google/uuid"
 	"github.com/l-orlov/matcha/internal/config"
 	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
 	"github.com/l-orlov/matcha/internal/repository"
 	"github.com/l-orlov/task-tracker/pkg/mailer"
 	"github.com/pkg/errors"
@@ -27,51 +29,147 @@ type (
 	}
 	User interface {
 		CreateUser(ctx context.Context, user models.UserToCreate) (uint64, error)
+		CreateAdmin(ctx context.Context, user models.UserToCreate) (uint64, error)
 		GetUserByID(ctx context.Context, id uint64) (*models.User, error)
 		GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+		GetUserByUsername(ctx context.Context, username string) (*models.User, error)
 		UpdateUser(ctx context.Context, user models.User) error
 		SetUserPassword(ctx context.Context, userID uint64, password string) error
+		ResetUserPassword(ctx context.Context, userID uint64, password string) error
 		ChangeUserPassword(ctx context.Context, userID uint64, oldPassword, newPassword string) error
-		GetAllUsers(ctx context.Context) ([]models.User, error)
+		GetAllUsers(ctx context.Context, req pagination.Request) ([]models.User, string, error)
 		DeleteUser(ctx context.Context, id uint64) error
 		ConfirmEmail(ctx context.Context, id uint64) error
+		SetUserState(ctx context.Context, userID uint64, state string) error
+		ReactivateUser(ctx context.Context, userID uint64) (bool, error)
 	}
 	UserAuthentication interface {
-		AuthenticateUserByUsername(ctx context.Context, username, password, fingerprint string) (userID uint64, err error)
+		AuthenticateUserByUsername(ctx context.Context, username, password, fingerprint, clientIP string) (userID uint64, err error)
+		AuthenticateUserByTwoFactorCode(ctx context.Context, challengeToken, code, fingerprint, clientIP string) (userID uint64, err error)
+		CheckMagicLinkRequest(ctx context.Context, email, fingerprint string) (userID uint64, err error)
+		AuthenticateUserByPasskey(ctx context.Context, sessionID string, response io.Reader, fingerprint string) (userID uint64, err error)
+		AuthenticateUserByPasskeyTwoFactor(ctx context.Context, challengeToken string, response io.Reader, fingerprint string) (userID uint64, err error)
 	}
 	UserAuthorization interface {
-		CreateSession(userID string) (accessToken, refreshToken string, err error)
-		ValidateAccessToken(accessToken string) (*jwt.StandardClaims, error)
-		RefreshSession(currentRefreshToken string) (accessToken, refreshToken string, err error)
+		CreateSession(ctx context.Context, userID uint64) (accessToken, refreshToken string, err error)
+		ValidateAccessToken(accessToken string) (*models.AccessTokenClaims, error)
+		RefreshSession(ctx context.Context, currentRefreshToken string) (accessToken, refreshToken string, err error)
 		RevokeSession(accessToken string) error
-		GetAccessTokenClaims(accessToken string) (*jwt.StandardClaims, error)
+		GetAccessTokenClaims(accessToken string) (*models.AccessTokenClaims, error)
 	}
 	Verification interface {
 		CreateEmailConfirmToken(userID uint64) (string, error)
 		VerifyEmailConfirmToken(emailConfirmToken string) (userID uint64, err error)
 		CreatePasswordResetConfirmToken(userID uint64) (string, error)
 		VerifyPasswordResetConfirmToken(confirmToken string) (userID uint64, err error)
+		CreateTwoFactorChallengeToken(userID uint64) (string, error)
+		CreateMagicLinkToken(userID uint64) (string, error)
+		VerifyMagicLinkToken(magicLinkToken string) (userID uint64, err error)
+		CreateLoginAlertToken(userID uint64) (string, error)
+		VerifyLoginAlertToken(loginAlertToken string) (userID uint64, err error)
+	}
+	TwoFactor interface {
+		EnrollTwoFactor(ctx context.Context, userID uint64) (*models.TwoFactorEnrollment, error)
+		EnableTwoFactor(ctx context.Context, userID uint64, code string) (recoveryCodes []string, err error)
+		DisableTwoFactor(ctx context.Context, userID uint64, password string) error
+		GenerateRecoveryCodes(ctx context.Context, userID uint64) ([]string, error)
+		IsTwoFactorEnabled(ctx context.Context, userID uint64) (bool, error)
+		ValidateTwoFactorCode(ctx context.Context, userID uint64, code string) error
+	}
+	WebAuthn interface {
+		BeginPasskeyRegistration(ctx context.Context, userID uint64) (*protocol.CredentialCreation, error)
+		FinishPasskeyRegistration(ctx context.Context, userID uint64, response io.Reader) error
+		GetPasskeys(
+			ctx context.Context, userID uint64, req pagination.Request,
+		) ([]models.WebAuthnCredential, string, error)
+		DeletePasskey(ctx context.Context, userID uint64, credentialID []byte) error
+		HasPasskeys(ctx context.Context, userID uint64) (bool, error)
+		BeginPasskeyLogin(ctx context.Context, username string) (options *protocol.CredentialAssertion, sessionID string, err error)
+		FinishPasskeyLogin(ctx context.Context, sessionID string, response io.Reader) (userID uint64, err error)
+		BeginPasskeyTwoFactor(ctx context.Context, challengeToken string) (*protocol.CredentialAssertion, error)
+		FinishPasskeyTwoFactor(ctx context.Context, challengeToken string, response io.Reader) (userID uint64, err error)
+	}
+	OAuth interface {
+		GetOAuthLoginURL(ctx context.Context, providerName string) (loginURL, state string, err error)
+		AuthenticateUserByOAuth(
+			ctx context.Context, providerName, state, browserState, code string,
+		) (userID uint64, err error)
+	}
+	AccountDeletion interface {
+		ScheduleAccountDeletion(ctx context.Context, userID uint64, password string) (deleteAt time.Time, err error)
+		PurgeAccount(ctx context.Context, userID uint64) error
+		PurgeDeletedAccounts(ctx context.Context) (purgedNum int, err error)
+	}
+	StorageReconciliation interface {
+		ReconcileStorage(ctx context.Context) (fixedNum int, err error)
+	}
+	DataExport interface {
+		RequestDataExport(ctx context.Context, userID uint64) error
+		ProcessDataExportRequest(ctx context.Context) (processed bool, err error)
+	}
+	LoginAlert interface {
+		RegisterLogin(ctx context.Context, userID uint64, info models.LoginInfo) error
+		RevokeAccessByLoginAlert(ctx context.Context, loginAlertToken string) (userID uint64, err error)
+	}
+	RateLimit interface {
+		AllowRequest(key string, limit int, window time.Duration) (allowed bool, retryAfter time.Duration)
 	}
 	Mailer interface {
 		SendEmailConfirm(toEmail, token string)
 		SendResetPasswordConfirm(toEmail, token string)
+		SendMagicLink(toEmail, token string)
+		SendLoginAlert(toEmail string, info models.LoginInfo, token string)
+		SendDataExport(toEmail, url string)
+	}
+	PictureModeration interface {
+		GetPendingUserPictures(ctx context.Context, req pagination.Request) ([]models.UserPicture, string, error)
+		ApproveUserPicture(ctx context.Context, uuid uuid.UUID) error
+		RejectUserPicture(ctx context.Context, uuid uuid.UUID) error
+		GetPendingUserAvatars(ctx context.Context, req pagination.Request) ([]models.UserAvatar, string, error)
+		ApproveUserAvatar(ctx context.Context, review models.UserAvatarReview) error
+		RejectUserAvatar(ctx context.Context, review models.UserAvatarReview) error
+		GetPictureMatches(ctx context.Context, req pagination.Request) ([]models.PictureMatch, string, error)
+		DismissPictureMatch(ctx context.Context, id uint64) error
+	}
+	PictureURL interface {
+		GetPictureURL(ctx context.Context, path, contentHash string) string
+		GetSignedPicture(ctx context.Context, request models.SignedPictureRequest) ([]byte, error)
 	}
 	UserProfile interface {
-		GetUserProfileByID(ctx context.Context, id uint64) (*models.UserProfile, error)
+		GetUserProfileByID(ctx context.Context, viewerID, id uint64) (*models.UserProfile, error)
 		UpdateUserProfile(ctx context.Context, user models.UserProfile) error
 		UploadUserAvatar(ctx context.Context, userID uint64, file io.ReadSeeker) error
 		DeleteUserAvatar(ctx context.Context, userID uint64) error
-		UploadUserPicture(ctx context.Context, userID uint64, file io.ReadSeeker) error
-		GetUserPicturesByUserID(ctx context.Context, userID uint64) ([]models.UserPicture, error)
-		DeleteUserPicture(ctx context.Context, uuid uuid.UUID) error
+		UploadUserPicture(ctx context.Context, userID uint64, file io.ReadSeeker, caption string) error
+		CreatePictureUploadURL(
+			ctx context.Context, userID uint64, request models.PictureUploadRequest,
+		) (*models.PictureUpload, error)
+		FinalizePictureUpload(ctx context.Context, userID uint64, finalization models.PictureUploadFinalization) error
+		GetUserPicturesByUserID(
+			ctx context.Context, userID uint64, req pagination.Request,
+		) ([]models.UserPicture, string, error)
+		ReorderUserPictures(ctx context.Context, userID uint64, uuids []uuid.UUID) error
+		UpdateUserPictureCaption(ctx context.Context, userID uint64, uuid uuid.UUID, caption string) error
+		PromoteUserPictureToAvatar(ctx context.Context, userID uint64, uuid uuid.UUID) error
+		DeleteUserPicture(ctx context.Context, userID uint64, uuid uuid.UUID) error
 	}
 	Service struct {
 		User
 		UserAuthentication
 		UserAuthorization
 		Verification
+		TwoFactor
+		WebAuthn
+		OAuth
+		AccountDeletion
+		DataExport
+		StorageReconciliation
+		LoginAlert
+		RateLimit
 		Mailer
 		UserProfile
+		PictureModeration
+		PictureURL
 	}
 )
 
@@ -90,21 +188,85 @@ func NewService(
 		return nil, errors.Wrap(err, "failed to create random symbols generator")
 	}
 
+	paginator := pagination.New(cfg.Pagination)
+
+	twoFactor, err := NewTwoFactorService(cfg.TwoFactor, repo, generator)
+	if err != nil {
+		return nil, err
+	}
+
+	webAuthnLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "webauthn-svc"})
+	webAuthn, err := NewWebAuthnService(cfg.WebAuthn, webAuthnLogEntry, repo, paginator)
+	if err != nil {
+		return nil, err
+	}
+
 	authenticationLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "authentication-svc"})
 	verificationLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "verification-svc"})
 	profileLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "user-profile-svc"})
+	oauthLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "oauth-svc"})
+	accountDeletionLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "account-deletion-svc"})
+	dataExportLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "data-export-svc"})
+	loginAlertLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "login-alert-svc"})
+	rateLimitLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "rate-limit-svc"})
+	reconciliationLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "storage-reconciliation-svc"})
+	pictureURLLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "picture-url-svc"})
+	moderatorLogEntry := logrus.NewEntry(log).WithFields(logrus.Fields{"source": "picture-moderator"})
 
 	mailerCfg := MailerServiceConfig{
 		From:      cfg.Mailer.Username,
 		AppDomain: cfg.Mailer.AppDomain,
 	}
 
+	verification := NewVerificationService(verificationLogEntry, repo.VerificationCache, generator)
+	mailerSvc := NewMailerService(mailerCfg, mailer)
+
+	accountDeletion := NewAccountDeletionService(
+		cfg.AccountDeletion, accountDeletionLogEntry, cfg.FilePathTemplates.UserDir, cfg.Storage.Bucket, repo,
+	)
+	pictureProcessor := NewPictureProcessor(cfg.PictureProcessing)
+	pictureURL, err := NewPictureURLService(cfg.PictureURLs, pictureURLLogEntry, cfg.Storage.Bucket, repo)
+	if err != nil {
+		return nil, err
+	}
+	pictureModerator, err := NewPictureModerator(cfg.PictureModeration, moderatorLogEntry)
+	if err != nil {
+		return nil, err
+	}
+	userProfile := NewUserProfileService(
+		profileLogEntry, cfg.MaxUserPicturesNum, cfg.FilePathTemplates, cfg.PictureUpload, cfg.Storage.Bucket,
+		repo, pictureProcessor, pictureURL, pictureModerator, cfg.PictureDuplicates, paginator,
+	)
+	storageReconciliation, err := NewStorageReconciliationService(
+		cfg.StorageReconciliation, reconciliationLogEntry, cfg.FilePathTemplates.UserPicture, cfg.Storage.Bucket,
+		repo, pictureProcessor,
+	)
+	if err != nil {
+		return nil, err
+	}
+	user := NewUserService(
+		repo.User, repo.TxManager, repo.SessionCache, cfg.JWT.AccessTokenLifetime.Duration(), paginator,
+	)
+	dataExport := NewDataExportService(
+		cfg.DataExport, dataExportLogEntry, cfg.FilePathTemplates, cfg.Storage.Bucket, repo, mailerSvc,
+	)
+
 	return &Service{
-		User:               NewUserService(repo.User, cfg.JWT.AccessTokenLifetime.Duration()),
-		UserAuthentication: NewAuthenticationService(cfg, authenticationLogEntry, repo),
-		UserAuthorization:  NewAuthorizationService(cfg, repo),
-		Verification:       NewVerificationService(verificationLogEntry, repo.VerificationCache, generator),
-		Mailer:             NewMailerService(mailerCfg, mailer),
-		UserProfile:        NewUserProfileService(profileLogEntry, cfg.MaxUserPicturesNum, cfg.FilePathTemplates, repo),
+		User:                  user,
+		UserAuthentication:    NewAuthenticationService(cfg, authenticationLogEntry, repo, twoFactor, webAuthn),
+		UserAuthorization:     NewAuthorizationService(cfg, repo),
+		Verification:          verification,
+		TwoFactor:             twoFactor,
+		WebAuthn:              webAuthn,
+		OAuth:                 NewOAuthService(cfg.OAuth, oauthLogEntry, repo, generator),
+		AccountDeletion:       accountDeletion,
+		DataExport:            dataExport,
+		StorageReconciliation: storageReconciliation,
+		LoginAlert:            NewLoginAlertService(loginAlertLogEntry, repo, verification, mailerSvc, generator),
+		RateLimit:             NewRateLimitService(rateLimitLogEntry, repo.RateLimitCache),
+		Mailer:                mailerSvc,
+		UserProfile:           userProfile,
+		PictureModeration:     userProfile,
+		PictureURL:            pictureURL,
 	}, nil
 }
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	pathTemplateUserIDMarker = "\x00userID\x00"
+	pathTemplateUUIDMarker   = "\x00uuid\x00"
+)
+
+type (
+	StorageReconciliationService struct {
+		cfg               config.StorageReconciliation
+		log               *logrus.Entry
+		picturesPrefix    string
+		picturePathRegexp *regexp.Regexp
+		bucket            string
+		repo              *repository.Repository
+		processor         *PictureProcessor
+	}
+	// StorageReconciler periodically repairs mismatches between storage objects and database.
+	StorageReconciler struct {
+		log      *logrus.Entry
+		svc      StorageReconciliation
+		interval time.Duration
+		done     chan struct{}
+		wg       sync.WaitGroup
+	}
+	// storedPicture is picture objects found in storage by picture path.
+	storedPicture struct {
+		objectNames  map[string]struct{}
+		lastModified time.Time
+	}
+)
+
+func NewStorageReconciliationService(
+	cfg config.StorageReconciliation, log *logrus.Entry, userPicturePathTemplate, bucket string,
+	repo *repository.Repository, processor *PictureProcessor,
+) (*StorageReconciliationService, error) {
+	picturesPrefix, picturePathRegexp, err := parsePicturePathTemplate(userPicturePathTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse user picture path template")
+	}
+
+	return &StorageReconciliationService{
+		cfg:               cfg,
+		log:               log,
+		picturesPrefix:    picturesPrefix,
+		picturePathRegexp: picturePathRegexp,
+		bucket:            bucket,
+		repo:              repo,
+		processor:         processor,
+	}, nil
+}
+
+// ReconcileStorage deletes objects of expired storage outbox records and orphaned picture objects,
+// deletes picture rows which objects are missing and restores missing picture variants.
+func (s *StorageReconciliationService) ReconcileStorage(ctx context.Context) (int, error) {
+	fixedNum, err := s.processStorageOutbox(ctx)
+	if err != nil {
+		return fixedNum, errors.Wrap(err, "failed to process storage outbox")
+	}
+
+	listedAt := time.Now()
+	objects, err := s.repo.Storage.ListFiles(ctx, s.bucket, s.picturesPrefix)
+	if err != nil {
+		return fixedNum, errors.Wrap(err, "failed to list picture objects")
+	}
+
+	storedPictures := s.groupPictureObjects(objects)
+
+	num, err := s.deleteOrphanedPictureObjects(ctx, storedPictures)
+	fixedNum += num
+	if err != nil {
+		return fixedNum, errors.Wrap(err, "failed to delete orphaned picture objects")
+	}
+
+	num, err = s.repairPictureRows(ctx, storedPictures, listedAt)
+	fixedNum += num
+	if err != nil {
+		return fixedNum, errors.Wrap(err, "failed to repair picture rows")
+	}
+
+	return fixedNum, nil
+}
+
+// processStorageOutbox deletes objects of one batch of outbox records which grace period is over.
+func (s *StorageReconciliationService) processStorageOutbox(ctx context.Context) (int, error) {
+	before := time.Now().Add(-s.cfg.GracePeriod.Duration())
+	prefixes, err := s.repo.StorageOutbox.GetStorageOutboxRecords(ctx, before, s.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var deletedNum int
+	for _, prefix := range prefixes {
+		// empty prefix would match all files
+		if prefix != "" {
+			if err := s.repo.Storage.DeleteFilesByPrefix(ctx, s.bucket, prefix); err != nil {
+				s.log.Errorf("failed to delete objects of storage outbox record %s: %v", prefix, err)
+				continue
+			}
+		}
+
+		if err := s.repo.StorageOutbox.DeleteStorageOutboxRecord(ctx, prefix); err != nil {
+			s.log.Errorf("failed to delete storage outbox record %s: %v", prefix, err)
+			continue
+		}
+		deletedNum++
+	}
+
+	return deletedNum, nil
+}
+
+// groupPictureObjects groups picture objects with their variants by picture path.
+func (s *StorageReconciliationService) groupPictureObjects(objects []models.StorageObject) map[string]*storedPicture {
+	storedPictures := make(map[string]*storedPicture)
+	for _, object := range objects {
+		match := s.picturePathRegexp.FindStringSubmatch(object.Name)
+		if match == nil {
+			continue
+		}
+
+		picture, ok := storedPictures[match[1]]
+		if !ok {
+			picture = &storedPicture{objectNames: make(map[string]struct{})}
+			storedPictures[match[1]] = picture
+		}
+
+		picture.objectNames[object.Name] = struct{}{}
+		if object.LastModified.After(picture.lastModified) {
+			picture.lastModified = object.LastModified
+		}
+	}
+
+	return storedPictures
+}
+
+// deleteOrphanedPictureObjects deletes objects of pictures which have no rows.
+func (s *StorageReconciliationService) deleteOrphanedPictureObjects(
+	ctx context.Context, storedPictures map[string]*storedPicture,
+) (int, error) {
+	before := time.Now().Add(-s.cfg.GracePeriod.Duration())
+
+	paths := make([]string, 0, len(storedPictures))
+	for path, picture := range storedPictures {
+		if picture.lastModified.Before(before) {
+			paths = append(paths, path)
+		}
+	}
+
+	var deletedNum int
+	for start := 0; start < len(paths); start += s.cfg.BatchSize {
+		end := start + s.cfg.BatchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+
+		pictures, err := s.repo.UserPictures.GetUserPicturesByPaths(ctx, paths[start:end])
+		if err != nil {
+			return deletedNum, err
+		}
+
+		existing := make(map[string]struct{}, len(pictures))
+		for i := range pictures {
+			existing[pictures[i].PicturePath] = struct{}{}
+		}
+
+		for _, path := range paths[start:end] {
+			if _, ok := existing[path]; ok {
+				continue
+			}
+
+			if err := s.repo.Storage.DeleteFilesByPrefix(ctx, s.bucket, path); err != nil {
+				s.log.Errorf("failed to delete orphaned picture %s: %v", path, err)
+				continue
+			}
+			deletedNum++
+		}
+	}
+
+	return deletedNum, nil
+}
+
+// repairPictureRows deletes rows of pictures without objects and restores missing variants.
+// Rows created after objects were listed are skipped.
+func (s *StorageReconciliationService) repairPictureRows(
+	ctx context.Context, storedPictures map[string]*storedPicture, listedAt time.Time,
+) (int, error) {
+	var repairedNum int
+	var after uuid.UUID
+	for {
+		pictures, err := s.repo.UserPictures.GetUserPicturesAfterUUID(ctx, after, s.cfg.BatchSize)
+		if err != nil {
+			return repairedNum, err
+		}
+
+		for i := range pictures {
+			if pictures[i].CreatedAt.After(listedAt) {
+				continue
+			}
+
+			repaired, err := s.repairPictureRow(ctx, pictures[i], storedPictures[pictures[i].PicturePath])
+			if err != nil {
+				s.log.Errorf("failed to repair picture %s: %v", pictures[i].UUID, err)
+				continue
+			}
+
+			if repaired {
+				repairedNum++
+			}
+		}
+
+		if len(pictures) < s.cfg.BatchSize {
+			return repairedNum, nil
+		}
+
+		after = pictures[len(pictures)-1].UUID
+	}
+}
+
+func (s *StorageReconciliationService) repairPictureRow(
+	ctx context.Context, picture models.UserPicture, stored *storedPicture,
+) (bool, error) {
+	if stored == nil {
+		stored = &storedPicture{}
+	}
+
+	if _, ok := stored.objectNames[picture.PicturePath]; !ok {
+		// original can not be restored, so picture is deleted with remaining variants
+		if err := s.repo.UserPictures.DeleteUserPicture(ctx, picture.UUID); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	var missingNames []string
+	for _, name := range s.processor.VariantNames() {
+		variantPath, ok := picture.Variants[name]
+		if !ok {
+			missingNames = append(missingNames, name)
+			continue
+		}
+
+		if _, ok := stored.objectNames[variantPath]; !ok {
+			missingNames = append(missingNames, name)
+		}
+	}
+
+	if len(missingNames) == 0 {
+		return false, nil
+	}
+
+	data, err := s.repo.Storage.GetFile(ctx, s.bucket, picture.PicturePath)
+	if err != nil {
+		return false, err
+	}
+
+	processed, err := s.processor.Process(bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+
+	variants := make(models.PictureVariants, len(picture.Variants)+len(missingNames))
+	for name, variantPath := range picture.Variants {
+		variants[name] = variantPath
+	}
+
+	for _, name := range missingNames {
+		variantPath := picture.PicturePath + "_" + name
+		if err := s.repo.Storage.PutFile(
+			ctx, s.bucket, variantPath, pictureContentType, bytes.NewReader(processed.Variants[name]),
+		); err != nil {
+			return false, err
+		}
+
+		variants[name] = variantPath
+	}
+
+	if err := s.repo.UserPictures.UpdateUserPictureVariants(ctx, picture.UUID, variants); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// parsePicturePathTemplate returns static prefix of picture paths and regexp which matches
+// picture objects with variants. The first regexp group is picture path.
+func parsePicturePathTemplate(pathTemplate string) (string, *regexp.Regexp, error) {
+	path, err := prepareFilePath(pathTemplate, map[string]interface{}{
+		"UserID": pathTemplateUserIDMarker,
+		"UUID":   pathTemplateUUIDMarker,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !strings.Contains(path, pathTemplateUUIDMarker) {
+		return "", nil, errors.New("path template must contain picture uuid")
+	}
+
+	prefix := path[:strings.Index(path, "\x00")]
+
+	pattern := regexp.QuoteMeta(path)
+	pattern = strings.ReplaceAll(pattern, pathTemplateUserIDMarker, `\d+`)
+	pattern = strings.ReplaceAll(pattern, pathTemplateUUIDMarker,
+		`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+	re, err := regexp.Compile("^(" + pattern + ")(?:_[^/]+)?$")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return prefix, re, nil
+}
+
+func NewStorageReconciler(log *logrus.Entry, svc StorageReconciliation, interval time.Duration) *StorageReconciler {
+	return &StorageReconciler{
+		log:      log,
+		svc:      svc,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+func (r *StorageReconciler) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-ticker.C:
+				r.reconcile()
+			}
+		}
+	}()
+}
+
+func (r *StorageReconciler) Shutdown() {
+	close(r.done)
+	r.wg.Wait()
+}
+
+func (r *StorageReconciler) reconcile() {
+	fixedNum, err := r.svc.ReconcileStorage(context.Background())
+	if err != nil {
+		r.log.Errorf("failed to reconcile storage: %v", err)
+	}
+
+	if fixedNum != 0 {
+		r.log.Infof("fixed %d storage mismatches", fixedNum)
+	}
+}
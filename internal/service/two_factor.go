@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/config"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	recoveryCodeLength    = 10
+	recoveryCodeDigitsNum = 4
+
+	// totpPeriod and totpSkew are defaults of authenticator apps, codes of adjacent steps are accepted for clock drift
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+var (
+	ErrTwoFactorNotEnrolled       = errors.New("two-factor authentication is not enrolled")
+	ErrTwoFactorNotEnabled        = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorAlreadyEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrWrongTwoFactorCode         = errors.New("wrong two-factor authentication code")
+	ErrTwoFactorChallengeNotFound = errors.New("two-factor authentication challenge not found")
+)
+
+type (
+	TwoFactorService struct {
+		cfg       config.TwoFactor
+		repo      *repository.Repository
+		generator RandomTokenGenerator
+		aead      cipher.AEAD
+	}
+)
+
+func NewTwoFactorService(
+	cfg config.TwoFactor, repo *repository.Repository, generator RandomTokenGenerator,
+) (*TwoFactorService, error) {
+	// AES-256 key is derived from configured key to allow keys of any length
+	key := sha256.Sum256(cfg.EncryptionKey)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create two-factor secret cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create two-factor secret cipher")
+	}
+
+	return &TwoFactorService{
+		cfg:       cfg,
+		repo:      repo,
+		generator: generator,
+		aead:      aead,
+	}, nil
+}
+
+// EnrollTwoFactor generates new TOTP secret for user. It is not used
+// until user confirms it by EnableTwoFactor.
+func (s *TwoFactorService) EnrollTwoFactor(ctx context.Context, userID uint64) (*models.TwoFactorEnrollment, error) {
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, ierrors.NewBusiness(ErrUserNotFound, "")
+	}
+
+	twoFactor, err := s.repo.UserTwoFactor.GetUserTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if twoFactor != nil && twoFactor.IsEnabled {
+		return nil, ierrors.NewBusiness(ErrTwoFactorAlreadyEnabled, "")
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.cfg.Issuer,
+		AccountName: user.Email,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate two-factor secret")
+	}
+
+	encryptedSecret, err := s.encrypt([]byte(key.Secret()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.repo.UserTwoFactor.PutUserTwoFactorSecret(ctx, userID, encryptedSecret); err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorEnrollment{
+		Secret:          key.Secret(),
+		ProvisioningURI: key.URL(),
+	}, nil
+}
+
+// EnableTwoFactor checks code by enrolled secret, enables two-factor authentication
+// and returns new recovery codes.
+func (s *TwoFactorService) EnableTwoFactor(ctx context.Context, userID uint64, code string) ([]string, error) {
+	twoFactor, err := s.repo.UserTwoFactor.GetUserTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if twoFactor == nil {
+		return nil, ierrors.NewBusiness(ErrTwoFactorNotEnrolled, "")
+	}
+
+	if twoFactor.IsEnabled {
+		return nil, ierrors.NewBusiness(ErrTwoFactorAlreadyEnabled, "")
+	}
+
+	isValid, err := s.validateTOTPCode(ctx, twoFactor, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isValid {
+		return nil, ierrors.NewBusiness(ErrWrongTwoFactorCode, "")
+	}
+
+	if err = s.repo.UserTwoFactor.EnableUserTwoFactor(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return s.GenerateRecoveryCodes(ctx, userID)
+}
+
+func (s *TwoFactorService) DisableTwoFactor(ctx context.Context, userID uint64, password string) error {
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return ierrors.NewBusiness(ErrUserNotFound, "")
+	}
+
+	if !models.CheckPasswordHash(user.Password, password) {
+		return ierrors.NewBusiness(ErrWrongPassword, "")
+	}
+
+	return s.repo.UserTwoFactor.DeleteUserTwoFactor(ctx, userID)
+}
+
+// GenerateRecoveryCodes replaces user recovery codes with new ones.
+// Only hashes of codes are stored, so codes can be shown to user only once.
+func (s *TwoFactorService) GenerateRecoveryCodes(ctx context.Context, userID uint64) ([]string, error) {
+	twoFactor, err := s.repo.UserTwoFactor.GetUserTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if twoFactor == nil || !twoFactor.IsEnabled {
+		return nil, ierrors.NewBusiness(ErrTwoFactorNotEnabled, "")
+	}
+
+	codes := make([]string, 0, s.cfg.RecoveryCodesNum)
+	codeHashes := make([]string, 0, s.cfg.RecoveryCodesNum)
+	for i := 0; i < s.cfg.RecoveryCodesNum; i++ {
+		code, err := s.generator.Generate(recoveryCodeLength, recoveryCodeDigitsNum, 0, true, true)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate recovery code")
+		}
+
+		codes = append(codes, code)
+		codeHashes = append(codeHashes, hashRecoveryCode(code))
+	}
+
+	if err = s.repo.UserTwoFactor.UpdateUserRecoveryCodes(ctx, userID, codeHashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *TwoFactorService) IsTwoFactorEnabled(ctx context.Context, userID uint64) (bool, error) {
+	twoFactor, err := s.repo.UserTwoFactor.GetUserTwoFactor(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return twoFactor != nil && twoFactor.IsEnabled, nil
+}
+
+// ValidateTwoFactorCode checks TOTP code or recovery code of user.
+// Every code can be used once: recovery code is deleted and TOTP code step is saved after successful usage.
+func (s *TwoFactorService) ValidateTwoFactorCode(ctx context.Context, userID uint64, code string) error {
+	twoFactor, err := s.repo.UserTwoFactor.GetUserTwoFactor(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if twoFactor == nil || !twoFactor.IsEnabled {
+		return ierrors.NewBusiness(ErrTwoFactorNotEnabled, "")
+	}
+
+	isValid, err := s.validateTOTPCode(ctx, twoFactor, code)
+	if err != nil {
+		return err
+	}
+
+	if isValid {
+		return nil
+	}
+
+	isUsed, err := s.repo.UserTwoFactor.UseUserRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	if !isUsed {
+		return ErrWrongTwoFactorCode
+	}
+
+	return nil
+}
+
+// validateTOTPCode checks code and saves its time step. Code of already accepted step is not valid,
+// so intercepted code can not be replayed while it is not expired.
+func (s *TwoFactorService) validateTOTPCode(
+	ctx context.Context, twoFactor *models.UserTwoFactor, code string,
+) (bool, error) {
+	secret, err := s.decrypt(twoFactor.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := matchTOTPStep(code, string(secret), time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return s.repo.UserTwoFactor.UseUserTOTPStep(ctx, twoFactor.UserID, step)
+}
+
+func (s *TwoFactorService) encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *TwoFactorService) decrypt(ciphertext []byte) ([]byte, error) {
+	nonceSize := s.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("not valid encrypted two-factor secret")
+	}
+
+	plaintext, err := s.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt two-factor secret")
+	}
+
+	return plaintext, nil
+}
+
+// matchTOTPStep returns time step of TOTP code within skew from time.
+func matchTOTPStep(code, secret string, t time.Time) (int64, bool) {
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		isValid, err := totp.ValidateCustom(code, secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && isValid {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
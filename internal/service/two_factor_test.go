@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pquerna/otp/totp"
+	"github.com/sirupsen/logrus"
+)
+
+type fakeUserTwoFactor struct {
+	repository.UserTwoFactor
+	mu        sync.Mutex
+	twoFactor models.UserTwoFactor
+	lastStep  *int64
+}
+
+func (r *fakeUserTwoFactor) GetUserTwoFactor(ctx context.Context, userID uint64) (*models.UserTwoFactor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.twoFactor.UserID != userID {
+		return nil, nil
+	}
+	twoFactor := r.twoFactor
+
+	return &twoFactor, nil
+}
+
+func (r *fakeUserTwoFactor) UseUserRecoveryCode(ctx context.Context, userID uint64, codeHash string) (bool, error) {
+	return false, nil
+}
+
+func (r *fakeUserTwoFactor) UseUserTOTPStep(ctx context.Context, userID uint64, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.lastStep != nil && *r.lastStep >= step {
+		return false, nil
+	}
+	r.lastStep = &step
+
+	return true, nil
+}
+
+func (r *fakeUserTwoFactor) DeleteUserTwoFactor(ctx context.Context, userID uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.twoFactor.UserID == userID {
+		r.twoFactor = models.UserTwoFactor{}
+	}
+
+	return nil
+}
+
+// newTestTwoFactorServices returns services for user with enabled two-factor authentication and its TOTP secret.
+func newTestTwoFactorServices(
+	t *testing.T, maxAttempts int,
+) (*AuthenticationService, *repository.Repository, uint64, string) {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	repo := newFakeRepository()
+
+	userID, err := repo.User.CreateUser(context.Background(), models.UserToCreate{
+		Email:    "alice@example.com",
+		Username: "alice",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		UserBlocking: config.UserBlocking{MaxErrors: 100},
+		TwoFactor: config.TwoFactor{
+			Issuer:               "Matcha",
+			EncryptionKey:        []byte("test"),
+			ChallengeMaxAttempts: maxAttempts,
+		},
+	}
+
+	twoFactorSvc, err := NewTwoFactorService(cfg.TwoFactor, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "Matcha", AccountName: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedSecret, err := twoFactorSvc.encrypt([]byte(key.Secret()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.UserTwoFactor = &fakeUserTwoFactor{twoFactor: models.UserTwoFactor{
+		UserID:    userID,
+		Secret:    encryptedSecret,
+		IsEnabled: true,
+	}}
+
+	svc := NewAuthenticationService(cfg, logrus.NewEntry(log), repo, twoFactorSvc, nil)
+
+	return svc, repo, userID, key.Secret()
+}
+
+func TestTwoFactorCodeCanNotBeReused(t *testing.T) {
+	ctx := context.Background()
+	svc, _, userID, secret := newTestTwoFactorServices(t, 5)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.twoFactor.ValidateTwoFactorCode(ctx, userID, code); err != nil {
+		t.Fatalf("ValidateTwoFactorCode: %v", err)
+	}
+
+	if err := svc.twoFactor.ValidateTwoFactorCode(ctx, userID, code); !errors.Is(err, ErrWrongTwoFactorCode) {
+		t.Errorf("ValidateTwoFactorCode of reused code returned %v, want %v", err, ErrWrongTwoFactorCode)
+	}
+
+	// code of earlier step within skew is valid by time, but its step is already passed
+	previousCode, err := totp.GenerateCode(secret, time.Now().Add(-totpPeriod*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if previousCode != code {
+		if err := svc.twoFactor.ValidateTwoFactorCode(ctx, userID, previousCode); !errors.Is(err, ErrWrongTwoFactorCode) {
+			t.Errorf("ValidateTwoFactorCode of previous step code returned %v, want %v", err, ErrWrongTwoFactorCode)
+		}
+	}
+}
+
+func TestTwoFactorChallengeIsInvalidatedAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	svc, repo, userID, secret := newTestTwoFactorServices(t, 3)
+
+	repo.VerificationCache.(*fakeVerificationCache).putChallengeToken("challenge", userID)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.AuthenticateUserByTwoFactorCode(ctx, "challenge", wrongCode, "fingerprint", "127.0.0.1")
+		if !errors.Is(err, ErrWrongTwoFactorCode) {
+			t.Fatalf("attempt %d returned %v, want %v", i+1, err, ErrWrongTwoFactorCode)
+		}
+	}
+
+	// right code does not help after challenge is invalidated
+	_, err = svc.AuthenticateUserByTwoFactorCode(ctx, "challenge", code, "fingerprint", "127.0.0.1")
+	if !errors.Is(err, ErrTwoFactorChallengeNotFound) {
+		t.Errorf("attempt after max attempts returned %v, want %v", err, ErrTwoFactorChallengeNotFound)
+	}
+}
+
+func TestTwoFactorChallengeAcceptsRightCodeWithinAttempts(t *testing.T) {
+	ctx := context.Background()
+	svc, repo, userID, secret := newTestTwoFactorServices(t, 3)
+
+	repo.VerificationCache.(*fakeVerificationCache).putChallengeToken("challenge", userID)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotID, err := svc.AuthenticateUserByTwoFactorCode(ctx, "challenge", code, "fingerprint", "127.0.0.1")
+	if err != nil || gotID != userID {
+		t.Fatalf("AuthenticateUserByTwoFactorCode returned %d, %v, want %d", gotID, err, userID)
+	}
+
+	// challenge is completed, so it can not be used again
+	_, err = svc.AuthenticateUserByTwoFactorCode(ctx, "challenge", code, "fingerprint", "127.0.0.1")
+	if !errors.Is(err, ErrTwoFactorChallengeNotFound) {
+		t.Errorf("completed challenge returned %v, want %v", err, ErrTwoFactorChallengeNotFound)
+	}
+}
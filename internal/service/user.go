@@ -2,10 +2,12 @@ package service
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	ierrors "github.com/l-orlov/matcha/internal/errors"
 	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
 	"github.com/l-orlov/matcha/internal/repository"
 	"github.com/pkg/errors"
 )
@@ -15,21 +17,29 @@ var (
 	ErrUsernameIsTaken = errors.New("username is already taken")
 	ErrEmailIsTaken    = errors.New("user with this email already exists")
 	ErrWrongPassword   = errors.New("wrong password")
+	ErrNotValidState   = errors.New("not valid user state")
 )
 
 type (
 	UserService struct {
 		repo                repository.User
+		txManager           repository.TxManager
+		sessionCache        repository.SessionCache
 		accessTokenLifetime time.Duration
+		paginator           *pagination.Paginator
 	}
 )
 
 func NewUserService(
-	repo repository.User, tokenLifetime time.Duration,
+	repo repository.User, txManager repository.TxManager, sessionCache repository.SessionCache,
+	tokenLifetime time.Duration, paginator *pagination.Paginator,
 ) *UserService {
 	return &UserService{
 		repo:                repo,
+		txManager:           txManager,
+		sessionCache:        sessionCache,
 		accessTokenLifetime: tokenLifetime,
+		paginator:           paginator,
 	}
 }
 
@@ -62,6 +72,29 @@ func (s *UserService) CreateUser(ctx context.Context, user models.UserToCreate)
 	return s.repo.CreateUser(ctx, user)
 }
 
+// CreateAdmin creates user with confirmed email and admin role.
+func (s *UserService) CreateAdmin(ctx context.Context, user models.UserToCreate) (uint64, error) {
+	var id uint64
+	err := s.txManager.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = s.CreateUser(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		if err := s.repo.ConfirmEmail(ctx, id); err != nil {
+			return err
+		}
+
+		return s.repo.AddUserRole(ctx, id, models.RoleAdmin)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
 func (s *UserService) GetUserByID(ctx context.Context, id uint64) (*models.User, error) {
 	return s.repo.GetUserByID(ctx, id)
 }
@@ -70,6 +103,10 @@ func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*models
 	return s.repo.GetUserByEmail(ctx, email)
 }
 
+func (s *UserService) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return s.repo.GetUserByUsername(ctx, username)
+}
+
 func (s *UserService) UpdateUser(ctx context.Context, user models.User) error {
 	return s.repo.UpdateUser(ctx, user)
 }
@@ -83,6 +120,19 @@ func (s *UserService) SetUserPassword(ctx context.Context, userID uint64, passwo
 	return s.repo.UpdateUserPassword(ctx, userID, hashedPassword)
 }
 
+// ResetUserPassword sets new password and revokes all user sessions.
+func (s *UserService) ResetUserPassword(ctx context.Context, userID uint64, password string) error {
+	if err := s.SetUserPassword(ctx, userID, password); err != nil {
+		return err
+	}
+
+	if err := s.sessionCache.DeleteUserSessions(strconv.FormatUint(userID, 10)); err != nil {
+		return errors.Wrap(err, "failed to revoke user sessions")
+	}
+
+	return nil
+}
+
 func (s *UserService) ChangeUserPassword(ctx context.Context, userID uint64, oldPassword, newPassword string) error {
 	user, err := s.repo.GetUserByID(ctx, userID)
 	if err != nil {
@@ -105,8 +155,30 @@ func (s *UserService) ChangeUserPassword(ctx context.Context, userID uint64, old
 	return s.repo.UpdateUserPassword(ctx, userID, hashedPassword)
 }
 
-func (s *UserService) GetAllUsers(ctx context.Context) ([]models.User, error) {
-	return s.repo.GetAllUsers(ctx)
+// GetAllUsers returns requested page of users in order of ids and cursor of the next page.
+func (s *UserService) GetAllUsers(ctx context.Context, req pagination.Request) ([]models.User, string, error) {
+	var afterID uint64
+	_, limit, err := decodePageRequest(s.paginator, usersList, req, &afterID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// one more user is requested to know if there is the next page
+	users, err := s.repo.GetAllUsers(ctx, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(users) > limit {
+		users = users[:limit]
+		nextCursor, err = s.paginator.EncodeCursor(usersList, users[limit-1].ID)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return users, nextCursor, nil
 }
 
 func (s *UserService) DeleteUser(ctx context.Context, id uint64) error {
@@ -116,3 +188,19 @@ func (s *UserService) DeleteUser(ctx context.Context, id uint64) error {
 func (s *UserService) ConfirmEmail(ctx context.Context, id uint64) error {
 	return s.repo.ConfirmEmail(ctx, id)
 }
+
+// SetUserState sets state of user account. Deleted state is set only by account deletion.
+func (s *UserService) SetUserState(ctx context.Context, userID uint64, state string) error {
+	switch state {
+	case models.UserStateActive, models.UserStateDeactivated, models.UserStateHidden:
+	default:
+		return ierrors.NewBusiness(ErrNotValidState, "")
+	}
+
+	return s.repo.UpdateUserState(ctx, userID, state)
+}
+
+// ReactivateUser makes deactivated or deleted user active. It returns false if user was already active.
+func (s *UserService) ReactivateUser(ctx context.Context, userID uint64) (bool, error) {
+	return s.repo.ReactivateUser(ctx, userID)
+}
@@ -4,50 +4,80 @@ import (
 	"bytes"
 	"context"
 	"io"
-	"strings"
+	"net/http"
 	"text/template"
 	"time"
+	"unicode/utf8"
 
-	"github.com/gabriel-vasile/mimetype"
 	"github.com/google/uuid"
 	"github.com/l-orlov/matcha/internal/config"
 	ierrors "github.com/l-orlov/matcha/internal/errors"
 	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
 	"github.com/l-orlov/matcha/internal/repository"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	matchaBucketName  = "matcha"
-	pictureURLExpires = 3 * time.Hour
-)
+const maxPictureCaptionLength = 300
 
-var ErrUserPictureNotFound = errors.New("user picture not found")
+var (
+	ErrUserPictureNotFound   = errors.New("user picture not found")
+	ErrNotValidPicturesOrder = errors.New("pictures order must contain every user picture exactly once")
+	ErrPictureUploadNotFound = errors.New("picture upload not found")
+	ErrPictureCaptionTooLong = errors.Errorf("picture caption must not be longer than %d characters", maxPictureCaptionLength)
+	ErrPictureRejected       = errors.New("picture is rejected by moderation")
+)
 
 type (
 	UserProfileService struct {
 		log                *logrus.Entry
 		maxUserPicturesNum int
 		pathTemplates      config.FilePathTemplates
+		uploadCfg          config.PictureUpload
+		bucket             string
 		repo               *repository.Repository
+		processor          *PictureProcessor
+		pictureURL         *PictureURLService
+		moderator          PictureModerator
+		duplicatesCfg      config.PictureDuplicates
+		paginator          *pagination.Paginator
 	}
 )
 
 func NewUserProfileService(
-	log *logrus.Entry, maxUserPicturesNum int,
-	pathTemplates config.FilePathTemplates, repo *repository.Repository,
+	log *logrus.Entry, maxUserPicturesNum int, pathTemplates config.FilePathTemplates,
+	uploadCfg config.PictureUpload, bucket string, repo *repository.Repository, processor *PictureProcessor,
+	pictureURL *PictureURLService, moderator PictureModerator, duplicatesCfg config.PictureDuplicates,
+	paginator *pagination.Paginator,
 ) *UserProfileService {
 	return &UserProfileService{
 		log:                log,
 		maxUserPicturesNum: maxUserPicturesNum,
 		pathTemplates:      pathTemplates,
+		uploadCfg:          uploadCfg,
+		bucket:             bucket,
 		repo:               repo,
+		processor:          processor,
+		pictureURL:         pictureURL,
+		moderator:          moderator,
+		duplicatesCfg:      duplicatesCfg,
+		paginator:          paginator,
 	}
 }
 
-func (s *UserProfileService) GetUserProfileByID(ctx context.Context, id uint64) (*models.UserProfile, error) {
-	profile, err := s.repo.User.GetUserProfileByID(ctx, id)
+// GetUserProfileByID returns profile of user for viewer. Other users can not view deactivated profiles
+// and pictures which are not approved by moderation.
+func (s *UserProfileService) GetUserProfileByID(
+	ctx context.Context, viewerID, id uint64,
+) (*models.UserProfile, error) {
+	var profile *models.UserProfile
+	var err error
+	if viewerID == id {
+		profile, err = s.repo.User.GetUserProfileByID(ctx, id)
+	} else {
+		profile, err = s.repo.User.GetVisibleUserProfileByID(ctx, id)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -56,17 +86,27 @@ func (s *UserProfileService) GetUserProfileByID(ctx context.Context, id uint64)
 		return nil, nil
 	}
 
+	if viewerID != id && profile.AvatarModeration != models.PictureModerationApproved {
+		profile.AvatarPath = ""
+	}
+
 	if profile.AvatarPath != "" {
-		profile.AvatarURL = s.getPictureURLByPath(ctx, profile.AvatarPath)
+		profile.AvatarURL = s.pictureURL.GetPictureURL(ctx, profile.AvatarPath, profile.AvatarContentHash)
+		profile.AvatarVariantURLs = s.getPictureVariantURLs(ctx, profile.AvatarVariants, profile.AvatarContentHash)
 	}
 
-	pictures, err := s.repo.UserPictures.GetUserPicturesByUserID(ctx, profile.ID)
+	pictures, err := s.repo.UserPictures.GetUserPicturesByUserID(ctx, profile.ID, nil, 0)
 	if err != nil {
 		return nil, err
 	}
 
+	if viewerID != id {
+		pictures = approvedPictures(pictures)
+	}
+
 	for i := range pictures {
-		pictures[i].PictureURL = s.getPictureURLByPath(ctx, pictures[i].PicturePath)
+		pictures[i].PictureURL = s.pictureURL.GetPictureURL(ctx, pictures[i].PicturePath, pictures[i].ContentHash)
+		pictures[i].VariantURLs = s.getPictureVariantURLs(ctx, pictures[i].Variants, pictures[i].ContentHash)
 	}
 
 	profile.Pictures = pictures
@@ -88,61 +128,222 @@ func (s *UserProfileService) UploadUserAvatar(ctx context.Context, userID uint64
 		return ierrors.NewBusiness(ErrUserNotFound, "")
 	}
 
-	mime, err := mimetype.DetectReader(file)
+	picture, err := s.processor.Process(file)
 	if err != nil {
 		return err
 	}
-	if !strings.HasPrefix(mime.String(), "image/") {
-		return ierrors.NewBusiness(errors.Errorf("avatar file %s is not an image", mime.String()), "")
-	}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
+	moderation, err := s.moderatePicture(ctx, picture)
+	if err != nil {
 		return err
 	}
 
-	path, err := prepareFilePath(s.pathTemplates.UserAvatar, map[string]interface{}{"UserID": userID})
+	path, err := s.newUserAvatarPath(ctx, userID)
 	if err != nil {
 		return err
 	}
 
-	if err := s.repo.Storage.PutFile(ctx, matchaBucketName, path, mime.String(), file); err != nil {
+	variants, err := putProcessedPicture(ctx, s.repo.Storage, s.bucket, path, picture)
+	if err != nil {
+		s.discardFiles(ctx, path)
 		return err
 	}
 
-	if err := s.repo.User.UpdateUserAvatarPath(ctx, userID, path); err != nil {
+	if err := s.replaceUserAvatar(ctx, userID, models.UserAvatar{
+		Path:           path,
+		Variants:       variants,
+		ContentHash:    picture.ContentHash,
+		Moderation:     moderation,
+		PerceptualHash: int64(picture.PerceptualHash),
+	}); err != nil {
 		return err
 	}
 
+	s.detectPictureDuplicates(ctx, userID, path, int64(picture.PerceptualHash))
+
 	return nil
 }
 
 func (s *UserProfileService) DeleteUserAvatar(ctx context.Context, userID uint64) error {
-	user, err := s.repo.User.GetUserByID(ctx, userID)
+	return s.replaceUserAvatar(ctx, userID, models.UserAvatar{
+		Moderation: models.PictureModerationApproved,
+	})
+}
+
+// newUserAvatarPath returns path for objects of new avatar. Every avatar has own path, so objects
+// of avatar which is replaced or rejected meanwhile can be deleted without touching new ones.
+// Outbox record makes reconciler delete objects if process fails before avatar row refers to them.
+func (s *UserProfileService) newUserAvatarPath(ctx context.Context, userID uint64) (string, error) {
+	path, err := prepareFilePath(s.pathTemplates.UserAvatar, map[string]interface{}{
+		"UserID": userID,
+		"UUID":   uuid.New(),
+	})
 	if err != nil {
+		return "", err
+	}
+
+	if err := s.repo.StorageOutbox.AddStorageOutboxRecord(ctx, path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// replaceUserAvatar sets avatar of user and deletes objects of previous one. Avatar without path removes it.
+// Outbox record of new avatar is deleted with row update, so its objects are kept from then on.
+func (s *UserProfileService) replaceUserAvatar(ctx context.Context, userID uint64, avatar models.UserAvatar) error {
+	var previous models.UserAvatar
+	if err := s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		locked, err := s.repo.User.LockUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if !locked {
+			return ierrors.NewBusiness(ErrUserNotFound, "")
+		}
+
+		profile, err := s.repo.User.GetUserProfileByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if profile == nil {
+			return ierrors.NewBusiness(ErrUserNotFound, "")
+		}
+
+		previous.Path, previous.Variants = profile.AvatarPath, profile.AvatarVariants
+
+		if err := s.repo.User.UpdateUserAvatar(ctx, userID, avatar); err != nil {
+			return err
+		}
+
+		if avatar.Path == "" {
+			return nil
+		}
+
+		return s.repo.StorageOutbox.DeleteStorageOutboxRecord(ctx, avatar.Path)
+	}); err != nil {
+		s.discardFiles(ctx, avatar.Path)
 		return err
 	}
 
-	if user == nil {
-		return ierrors.NewBusiness(ErrUserNotFound, "")
+	if previous.Path != "" {
+		if err := s.deletePicture(ctx, previous.Path, previous.Variants); err != nil {
+			s.log.Error(errors.Wrapf(err, "failed to delete previous avatar %s", previous.Path))
+		}
+	}
+
+	return nil
+}
+
+func (s *UserProfileService) UploadUserPicture(
+	ctx context.Context, userID uint64, file io.ReadSeeker, caption string,
+) error {
+	if err := s.checkPictureUpload(ctx, userID, caption); err != nil {
+		return err
+	}
+
+	picture, err := s.processor.Process(file)
+	if err != nil {
+		return err
+	}
+
+	return s.createUserPicture(ctx, userID, uuid.New(), picture, caption)
+}
+
+// CreatePictureUploadURL issues presigned form for uploading picture directly to storage.
+// Form accepts only file of requested content type and size. Uploaded file is registered as user picture by FinalizePictureUpload.
+func (s *UserProfileService) CreatePictureUploadURL(
+	ctx context.Context, userID uint64, request models.PictureUploadRequest,
+) (*models.PictureUpload, error) {
+	if err := s.checkPictureUpload(ctx, userID, ""); err != nil {
+		return nil, err
+	}
+
+	if err := s.processor.CheckUpload(request.ContentType, request.Size); err != nil {
+		return nil, err
+	}
+
+	uploadUUID := uuid.New()
+	path, err := prepareFilePath(s.pathTemplates.UserPictureUpload, map[string]interface{}{
+		"UserID": userID,
+		"UUID":   uploadUUID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// outbox record makes reconciler delete upload if it is not finalized
+	if err := s.repo.StorageOutbox.AddStorageOutboxRecord(ctx, path); err != nil {
+		return nil, err
+	}
+
+	expires := s.uploadCfg.URLLifetime.Duration()
+	upload, err := s.repo.Storage.GetFileUpload(ctx, s.bucket, path, request.ContentType, request.Size, expires)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PictureUpload{
+		UUID:      uploadUUID,
+		URL:       upload.URL,
+		Method:    http.MethodPost,
+		Fields:    upload.Fields,
+		FileField: models.StorageUploadFileField,
+		ExpiresAt: time.Now().Add(expires),
+	}, nil
+}
+
+// FinalizePictureUpload validates and processes file uploaded by presigned URL and registers it as user picture.
+// Uploaded file is deleted in any case, so upload can not be finalized twice. Picture gets new UUID,
+// as upload UUID comes from client and reusing it would overwrite objects of existing picture.
+func (s *UserProfileService) FinalizePictureUpload(
+	ctx context.Context, userID uint64, finalization models.PictureUploadFinalization,
+) error {
+	if err := s.checkPictureUpload(ctx, userID, finalization.Caption); err != nil {
+		return err
+	}
+
+	path, err := prepareFilePath(s.pathTemplates.UserPictureUpload, map[string]interface{}{
+		"UserID": userID,
+		"UUID":   finalization.UUID,
+	})
+	if err != nil {
+		return err
 	}
 
-	path, err := prepareFilePath(s.pathTemplates.UserAvatar, map[string]interface{}{"UserID": userID})
+	object, err := s.repo.Storage.GetFileInfo(ctx, s.bucket, path)
 	if err != nil {
 		return err
 	}
 
-	if err := s.repo.Storage.DeleteFile(ctx, matchaBucketName, path); err != nil {
+	if object == nil {
+		return ierrors.NewBusiness(ErrPictureUploadNotFound, "")
+	}
+	defer s.discardFiles(ctx, path)
+
+	// storage enforces constraints of upload form, the check guards against files put other way
+	if err := s.processor.CheckUpload(object.ContentType, object.Size); err != nil {
 		return err
 	}
 
-	if err := s.repo.User.UpdateUserAvatarPath(ctx, userID, ""); err != nil {
+	data, err := s.repo.Storage.GetFile(ctx, s.bucket, path)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	picture, err := s.processor.Process(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	return s.createUserPicture(ctx, userID, uuid.New(), picture, finalization.Caption)
 }
 
-func (s *UserProfileService) UploadUserPicture(ctx context.Context, userID uint64, file io.ReadSeeker) error {
+// checkPictureUpload checks that user can upload one more picture. It is done before processing
+// to not do useless work, limit is enforced on creation.
+func (s *UserProfileService) checkPictureUpload(ctx context.Context, userID uint64, caption string) error {
 	user, err := s.repo.User.GetUserByID(ctx, userID)
 	if err != nil {
 		return err
@@ -152,32 +353,30 @@ func (s *UserProfileService) UploadUserPicture(ctx context.Context, userID uint6
 		return ierrors.NewBusiness(ErrUserNotFound, "")
 	}
 
-	userPictures, err := s.repo.UserPictures.GetUserPicturesByUserID(ctx, userID)
+	if utf8.RuneCountInString(caption) > maxPictureCaptionLength {
+		return ierrors.NewBusiness(ErrPictureCaptionTooLong, "")
+	}
+
+	userPictures, err := s.repo.UserPictures.GetUserPicturesByUserID(ctx, userID, nil, 0)
 	if err != nil {
 		return err
 	}
 
 	if len(userPictures) >= s.maxUserPicturesNum {
-		return ierrors.NewBusiness(
-			errors.Errorf("can not upload more than %d pictures", s.maxUserPicturesNum), "",
-		)
+		return s.newPicturesLimitError()
 	}
 
-	mime, err := mimetype.DetectReader(file)
-	if err != nil {
-		return err
-	}
-	if !strings.HasPrefix(mime.String(), "image/") {
-		return ierrors.NewBusiness(
-			errors.Errorf("picture file %s is not an image", mime.String()), "",
-		)
-	}
+	return nil
+}
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
+func (s *UserProfileService) createUserPicture(
+	ctx context.Context, userID uint64, pictureUUID uuid.UUID, picture *ProcessedPicture, caption string,
+) error {
+	moderation, err := s.moderatePicture(ctx, picture)
+	if err != nil {
 		return err
 	}
 
-	pictureUUID := uuid.New()
 	path, err := prepareFilePath(s.pathTemplates.UserPicture, map[string]interface{}{
 		"UserID": userID,
 		"UUID":   pictureUUID,
@@ -186,57 +385,336 @@ func (s *UserProfileService) UploadUserPicture(ctx context.Context, userID uint6
 		return err
 	}
 
-	if err := s.repo.Storage.PutFile(ctx, matchaBucketName, path, mime.String(), file); err != nil {
+	// outbox record makes reconciler delete objects if process fails before picture row is created
+	if err := s.repo.StorageOutbox.AddStorageOutboxRecord(ctx, path); err != nil {
 		return err
 	}
 
-	if err := s.repo.UserPictures.CreateUserPicture(ctx, models.UserPicture{
-		UUID:        pictureUUID,
-		UserID:      userID,
-		PicturePath: path,
-	}); err != nil {
+	variants, err := putProcessedPicture(ctx, s.repo.Storage, s.bucket, path, picture)
+	if err != nil {
+		s.discardFiles(ctx, path)
 		return err
 	}
 
+	created, err := s.repo.UserPictures.CreateUserPicture(ctx, models.UserPicture{
+		UUID:           pictureUUID,
+		UserID:         userID,
+		PicturePath:    path,
+		Variants:       variants,
+		ContentHash:    picture.ContentHash,
+		PerceptualHash: int64(picture.PerceptualHash),
+		Caption:        caption,
+		Moderation:     moderation,
+	}, s.maxUserPicturesNum)
+	if err != nil {
+		s.discardFiles(ctx, path)
+		return err
+	}
+
+	if !created {
+		s.discardFiles(ctx, path)
+		return s.newPicturesLimitError()
+	}
+
+	s.detectPictureDuplicates(ctx, userID, path, int64(picture.PerceptualHash))
+
 	return nil
 }
 
-func (s *UserProfileService) GetUserPicturesByUserID(ctx context.Context, userID uint64) ([]models.UserPicture, error) {
-	pictures, err := s.repo.UserPictures.GetUserPicturesByUserID(ctx, userID)
+// GetUserPicturesByUserID returns requested page of user pictures in order of positions
+// and cursor of the next page.
+func (s *UserProfileService) GetUserPicturesByUserID(
+	ctx context.Context, userID uint64, req pagination.Request,
+) ([]models.UserPicture, string, error) {
+	var key models.UserPictureKey
+	ok, limit, err := decodePageRequest(s.paginator, userPicturesList, req, &key)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	var after *models.UserPictureKey
+	if ok {
+		after = &key
+	}
+
+	// one more picture is requested to know if there is the next page
+	pictures, err := s.repo.UserPictures.GetUserPicturesByUserID(ctx, userID, after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(pictures) > limit {
+		pictures = pictures[:limit]
+		last := pictures[limit-1]
+		nextCursor, err = s.paginator.EncodeCursor(userPicturesList, models.UserPictureKey{
+			Position: last.Position,
+			UUID:     last.UUID,
+		})
+		if err != nil {
+			return nil, "", err
+		}
 	}
 
 	for i := range pictures {
-		pictures[i].PictureURL = s.getPictureURLByPath(ctx, pictures[i].PicturePath)
+		pictures[i].PictureURL = s.pictureURL.GetPictureURL(ctx, pictures[i].PicturePath, pictures[i].ContentHash)
+		pictures[i].VariantURLs = s.getPictureVariantURLs(ctx, pictures[i].Variants, pictures[i].ContentHash)
 	}
 
-	return pictures, nil
+	return pictures, nextCursor, nil
 }
 
-func (s *UserProfileService) DeleteUserPicture(ctx context.Context, uuid uuid.UUID) error {
+// DeleteUserPicture deletes picture of user. Picture row is deleted before objects. If deleting objects fails,
+// they are deleted by reconciler using outbox record added with row deletion.
+func (s *UserProfileService) DeleteUserPicture(ctx context.Context, userID uint64, uuid uuid.UUID) error {
 	userPicture, err := s.repo.UserPictures.GetUserPictureByUUID(ctx, uuid)
 	if err != nil {
 		return err
 	}
 
-	if userPicture == nil {
+	if userPicture == nil || userPicture.UserID != userID {
 		return ierrors.NewBusiness(ErrUserPictureNotFound, "")
 	}
 
-	path, err := prepareFilePath(s.pathTemplates.UserPicture, map[string]interface{}{
-		"UserID": userPicture.UserID,
-		"UUID":   userPicture.UUID,
-	})
+	deleted, err := s.repo.UserPictures.DeleteUserPictureOfUser(ctx, userID, uuid)
 	if err != nil {
 		return err
 	}
 
-	if err := s.repo.Storage.DeleteFile(ctx, matchaBucketName, path); err != nil {
+	if !deleted {
+		return ierrors.NewBusiness(ErrUserPictureNotFound, "")
+	}
+
+	s.discardFiles(ctx, userPicture.PicturePath)
+
+	return nil
+}
+
+// ReorderUserPictures sets order of user pictures. It must contain all user pictures.
+func (s *UserProfileService) ReorderUserPictures(ctx context.Context, userID uint64, uuids []uuid.UUID) error {
+	seen := make(map[uuid.UUID]struct{}, len(uuids))
+	for _, pictureUUID := range uuids {
+		if _, ok := seen[pictureUUID]; ok {
+			return ierrors.NewBusiness(ErrNotValidPicturesOrder, "")
+		}
+
+		seen[pictureUUID] = struct{}{}
+	}
+
+	reordered, err := s.repo.UserPictures.ReorderUserPictures(ctx, userID, uuids)
+	if err != nil {
 		return err
 	}
 
-	return s.repo.UserPictures.DeleteUserPicture(ctx, uuid)
+	if !reordered {
+		return ierrors.NewBusiness(ErrNotValidPicturesOrder, "")
+	}
+
+	return nil
+}
+
+func (s *UserProfileService) UpdateUserPictureCaption(
+	ctx context.Context, userID uint64, uuid uuid.UUID, caption string,
+) error {
+	if utf8.RuneCountInString(caption) > maxPictureCaptionLength {
+		return ierrors.NewBusiness(ErrPictureCaptionTooLong, "")
+	}
+
+	updated, err := s.repo.UserPictures.UpdateUserPictureCaption(ctx, userID, uuid, caption)
+	if err != nil {
+		return err
+	}
+
+	if !updated {
+		return ierrors.NewBusiness(ErrUserPictureNotFound, "")
+	}
+
+	return nil
+}
+
+// PromoteUserPictureToAvatar makes avatar from user picture. Picture objects are copied on storage side,
+// so avatar and picture can be deleted independently.
+func (s *UserProfileService) PromoteUserPictureToAvatar(ctx context.Context, userID uint64, uuid uuid.UUID) error {
+	userPicture, err := s.repo.UserPictures.GetUserPictureByUUID(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if userPicture == nil || userPicture.UserID != userID {
+		return ierrors.NewBusiness(ErrUserPictureNotFound, "")
+	}
+
+	path, err := s.newUserAvatarPath(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Storage.CopyFile(ctx, s.bucket, userPicture.PicturePath, path); err != nil {
+		s.discardFiles(ctx, path)
+		return err
+	}
+
+	variants := make(models.PictureVariants, len(userPicture.Variants))
+	for name, pictureVariantPath := range userPicture.Variants {
+		variantPath := path + "_" + name
+		if err := s.repo.Storage.CopyFile(ctx, s.bucket, pictureVariantPath, variantPath); err != nil {
+			s.discardFiles(ctx, path)
+			return err
+		}
+
+		variants[name] = variantPath
+	}
+
+	return s.replaceUserAvatar(ctx, userID, models.UserAvatar{
+		Path:           path,
+		Variants:       variants,
+		ContentHash:    userPicture.ContentHash,
+		Moderation:     userPicture.Moderation,
+		PerceptualHash: userPicture.PerceptualHash,
+	})
+}
+
+// moderatePicture returns moderation status of picture. Rejected picture is not stored at all.
+// If moderator fails, picture is sent to review, so upload does not depend on moderator availability.
+func (s *UserProfileService) moderatePicture(ctx context.Context, picture *ProcessedPicture) (string, error) {
+	verdict, err := s.moderator.ModeratePicture(ctx, picture.Original)
+	if err != nil {
+		s.log.Error(errors.Wrap(err, "failed to moderate picture, it is sent to review"))
+		return models.PictureModerationPending, nil
+	}
+
+	switch verdict {
+	case ModerationApprove:
+		return models.PictureModerationApproved, nil
+	case ModerationReject:
+		return "", ierrors.NewBusiness(ErrPictureRejected, "")
+	default:
+		return models.PictureModerationPending, nil
+	}
+}
+
+// detectPictureDuplicates saves matches of user picture with pictures of other users which are its
+// near-duplicates. Errors are only logged, because matches are only signals for moderators.
+func (s *UserProfileService) detectPictureDuplicates(ctx context.Context, userID uint64, path string, hash int64) {
+	// hash of uniform picture is zero, such pictures are not distinctive
+	if hash == 0 {
+		return
+	}
+
+	candidates, err := s.repo.PictureMatches.GetPictureHashCandidates(ctx, userID, hash, s.duplicatesCfg.CandidatesLimit)
+	if err != nil {
+		s.log.Error(errors.Wrapf(err, "failed to get duplicate candidates of picture %s", path))
+		return
+	}
+
+	var matches []models.PictureMatch
+	for i := range candidates {
+		distance := pictureHashDistance(uint64(hash), uint64(candidates[i].PerceptualHash))
+		if distance > s.duplicatesCfg.MaxDistance {
+			continue
+		}
+
+		matches = append(matches, models.PictureMatch{
+			UserID:             userID,
+			PicturePath:        path,
+			MatchedUserID:      candidates[i].UserID,
+			MatchedPicturePath: candidates[i].PicturePath,
+			Distance:           distance,
+		})
+	}
+
+	if len(matches) == 0 {
+		return
+	}
+
+	if err := s.repo.PictureMatches.AddPictureMatches(ctx, matches); err != nil {
+		s.log.Error(errors.Wrapf(err, "failed to save duplicates of picture %s", path))
+	}
+}
+
+func (s *UserProfileService) newPicturesLimitError() error {
+	return ierrors.NewBusiness(
+		errors.Errorf("can not upload more than %d pictures", s.maxUserPicturesNum), "",
+	)
+}
+
+// putProcessedPicture puts picture to storage by path and its variants next to it.
+func putProcessedPicture(
+	ctx context.Context, storage repository.Storage, bucket, path string, picture *ProcessedPicture,
+) (models.PictureVariants, error) {
+	if err := storage.PutFile(
+		ctx, bucket, path, pictureContentType, bytes.NewReader(picture.Original),
+	); err != nil {
+		return nil, err
+	}
+
+	variants := make(models.PictureVariants, len(picture.Variants))
+	for name, data := range picture.Variants {
+		variantPath := path + "_" + name
+		if err := storage.PutFile(
+			ctx, bucket, variantPath, pictureContentType, bytes.NewReader(data),
+		); err != nil {
+			return nil, err
+		}
+
+		variants[name] = variantPath
+	}
+
+	return variants, nil
+}
+
+func (s *UserProfileService) deletePicture(ctx context.Context, path string, variants models.PictureVariants) error {
+	if err := s.repo.Storage.DeleteFile(ctx, s.bucket, path); err != nil {
+		return err
+	}
+
+	for _, variantPath := range variants {
+		if err := s.repo.Storage.DeleteFile(ctx, s.bucket, variantPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discardFiles deletes files by prefix, e.g. picture with its variants, and then its storage outbox record.
+// Errors are only logged, because outbox record is kept for reconciler in this case.
+func (s *UserProfileService) discardFiles(ctx context.Context, path string) {
+	// empty prefix would match all files
+	if path == "" {
+		return
+	}
+
+	if err := s.repo.Storage.DeleteFilesByPrefix(ctx, s.bucket, path); err != nil {
+		s.log.Error(errors.Wrapf(err, "failed to delete files %s, left for reconciler", path))
+		return
+	}
+
+	if err := s.repo.StorageOutbox.DeleteStorageOutboxRecord(ctx, path); err != nil {
+		s.log.Error(errors.Wrapf(err, "failed to delete storage outbox record %s", path))
+	}
+}
+
+func (s *UserProfileService) getPictureVariantURLs(
+	ctx context.Context, variants models.PictureVariants, contentHash string,
+) map[string]string {
+	urls := make(map[string]string, len(variants))
+	for name, path := range variants {
+		urls[name] = s.pictureURL.GetPictureURL(ctx, path, contentHash)
+	}
+
+	return urls
+}
+
+func approvedPictures(pictures []models.UserPicture) []models.UserPicture {
+	approved := pictures[:0]
+	for i := range pictures {
+		if pictures[i].Moderation == models.PictureModerationApproved {
+			approved = append(approved, pictures[i])
+		}
+	}
+
+	return approved
 }
 
 func prepareFilePath(pathTemplate string, pathParams map[string]interface{}) (string, error) {
@@ -252,13 +730,3 @@ func prepareFilePath(pathTemplate string, pathParams map[string]interface{}) (st
 
 	return buf.String(), nil
 }
-
-func (s *UserProfileService) getPictureURLByPath(ctx context.Context, path string) string {
-	url, err := s.repo.Storage.GetFileURL(ctx, matchaBucketName, path, pictureURLExpires)
-	if err != nil {
-		s.log.Errorf("failed to get picture URL by path %s: %v", path, err)
-		return ""
-	}
-
-	return url
-}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUserAvatarNotFound   = errors.New("user avatar not found or replaced")
+	ErrPictureMatchNotFound = errors.New("picture match not found")
+)
+
+// GetPendingUserPictures returns requested page of pictures waiting for moderation, the oldest first,
+// and cursor of the next page.
+func (s *UserProfileService) GetPendingUserPictures(
+	ctx context.Context, req pagination.Request,
+) ([]models.UserPicture, string, error) {
+	var key models.PendingPictureKey
+	ok, limit, err := decodePageRequest(s.paginator, pendingPicturesList, req, &key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var after *models.PendingPictureKey
+	if ok {
+		after = &key
+	}
+
+	// one more picture is requested to know if there is the next page
+	pictures, err := s.repo.UserPictures.GetPendingUserPictures(ctx, after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(pictures) > limit {
+		pictures = pictures[:limit]
+		last := pictures[limit-1]
+		nextCursor, err = s.paginator.EncodeCursor(pendingPicturesList, models.PendingPictureKey{
+			CreatedAt: last.CreatedAt,
+			UUID:      last.UUID,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	for i := range pictures {
+		pictures[i].PictureURL = s.pictureURL.GetPictureURL(ctx, pictures[i].PicturePath, pictures[i].ContentHash)
+		pictures[i].VariantURLs = s.getPictureVariantURLs(ctx, pictures[i].Variants, pictures[i].ContentHash)
+	}
+
+	return pictures, nextCursor, nil
+}
+
+func (s *UserProfileService) ApproveUserPicture(ctx context.Context, uuid uuid.UUID) error {
+	approved, err := s.repo.UserPictures.SetUserPictureModeration(ctx, uuid, models.PictureModerationApproved)
+	if err != nil {
+		return err
+	}
+
+	if !approved {
+		return ierrors.NewBusiness(ErrUserPictureNotFound, "")
+	}
+
+	return nil
+}
+
+// RejectUserPicture deletes picture of any user with its objects.
+func (s *UserProfileService) RejectUserPicture(ctx context.Context, uuid uuid.UUID) error {
+	userPicture, err := s.repo.UserPictures.GetUserPictureByUUID(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	if userPicture == nil {
+		return ierrors.NewBusiness(ErrUserPictureNotFound, "")
+	}
+
+	if err := s.repo.UserPictures.DeleteUserPicture(ctx, uuid); err != nil {
+		return err
+	}
+
+	s.discardFiles(ctx, userPicture.PicturePath)
+
+	return nil
+}
+
+// GetPendingUserAvatars returns requested page of avatars waiting for moderation and cursor of the next page.
+func (s *UserProfileService) GetPendingUserAvatars(
+	ctx context.Context, req pagination.Request,
+) ([]models.UserAvatar, string, error) {
+	var afterID uint64
+	_, limit, err := decodePageRequest(s.paginator, pendingAvatarsList, req, &afterID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// one more avatar is requested to know if there is the next page
+	avatars, err := s.repo.User.GetPendingUserAvatars(ctx, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(avatars) > limit {
+		avatars = avatars[:limit]
+		nextCursor, err = s.paginator.EncodeCursor(pendingAvatarsList, avatars[limit-1].UserID)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	for i := range avatars {
+		avatars[i].URL = s.pictureURL.GetPictureURL(ctx, avatars[i].Path, avatars[i].ContentHash)
+		avatars[i].VariantURLs = s.getPictureVariantURLs(ctx, avatars[i].Variants, avatars[i].ContentHash)
+	}
+
+	return avatars, nextCursor, nil
+}
+
+func (s *UserProfileService) ApproveUserAvatar(ctx context.Context, review models.UserAvatarReview) error {
+	approved, err := s.repo.User.SetUserAvatarModeration(
+		ctx, review.UserID, review.ContentHash, models.PictureModerationApproved,
+	)
+	if err != nil {
+		return err
+	}
+
+	if !approved {
+		return ierrors.NewBusiness(ErrUserAvatarNotFound, "")
+	}
+
+	return nil
+}
+
+// RejectUserAvatar removes avatar from profile and deletes its objects. User row is locked while avatar
+// is checked and removed, so avatar uploaded by user meanwhile is not removed instead of reviewed one.
+// Objects are deleted after commit by path of reviewed avatar, which is not reused by new avatars.
+func (s *UserProfileService) RejectUserAvatar(ctx context.Context, review models.UserAvatarReview) error {
+	var avatar models.UserAvatar
+	if err := s.repo.WithinTransaction(ctx, func(ctx context.Context) error {
+		locked, err := s.repo.User.LockUser(ctx, review.UserID)
+		if err != nil {
+			return err
+		}
+
+		if !locked {
+			return ierrors.NewBusiness(ErrUserAvatarNotFound, "")
+		}
+
+		profile, err := s.repo.User.GetUserProfileByID(ctx, review.UserID)
+		if err != nil {
+			return err
+		}
+
+		if profile == nil || profile.AvatarPath == "" || profile.AvatarContentHash != review.ContentHash {
+			return ierrors.NewBusiness(ErrUserAvatarNotFound, "")
+		}
+
+		avatar.Path, avatar.Variants = profile.AvatarPath, profile.AvatarVariants
+
+		return s.repo.User.UpdateUserAvatar(ctx, review.UserID, models.UserAvatar{
+			Moderation: models.PictureModerationApproved,
+		})
+	}); err != nil {
+		return err
+	}
+
+	return s.deletePicture(ctx, avatar.Path, avatar.Variants)
+}
+
+// GetPictureMatches returns requested page of matches of pictures with pictures of other users,
+// the newest first, and cursor of the next page. Matches are signals of fake accounts which reuse photos.
+func (s *UserProfileService) GetPictureMatches(
+	ctx context.Context, req pagination.Request,
+) ([]models.PictureMatch, string, error) {
+	var key models.PictureMatchKey
+	ok, limit, err := decodePageRequest(s.paginator, pictureMatchesList, req, &key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var after *models.PictureMatchKey
+	if ok {
+		after = &key
+	}
+
+	// one more match is requested to know if there is the next page
+	matches, err := s.repo.PictureMatches.GetPictureMatches(ctx, after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(matches) > limit {
+		matches = matches[:limit]
+		last := matches[limit-1]
+		nextCursor, err = s.paginator.EncodeCursor(pictureMatchesList, models.PictureMatchKey{
+			CreatedAt: last.CreatedAt,
+			ID:        last.ID,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// content hash is not kept in match, so URLs are not keyed by content
+	for i := range matches {
+		matches[i].PictureURL = s.pictureURL.GetPictureURL(ctx, matches[i].PicturePath, "")
+		matches[i].MatchedPictureURL = s.pictureURL.GetPictureURL(ctx, matches[i].MatchedPicturePath, "")
+	}
+
+	return matches, nextCursor, nil
+}
+
+// DismissPictureMatch deletes match which moderator checked.
+func (s *UserProfileService) DismissPictureMatch(ctx context.Context, id uint64) error {
+	deleted, err := s.repo.PictureMatches.DeletePictureMatch(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return ierrors.NewBusiness(ErrPictureMatchNotFound, "")
+	}
+
+	return nil
+}
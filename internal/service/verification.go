@@ -13,6 +13,9 @@ const (
 
 	emailConfirmationTokenPrefix       = "ec"
 	passwordResetConfirmTokenKeyPrefix = "rpc"
+	twoFactorChallengeTokenPrefix      = "tfc"
+	magicLinkTokenPrefix               = "ml"
+	loginAlertTokenPrefix              = "la"
 )
 
 type (
@@ -91,6 +94,77 @@ func (s *VerificationService) VerifyPasswordResetConfirmToken(confirmToken strin
 	return userID, nil
 }
 
+func (s *VerificationService) CreateTwoFactorChallengeToken(userID uint64) (string, error) {
+	token, err := s.generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	challengeToken := twoFactorChallengeTokenPrefix + token
+
+	err = s.repo.PutTwoFactorChallengeToken(userID, challengeToken)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to put two-factor challenge token to cache")
+	}
+
+	return challengeToken, nil
+}
+
+func (s *VerificationService) CreateMagicLinkToken(userID uint64) (string, error) {
+	token, err := s.generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	magicLinkToken := magicLinkTokenPrefix + token
+
+	err = s.repo.PutMagicLinkToken(userID, magicLinkToken)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to put magic link token to cache")
+	}
+
+	return magicLinkToken, nil
+}
+
+func (s *VerificationService) VerifyMagicLinkToken(magicLinkToken string) (userID uint64, err error) {
+	// token is deleted together with reading, so it can be used only once even by concurrent requests
+	userID, err = s.repo.ConsumeMagicLinkToken(magicLinkToken)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to consume magic link token from cache")
+	}
+
+	return userID, nil
+}
+
+func (s *VerificationService) CreateLoginAlertToken(userID uint64) (string, error) {
+	token, err := s.generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	loginAlertToken := loginAlertTokenPrefix + token
+
+	err = s.repo.PutLoginAlertToken(userID, loginAlertToken)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to put login alert token to cache")
+	}
+
+	return loginAlertToken, nil
+}
+
+func (s *VerificationService) VerifyLoginAlertToken(loginAlertToken string) (userID uint64, err error) {
+	userID, err = s.repo.GetLoginAlertTokenData(loginAlertToken)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get login alert token data from cache")
+	}
+
+	if err := s.repo.DeleteLoginAlertToken(loginAlertToken); err != nil {
+		return 0, errors.Wrap(err, "failed to delete login alert token from cache")
+	}
+
+	return userID, nil
+}
+
 func (s *VerificationService) generateRandomToken() (string, error) {
 	randomToken, err := s.generator.Generate(
 		randomTokenLength, randomTokenDigitsNum, randomTokenSymbolsNum, false, false,
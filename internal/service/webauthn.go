@@ -0,0 +1,391 @@
+package service
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gomodule/redigo/redis"
+	"github.com/l-orlov/matcha/internal/config"
+	ierrors "github.com/l-orlov/matcha/internal/errors"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	webAuthnSessionIDLength = 32
+
+	webAuthnRegistrationKeyPrefix = "reg:"
+	webAuthnLoginKeyPrefix        = "login:"
+	webAuthnTwoFactorKeyPrefix    = "2fa:"
+)
+
+var (
+	ErrPasskeyNotFound           = errors.New("passkey not found")
+	ErrWebAuthnSessionNotFound   = errors.New("passkey ceremony not found or expired")
+	ErrPasskeyVerificationFailed = errors.New("passkey verification failed")
+	ErrPasskeyCloneWarning       = errors.New("passkey sign counter did not increase, authenticator may be cloned")
+)
+
+type (
+	WebAuthnService struct {
+		log       *logrus.Entry
+		repo      *repository.Repository
+		webAuthn  *webauthn.WebAuthn
+		paginator *pagination.Paginator
+	}
+	// webAuthnUser adapts user to interface of webauthn library.
+	webAuthnUser struct {
+		user        *models.User
+		credentials []webauthn.Credential
+	}
+)
+
+func NewWebAuthnService(
+	cfg config.WebAuthn, log *logrus.Entry, repo *repository.Repository, paginator *pagination.Paginator,
+) (*WebAuthnService, error) {
+	w, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: cfg.RPDisplayName,
+		RPID:          cfg.RPID,
+		RPOrigins:     []string{cfg.RPOrigin},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create webauthn")
+	}
+
+	return &WebAuthnService{
+		log:       log,
+		repo:      repo,
+		webAuthn:  w,
+		paginator: paginator,
+	}, nil
+}
+
+func (s *WebAuthnService) BeginPasskeyRegistration(
+	ctx context.Context, userID uint64,
+) (*protocol.CredentialCreation, error) {
+	user, err := s.getWebAuthnUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, protocol.CredentialDescriptor{
+			Type:         protocol.PublicKeyCredentialType,
+			CredentialID: credential.ID,
+		})
+	}
+
+	options, sessionData, err := s.webAuthn.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.putSession(webAuthnRegistrationKeyPrefix+strconv.FormatUint(userID, 10), userID, sessionData); err != nil {
+		return nil, err
+	}
+
+	return options, nil
+}
+
+func (s *WebAuthnService) FinishPasskeyRegistration(ctx context.Context, userID uint64, response io.Reader) error {
+	session, err := s.popSession(webAuthnRegistrationKeyPrefix + strconv.FormatUint(userID, 10))
+	if err != nil {
+		return err
+	}
+
+	user, err := s.getWebAuthnUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	parsedResponse, err := protocol.ParseCredentialCreationResponseBody(response)
+	if err != nil {
+		return ierrors.NewBusiness(errors.Wrap(ErrPasskeyVerificationFailed, protocolErrorDetails(err)), "")
+	}
+
+	credential, err := s.webAuthn.CreateCredential(user, session.Data, parsedResponse)
+	if err != nil {
+		return ierrors.NewBusiness(errors.Wrap(ErrPasskeyVerificationFailed, protocolErrorDetails(err)), "")
+	}
+
+	return s.repo.UserWebAuthn.CreateWebAuthnCredential(ctx, models.WebAuthnCredential{
+		ID:              credential.ID,
+		UserID:          userID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Flags:           int16(credential.Flags.ProtocolValue()),
+	})
+}
+
+// GetPasskeys returns requested page of user passkeys, the oldest first, and cursor of the next page.
+func (s *WebAuthnService) GetPasskeys(
+	ctx context.Context, userID uint64, req pagination.Request,
+) ([]models.WebAuthnCredential, string, error) {
+	var key models.WebAuthnCredentialKey
+	ok, limit, err := decodePageRequest(s.paginator, passkeysList, req, &key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var after *models.WebAuthnCredentialKey
+	if ok {
+		after = &key
+	}
+
+	// one more passkey is requested to know if there is the next page
+	credentials, err := s.repo.UserWebAuthn.GetWebAuthnCredentialsByUserID(ctx, userID, after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(credentials) > limit {
+		credentials = credentials[:limit]
+		last := credentials[limit-1]
+		nextCursor, err = s.paginator.EncodeCursor(passkeysList, models.WebAuthnCredentialKey{
+			CreatedAt: last.CreatedAt,
+			ID:        last.ID,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return credentials, nextCursor, nil
+}
+
+func (s *WebAuthnService) DeletePasskey(ctx context.Context, userID uint64, credentialID []byte) error {
+	return s.repo.UserWebAuthn.DeleteWebAuthnCredential(ctx, userID, credentialID)
+}
+
+func (s *WebAuthnService) HasPasskeys(ctx context.Context, userID uint64) (bool, error) {
+	credentials, err := s.repo.UserWebAuthn.GetWebAuthnCredentialsByUserID(ctx, userID, nil, 1)
+	if err != nil {
+		return false, err
+	}
+
+	return len(credentials) > 0, nil
+}
+
+// BeginPasskeyLogin starts passwordless login. It returns assertion options
+// and id of ceremony that must be passed to FinishPasskeyLogin.
+func (s *WebAuthnService) BeginPasskeyLogin(
+	ctx context.Context, username string,
+) (options *protocol.CredentialAssertion, sessionID string, err error) {
+	user, err := s.repo.User.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if user == nil {
+		return nil, "", ierrors.NewBusiness(ErrUserNotFound, "")
+	}
+
+	sessionID, err = randomURLSafeString(webAuthnSessionIDLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// passkey is the only factor here, so user must be verified by authenticator
+	options, err = s.beginAssertion(ctx, user.ID, webAuthnLoginKeyPrefix+sessionID,
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return options, sessionID, nil
+}
+
+func (s *WebAuthnService) FinishPasskeyLogin(
+	ctx context.Context, sessionID string, response io.Reader,
+) (userID uint64, err error) {
+	return s.finishAssertion(ctx, webAuthnLoginKeyPrefix+sessionID, response)
+}
+
+// BeginPasskeyTwoFactor starts assertion of passkey as second factor of sign in with challenge token.
+func (s *WebAuthnService) BeginPasskeyTwoFactor(
+	ctx context.Context, challengeToken string,
+) (*protocol.CredentialAssertion, error) {
+	userID, err := s.repo.VerificationCache.GetTwoFactorChallengeTokenData(challengeToken)
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return nil, ierrors.NewBusiness(ErrTwoFactorChallengeNotFound, "")
+		}
+
+		return nil, err
+	}
+
+	return s.beginAssertion(ctx, userID, webAuthnTwoFactorKeyPrefix+challengeToken)
+}
+
+func (s *WebAuthnService) FinishPasskeyTwoFactor(
+	ctx context.Context, challengeToken string, response io.Reader,
+) (userID uint64, err error) {
+	return s.finishAssertion(ctx, webAuthnTwoFactorKeyPrefix+challengeToken, response)
+}
+
+func (s *WebAuthnService) beginAssertion(
+	ctx context.Context, userID uint64, sessionKey string, opts ...webauthn.LoginOption,
+) (*protocol.CredentialAssertion, error) {
+	user, err := s.getWebAuthnUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(user.credentials) == 0 {
+		return nil, ierrors.NewBusiness(ErrPasskeyNotFound, "")
+	}
+
+	options, sessionData, err := s.webAuthn.BeginLogin(user, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.putSession(sessionKey, userID, sessionData); err != nil {
+		return nil, err
+	}
+
+	return options, nil
+}
+
+// finishAssertion verifies assertion and updates sign counter of used passkey.
+// Verification errors are ErrPasskeyVerificationFailed, so callers can count them.
+func (s *WebAuthnService) finishAssertion(
+	ctx context.Context, sessionKey string, response io.Reader,
+) (userID uint64, err error) {
+	session, err := s.popSession(sessionKey)
+	if err != nil {
+		return 0, err
+	}
+
+	user, err := s.getWebAuthnUser(ctx, session.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	parsedResponse, err := protocol.ParseCredentialRequestResponseBody(response)
+	if err != nil {
+		return 0, errors.Wrap(ErrPasskeyVerificationFailed, protocolErrorDetails(err))
+	}
+
+	credential, err := s.webAuthn.ValidateLogin(user, session.Data, parsedResponse)
+	if err != nil {
+		return 0, errors.Wrap(ErrPasskeyVerificationFailed, protocolErrorDetails(err))
+	}
+
+	if credential.Authenticator.CloneWarning {
+		return 0, ErrPasskeyCloneWarning
+	}
+
+	if err = s.repo.UserWebAuthn.UpdateWebAuthnCredentialUsage(
+		ctx, credential.ID, credential.Authenticator.SignCount, byte(credential.Flags.ProtocolValue()),
+	); err != nil {
+		return 0, err
+	}
+
+	return session.UserID, nil
+}
+
+func (s *WebAuthnService) getWebAuthnUser(ctx context.Context, userID uint64) (*webAuthnUser, error) {
+	user, err := s.repo.User.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, ierrors.NewBusiness(ErrUserNotFound, "")
+	}
+
+	credentials, err := s.repo.UserWebAuthn.GetWebAuthnCredentialsByUserID(ctx, userID, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	webAuthnCredentials := make([]webauthn.Credential, 0, len(credentials))
+	for _, credential := range credentials {
+		webAuthnCredentials = append(webAuthnCredentials, webauthn.Credential{
+			ID:              credential.ID,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(credential.Flags)),
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    credential.AAGUID,
+				SignCount: credential.SignCount,
+			},
+		})
+	}
+
+	return &webAuthnUser{
+		user:        user,
+		credentials: webAuthnCredentials,
+	}, nil
+}
+
+func (s *WebAuthnService) putSession(key string, userID uint64, sessionData *webauthn.SessionData) error {
+	err := s.repo.VerificationCache.PutWebAuthnSession(key, models.WebAuthnSession{
+		UserID: userID,
+		Data:   *sessionData,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to put webauthn session to cache")
+	}
+
+	return nil
+}
+
+// popSession gets ceremony session and deletes it, so every challenge can be answered only once.
+func (s *WebAuthnService) popSession(key string) (*models.WebAuthnSession, error) {
+	session, err := s.repo.VerificationCache.GetWebAuthnSession(key)
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return nil, ierrors.NewBusiness(ErrWebAuthnSessionNotFound, "")
+		}
+
+		return nil, err
+	}
+
+	if err = s.repo.VerificationCache.DeleteWebAuthnSession(key); err != nil {
+		return nil, errors.Wrap(err, "failed to delete webauthn session from cache")
+	}
+
+	return session, nil
+}
+
+func protocolErrorDetails(err error) string {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) && protocolErr.Details != "" {
+		return protocolErr.Details
+	}
+
+	return err.Error()
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatUint(u.user.ID, 10))
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.FirstName + " " + u.user.LastName
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/l-orlov/matcha/internal/config"
+	"github.com/l-orlov/matcha/internal/models"
+	"github.com/l-orlov/matcha/internal/pagination"
+	"github.com/l-orlov/matcha/internal/repository"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testRPID     = "localhost"
+	testRPOrigin = "http://localhost:8080"
+
+	authenticatorFlagUserPresent    = 0x01
+	authenticatorFlagUserVerified   = 0x04
+	authenticatorFlagBackupEligible = 0x08
+	authenticatorFlagBackupState    = 0x10
+	authenticatorFlagAttestedData   = 0x40
+)
+
+// softAuthenticator is software passkey with P-256 key which makes "none" attestation.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialID := make([]byte, 16)
+	if _, err = rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{
+		key:          key,
+		credentialID: credentialID,
+		flags:        authenticatorFlagUserPresent | authenticatorFlagUserVerified,
+	}
+}
+
+func (a *softAuthenticator) authenticatorData(attestedData []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+
+	data := append([]byte{}, rpIDHash[:]...)
+	if attestedData != nil {
+		data = append(data, a.flags|authenticatorFlagAttestedData)
+	} else {
+		data = append(data, a.flags)
+	}
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	return append(data, attestedData...)
+}
+
+func (a *softAuthenticator) register(t *testing.T, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+
+	publicKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // key type EC2
+		3:  -7, // algorithm ES256
+		-1: 1,  // curve P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attestedData := make([]byte, 16) // zero AAGUID
+	attestedData = binary.BigEndian.AppendUint16(attestedData, uint16(len(a.credentialID)))
+	attestedData = append(attestedData, a.credentialID...)
+	attestedData = append(attestedData, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(attestedData),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encodeBase64URL(clientDataJSON(t, "webauthn.create", challenge)),
+		"attestationObject": encodeBase64URL(attestationObject),
+	})
+}
+
+func (a *softAuthenticator) assert(t *testing.T, challenge protocol.URLEncodedBase64, userHandle []byte) []byte {
+	t.Helper()
+
+	clientData := clientDataJSON(t, "webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	authData := a.authenticatorData(nil)
+
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encodeBase64URL(clientData),
+		"authenticatorData": encodeBase64URL(authData),
+		"signature":         encodeBase64URL(signature),
+		"userHandle":        encodeBase64URL(userHandle),
+	})
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       encodeBase64URL(a.credentialID),
+		"rawId":    encodeBase64URL(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func clientDataJSON(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge.String(),
+		"origin":    testRPOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func encodeBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newTestWebAuthnService(t *testing.T) (*WebAuthnService, *repository.Repository, uint64) {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	repo := newFakeRepository()
+	userID, err := repo.User.CreateUser(context.Background(), models.UserToCreate{
+		Email:     "alice@example.com",
+		Username:  "alice",
+		FirstName: "Alice",
+		LastName:  "Smith",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := NewWebAuthnService(config.WebAuthn{
+		RPDisplayName: "Matcha",
+		RPID:          testRPID,
+		RPOrigin:      testRPOrigin,
+	}, logrus.NewEntry(log), repo, pagination.New(config.Pagination{
+		SignKey:      []byte("test"),
+		DefaultLimit: 10,
+		MaxLimit:     100,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svc, repo, userID
+}
+
+func registerPasskey(t *testing.T, svc *WebAuthnService, userID uint64, authenticator *softAuthenticator) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	options, err := svc.BeginPasskeyRegistration(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := authenticator.register(t, options.Response.Challenge)
+	if err = svc.FinishPasskeyRegistration(ctx, userID, bytes.NewReader(response)); err != nil {
+		t.Fatalf("failed to finish registration: %v", err)
+	}
+}
+
+func beginPasskeyLogin(t *testing.T, svc *WebAuthnService) (protocol.URLEncodedBase64, string) {
+	t.Helper()
+
+	options, sessionID, err := svc.BeginPasskeyLogin(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return options.Response.Challenge, sessionID
+}
+
+func TestWebAuthnService_RegistrationAndLogin(t *testing.T) {
+	ctx := context.Background()
+	svc, repo, userID := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, svc, userID, authenticator)
+
+	credentials, err := repo.UserWebAuthn.GetWebAuthnCredentialsByUserID(ctx, userID, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials) != 1 || !bytes.Equal(credentials[0].ID, authenticator.credentialID) {
+		t.Fatalf("expected registered passkey to be stored, got %v", credentials)
+	}
+
+	challenge, sessionID := beginPasskeyLogin(t, svc)
+	authenticator.signCount++
+	response := authenticator.assert(t, challenge, []byte(strconv.FormatUint(userID, 10)))
+
+	loggedInID, err := svc.FinishPasskeyLogin(ctx, sessionID, bytes.NewReader(response))
+	if err != nil {
+		t.Fatalf("failed to finish login: %v", err)
+	}
+	if loggedInID != userID {
+		t.Fatalf("expected user %d, got %d", userID, loggedInID)
+	}
+
+	credentials, err = repo.UserWebAuthn.GetWebAuthnCredentialsByUserID(ctx, userID, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials[0].SignCount != authenticator.signCount {
+		t.Fatalf("expected sign count %d, got %d", authenticator.signCount, credentials[0].SignCount)
+	}
+}
+
+func TestWebAuthnService_WrongChallenge(t *testing.T) {
+	svc, _, userID := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, svc, userID, authenticator)
+
+	_, sessionID := beginPasskeyLogin(t, svc)
+	authenticator.signCount++
+	response := authenticator.assert(t, protocol.URLEncodedBase64("other challenge"), []byte(strconv.FormatUint(userID, 10)))
+
+	_, err := svc.FinishPasskeyLogin(context.Background(), sessionID, bytes.NewReader(response))
+	if !errors.Is(err, ErrPasskeyVerificationFailed) {
+		t.Fatalf("expected %v, got %v", ErrPasskeyVerificationFailed, err)
+	}
+}
+
+func TestWebAuthnService_SignCountRegression(t *testing.T) {
+	ctx := context.Background()
+	svc, repo, userID := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.signCount = 5
+
+	registerPasskey(t, svc, userID, authenticator)
+
+	// clone of authenticator reports counter which is not greater than the stored one
+	challenge, sessionID := beginPasskeyLogin(t, svc)
+	authenticator.signCount = 3
+	response := authenticator.assert(t, challenge, []byte(strconv.FormatUint(userID, 10)))
+
+	_, err := svc.FinishPasskeyLogin(ctx, sessionID, bytes.NewReader(response))
+	if !errors.Is(err, ErrPasskeyCloneWarning) {
+		t.Fatalf("expected %v, got %v", ErrPasskeyCloneWarning, err)
+	}
+
+	credentials, err := repo.UserWebAuthn.GetWebAuthnCredentialsByUserID(ctx, userID, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials[0].SignCount != 5 {
+		t.Fatalf("expected sign count not to be updated, got %d", credentials[0].SignCount)
+	}
+}
+
+func TestWebAuthnService_ChallengeReuse(t *testing.T) {
+	ctx := context.Background()
+	svc, _, userID := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+
+	registerPasskey(t, svc, userID, authenticator)
+
+	challenge, sessionID := beginPasskeyLogin(t, svc)
+	authenticator.signCount++
+	response := authenticator.assert(t, challenge, []byte(strconv.FormatUint(userID, 10)))
+
+	if _, err := svc.FinishPasskeyLogin(ctx, sessionID, bytes.NewReader(response)); err != nil {
+		t.Fatalf("failed to finish login: %v", err)
+	}
+
+	// replayed assertion is rejected even with increased counter
+	authenticator.signCount++
+	response = authenticator.assert(t, challenge, []byte(strconv.FormatUint(userID, 10)))
+
+	_, err := svc.FinishPasskeyLogin(ctx, sessionID, bytes.NewReader(response))
+	if !errors.Is(err, ErrWebAuthnSessionNotFound) {
+		t.Fatalf("expected %v, got %v", ErrWebAuthnSessionNotFound, err)
+	}
+}
+
+func TestWebAuthnService_RegistrationChallengeReuse(t *testing.T) {
+	ctx := context.Background()
+	svc, _, userID := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+
+	options, err := svc.BeginPasskeyRegistration(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := authenticator.register(t, options.Response.Challenge)
+	if err = svc.FinishPasskeyRegistration(ctx, userID, bytes.NewReader(response)); err != nil {
+		t.Fatalf("failed to finish registration: %v", err)
+	}
+
+	err = svc.FinishPasskeyRegistration(ctx, userID, bytes.NewReader(response))
+	if !errors.Is(err, ErrWebAuthnSessionNotFound) {
+		t.Fatalf("expected %v, got %v", ErrWebAuthnSessionNotFound, err)
+	}
+}
@@ -0,0 +1,14 @@
+CREATE TABLE users_two_factor
+(
+    user_id        BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret         BYTEA       NOT NULL,
+    is_enabled     BOOLEAN     NOT NULL DEFAULT FALSE,
+    recovery_codes TEXT[]      NOT NULL DEFAULT ARRAY []::TEXT[],
+    last_totp_step BIGINT,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON users_two_factor
+    FOR EACH ROW
+    EXECUTE PROCEDURE trigger_set_timestamp();
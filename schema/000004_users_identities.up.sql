@@ -0,0 +1,10 @@
+CREATE TABLE user_identities
+(
+    provider   VARCHAR(50)                                    NOT NULL,
+    subject    VARCHAR(255)                                   NOT NULL,
+    user_id    BIGINT REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    email      VARCHAR(320)                                   NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ                                    NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+CREATE INDEX idx_user_identities_user_id ON user_identities (user_id);
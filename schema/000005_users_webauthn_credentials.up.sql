@@ -0,0 +1,13 @@
+CREATE TABLE users_webauthn_credentials
+(
+    id               BYTEA PRIMARY KEY,
+    user_id          BIGINT REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    public_key       BYTEA                                          NOT NULL,
+    attestation_type VARCHAR(50)                                    NOT NULL DEFAULT '',
+    aaguid           BYTEA                                          NOT NULL,
+    sign_count       BIGINT                                         NOT NULL DEFAULT 0,
+    flags            SMALLINT                                       NOT NULL,
+    created_at       TIMESTAMPTZ                                    NOT NULL DEFAULT NOW(),
+    last_used_at     TIMESTAMPTZ
+);
+CREATE INDEX idx_users_webauthn_credentials_user_id ON users_webauthn_credentials (user_id);
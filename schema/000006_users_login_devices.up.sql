@@ -0,0 +1,11 @@
+CREATE TABLE user_login_devices
+(
+    user_id      BIGINT REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    device_hash  VARCHAR(64)                                    NOT NULL,
+    country      VARCHAR(2)                                     NOT NULL DEFAULT '',
+    user_agent   TEXT                                           NOT NULL DEFAULT '',
+    last_ip      VARCHAR(45)                                    NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ                                    NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ                                    NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, device_hash, country)
+);
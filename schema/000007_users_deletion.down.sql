@@ -0,0 +1,3 @@
+DROP INDEX idx_users_deletion_scheduled_at;
+ALTER TABLE users
+    DROP COLUMN deletion_scheduled_at;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN deletion_scheduled_at TIMESTAMPTZ;
+CREATE INDEX idx_users_deletion_scheduled_at ON users (deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
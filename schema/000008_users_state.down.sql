@@ -0,0 +1,3 @@
+DROP INDEX idx_users_state;
+ALTER TABLE users
+    DROP COLUMN state;
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN state VARCHAR(20) NOT NULL DEFAULT 'active'
+        CHECK (state IN ('active', 'deactivated', 'hidden', 'deleted'));
+UPDATE users SET state = 'deleted' WHERE deletion_scheduled_at IS NOT NULL;
+CREATE INDEX idx_users_state ON users (state);
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP COLUMN avatar_variants;
+ALTER TABLE users_pictures
+    DROP COLUMN variants;
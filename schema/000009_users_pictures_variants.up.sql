@@ -0,0 +1,4 @@
+ALTER TABLE users_pictures
+    ADD COLUMN variants JSONB NOT NULL DEFAULT '{}'::JSONB;
+ALTER TABLE users
+    ADD COLUMN avatar_variants JSONB NOT NULL DEFAULT '{}'::JSONB;
@@ -0,0 +1,3 @@
+ALTER TABLE users_pictures
+    DROP COLUMN position,
+    DROP COLUMN caption;
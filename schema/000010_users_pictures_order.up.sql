@@ -0,0 +1,8 @@
+ALTER TABLE users_pictures
+    ADD COLUMN position INTEGER      NOT NULL DEFAULT 0,
+    ADD COLUMN caption  VARCHAR(300) NOT NULL DEFAULT '';
+UPDATE users_pictures p
+SET position = o.position
+FROM (SELECT uuid, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY created_at) - 1 AS position
+      FROM users_pictures) o
+WHERE p.uuid = o.uuid;
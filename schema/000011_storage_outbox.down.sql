@@ -0,0 +1,2 @@
+DROP INDEX idx_users_pictures_picture_path;
+DROP TABLE storage_outbox;
@@ -0,0 +1,7 @@
+CREATE TABLE storage_outbox
+(
+    object_prefix TEXT PRIMARY KEY,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_storage_outbox_created_at ON storage_outbox (created_at);
+CREATE INDEX idx_users_pictures_picture_path ON users_pictures (picture_path);
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP COLUMN avatar_content_hash;
+ALTER TABLE users_pictures
+    DROP COLUMN content_hash;
@@ -0,0 +1,4 @@
+ALTER TABLE users_pictures
+    ADD COLUMN content_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE users
+    ADD COLUMN avatar_content_hash VARCHAR(64) NOT NULL DEFAULT '';
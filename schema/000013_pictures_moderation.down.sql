@@ -0,0 +1,6 @@
+DROP INDEX idx_users_avatar_pending;
+DROP INDEX idx_users_pictures_pending;
+ALTER TABLE users
+    DROP COLUMN avatar_moderation_status;
+ALTER TABLE users_pictures
+    DROP COLUMN moderation_status;
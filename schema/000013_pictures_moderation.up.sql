@@ -0,0 +1,6 @@
+ALTER TABLE users_pictures
+    ADD COLUMN moderation_status VARCHAR(20) NOT NULL DEFAULT 'approved';
+ALTER TABLE users
+    ADD COLUMN avatar_moderation_status VARCHAR(20) NOT NULL DEFAULT 'approved';
+CREATE INDEX idx_users_pictures_pending ON users_pictures (created_at) WHERE moderation_status = 'pending';
+CREATE INDEX idx_users_avatar_pending ON users (id) WHERE avatar_moderation_status = 'pending';
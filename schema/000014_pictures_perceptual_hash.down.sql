@@ -0,0 +1,13 @@
+DROP TABLE users_pictures_matches;
+DROP INDEX idx_users_avatar_phash_band3;
+DROP INDEX idx_users_avatar_phash_band2;
+DROP INDEX idx_users_avatar_phash_band1;
+DROP INDEX idx_users_avatar_phash_band0;
+DROP INDEX idx_users_pictures_phash_band3;
+DROP INDEX idx_users_pictures_phash_band2;
+DROP INDEX idx_users_pictures_phash_band1;
+DROP INDEX idx_users_pictures_phash_band0;
+ALTER TABLE users
+    DROP COLUMN avatar_perceptual_hash;
+ALTER TABLE users_pictures
+    DROP COLUMN perceptual_hash;
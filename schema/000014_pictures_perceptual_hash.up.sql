@@ -0,0 +1,25 @@
+ALTER TABLE users_pictures
+    ADD COLUMN perceptual_hash BIGINT;
+ALTER TABLE users
+    ADD COLUMN avatar_perceptual_hash BIGINT;
+-- hash is split into four 16-bit bands: hashes which differ in less than 4 bits have at least one equal band
+CREATE INDEX idx_users_pictures_phash_band0 ON users_pictures (((perceptual_hash >> 48) & 65535));
+CREATE INDEX idx_users_pictures_phash_band1 ON users_pictures (((perceptual_hash >> 32) & 65535));
+CREATE INDEX idx_users_pictures_phash_band2 ON users_pictures (((perceptual_hash >> 16) & 65535));
+CREATE INDEX idx_users_pictures_phash_band3 ON users_pictures ((perceptual_hash & 65535));
+CREATE INDEX idx_users_avatar_phash_band0 ON users (((avatar_perceptual_hash >> 48) & 65535));
+CREATE INDEX idx_users_avatar_phash_band1 ON users (((avatar_perceptual_hash >> 32) & 65535));
+CREATE INDEX idx_users_avatar_phash_band2 ON users (((avatar_perceptual_hash >> 16) & 65535));
+CREATE INDEX idx_users_avatar_phash_band3 ON users ((avatar_perceptual_hash & 65535));
+CREATE TABLE users_pictures_matches
+(
+    id                   BIGSERIAL PRIMARY KEY,
+    user_id              BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    picture_path         TEXT        NOT NULL,
+    matched_user_id      BIGINT      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    matched_picture_path TEXT        NOT NULL,
+    distance             INTEGER     NOT NULL,
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (picture_path, matched_picture_path)
+);
+CREATE INDEX idx_users_pictures_matches_created_at ON users_pictures_matches (created_at);
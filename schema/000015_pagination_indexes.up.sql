@@ -0,0 +1,8 @@
+DROP INDEX idx_users_pictures_pending;
+CREATE INDEX idx_users_pictures_pending ON users_pictures (created_at, uuid) WHERE moderation_status = 'pending';
+DROP INDEX idx_users_pictures_user_id;
+CREATE INDEX idx_users_pictures_user_id_position ON users_pictures (user_id, position, uuid);
+DROP INDEX idx_users_pictures_matches_created_at;
+CREATE INDEX idx_users_pictures_matches_created_at ON users_pictures_matches (created_at, id);
+DROP INDEX idx_users_webauthn_credentials_user_id;
+CREATE INDEX idx_users_webauthn_credentials_user_id_created_at ON users_webauthn_credentials (user_id, created_at, id);
@@ -0,0 +1,9 @@
+// Package schema embeds database migrations, so binary applies them without files on disk.
+package schema
+
+import "embed"
+
+// Migrations are migration files of golang-migrate format in root directory.
+//
+//go:embed *.sql
+var Migrations embed.FS